@@ -13,18 +13,21 @@ import (
 	"strconv"
 	"strings"
 
+	"koushoku/cache"
 	. "koushoku/config"
 	"koushoku/server"
 	"koushoku/services"
 )
 
 func main() {
+	cache.Init()
 	server.Init()
 
 	server.GET("/archive/:id/:slug/download", download)
 	server.HEAD("/archive/:id/:slug/download", download)
 	server.GET("/data/:id/:pageNum", serve)
 	server.GET("/data/:id/:pageNum/*width", serve)
+	server.GET("/profiles/:file", serveProfileImage)
 
 	server.NoRoute(func(c *server.Context) {
 		c.Redirect(http.StatusFound, Config.Meta.BaseURL)
@@ -68,6 +71,25 @@ func download(c *server.Context) {
 		return
 	}
 
+	// Only the GET requests are downloads, and a download
+	// resumed with a range is only counted once.
+	if r := c.GetHeader("Range"); c.Request.Method == http.MethodGet &&
+		(len(r) == 0 || strings.HasPrefix(r, "bytes=0-")) {
+		services.RecordArchiveDownload(id)
+	}
+	http.ServeFile(c.Writer, c.Request, fp)
+}
+
+// serveProfileImage serves the avatar or cover image of a profile.
+// The file name changes whenever the image is replaced.
+func serveProfileImage(c *server.Context) {
+	fp := filepath.Join(Config.Directories.Profiles, filepath.Base(c.Param("file")))
+	if _, err := os.Stat(fp); err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
 	http.ServeFile(c.Writer, c.Request, fp)
 }
 
@@ -102,7 +124,13 @@ func serve(c *server.Context) {
 		return
 	}
 
+	// The pages are numbered from 0 by the OPDS-PSE clients,
+	// which are given links with base=0.
 	pageNum := services.GetPageNum(c.Param("pageNum"))
+	if c.Query("base") == "0" {
+		pageNum++
+	}
+
 	if pageNum <= 0 {
 		c.Status(http.StatusBadRequest)
 		return
@@ -148,7 +176,7 @@ func serve(c *server.Context) {
 	}
 
 	index := pageNum - 1
-	if index > len(files) {
+	if index >= len(files) {
 		c.Status(http.StatusNotFound)
 		return
 	}
@@ -12,18 +12,21 @@ import (
 	. "koushoku/config"
 )
 
-type ApiOptions struct {
-	ApiKey string `json:"key"`
-}
-
 type PurgeCacheOptions struct {
-	ApiOptions
 	Archives    bool `json:"archives,omitempty"`
 	Taxonomies  bool `json:"taxonomies,omitempty"`
 	Templates   bool `json:"templates,omitempty"`
 	Submissions bool `json:"submissions,omitempty"`
 }
 
+type ReloadListsOptions struct {
+	Aliases    bool `json:"aliases,omitempty"`
+	Blacklists bool `json:"blacklists,omitempty"`
+	Metadatas  bool `json:"metadatas,omitempty"`
+
+	TaxonomyKinds bool `json:"taxonomyKinds,omitempty"`
+}
+
 var ports []int
 
 func scanPorts(startPort, endPort int) {
@@ -49,9 +52,10 @@ func scanPorts(startPort, endPort int) {
 	}
 }
 
-func purgeCaches(startPort, endPort int, opts PurgeCacheOptions) {
+// postAdmin posts the options to the route of the admin API of
+// every running web server, authorized with the API key.
+func postAdmin(startPort, endPort int, path string, opts any) {
 	scanPorts(startPort, endPort)
-	opts.ApiKey = Config.HTTP.ApiKey
 
 	buf, err := json.Marshal(opts)
 	if err != nil {
@@ -59,54 +63,37 @@ func purgeCaches(startPort, endPort int, opts PurgeCacheOptions) {
 	}
 
 	for _, port := range ports {
-		req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:%d/api/purge-cache", port), bytes.NewBuffer(buf))
+		req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:%d/api/admin%s", port, path), bytes.NewBuffer(buf))
 		if err != nil {
 			log.Fatalln(err)
 		}
 
+		req.Header.Set("Authorization", "Bearer "+Config.HTTP.ApiKey)
 		req.Header.Set("Content-Type", "application/json")
 		client := &http.Client{}
 		res, err := client.Do(req)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer res.Body.Close()
+		res.Body.Close()
 
-		if res.StatusCode == 200 {
-			log.Printf("Purged caches on port %d\n", port)
-		} else {
-			log.Fatalf("Failed to purge archives cache: %s", res.Status)
+		if res.StatusCode >= 300 {
+			log.Fatalf("Failed to post %s on port %d: %s", path, port, res.Status)
 		}
 	}
 }
 
-func reloadTemplates(startPort, endPort int) {
-	scanPorts(startPort, endPort)
-	opts := ApiOptions{ApiKey: Config.HTTP.ApiKey}
-
-	buf, err := json.Marshal(opts)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	for _, port := range ports {
-		req, err := http.NewRequest("POST", fmt.Sprintf("http://localhost:%d/api/reload-templates", port), bytes.NewBuffer(buf))
-		if err != nil {
-			log.Fatalln(err)
-		}
+func purgeCaches(startPort, endPort int, opts PurgeCacheOptions) {
+	postAdmin(startPort, endPort, "/caches/purge", opts)
+	log.Println("Purged caches")
+}
 
-		req.Header.Set("Content-Type", "application/json")
-		client := &http.Client{}
-		res, err := client.Do(req)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer res.Body.Close()
+func reloadTemplates(startPort, endPort int) {
+	postAdmin(startPort, endPort, "/templates/reload", nil)
+	log.Println("Reloaded templates")
+}
 
-		if res.StatusCode == 200 {
-			log.Printf("Reloaded templates on port %d\n", port)
-		} else {
-			log.Fatalf("Failed to reload templates: %s", res.Status)
-		}
-	}
+func reloadLists(startPort, endPort int, opts ReloadListsOptions) {
+	postAdmin(startPort, endPort, "/lists/reload", opts)
+	log.Println("Reloaded lists")
 }
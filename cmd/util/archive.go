@@ -43,13 +43,11 @@ func getArchivePaths() (paths []string, err error) {
 	return paths, filepath.Walk(Config.Directories.Data, walkFn)
 }
 
-func populateArchive(archive *modext.Archive) error {
+// parseArchiveName populates the taxonomies of an archive
+// from its file name and metadata, and returns its title.
+func parseArchiveName(archive *modext.Archive) (title, titleSlug string) {
+	aliases := GetAliases()
 	fileName := FileName(archive.Path)
-	if stat, err := os.Stat(archive.Path); err == nil {
-		archive.Size = stat.Size()
-	} else {
-		return err
-	}
 
 	var (
 		artists   = make(map[string]string)
@@ -59,10 +57,10 @@ func populateArchive(archive *modext.Archive) error {
 		tags      = make(map[string]string)
 	)
 
-	if metadata, ok := Metadatas.Map[Slugify(fileName)]; ok {
+	if metadata, ok := GetMetadatas().Map[Slugify(fileName)]; ok {
 		for _, parody := range metadata.Parodies {
 			slug := Slugify(parody)
-			if v, ok := Aliases.ParodyMatches[slug]; ok {
+			if v, ok := aliases.ParodyMatches[slug]; ok {
 				slug = Slugify(v)
 				parody = v
 			}
@@ -70,24 +68,35 @@ func populateArchive(archive *modext.Archive) error {
 		}
 
 		for _, tag := range metadata.Tags {
-			slug := Slugify(tag)
-			if v, ok := Aliases.TagMatches[slug]; ok {
+			slug := TagSlug(tag)
+			if v, ok := aliases.TagMatches[slug]; ok {
 				tag = v
-				slug = Slugify(v)
-			}
-			if _, ok := Blacklists.TagMatches[slug]; ok {
-				return nil
+				slug = TagSlug(v)
 			}
 			tags[slug] = tag
 		}
+
+		kinds := GetTaxonomyKinds()
+		for kind, values := range metadata.Taxonomies {
+			if !kinds.IsCustom(kind) {
+				continue
+			}
+
+			if archive.Taxonomies == nil {
+				archive.Taxonomies = make(map[string][]*modext.Taxonomy)
+			}
+			for _, v := range values {
+				archive.Taxonomies[kind] = append(archive.Taxonomies[kind],
+					&modext.Taxonomy{Kind: kind, Slug: Slugify(v), Name: v})
+			}
+		}
 	}
 
 	matches := archiveRgx.FindAllString(fileName, -1)
 	if len(matches) == 0 {
-		return nil
+		return
 	}
 
-	var title string
 	for i, match := range matches {
 		match = strings.TrimSpace(match)
 		if len(match) == 0 {
@@ -177,7 +186,7 @@ func populateArchive(archive *modext.Archive) error {
 			for _, name := range names {
 				name = strings.TrimSpace(name)
 				if len(name) > 0 {
-					tags[Slugify(name)] = strings.ReplaceAll(name, "-", " ")
+					tags[TagSlug(name)] = strings.ReplaceAll(name, "-", " ")
 				}
 			}
 		} else if i == 1 || i == 2 {
@@ -189,92 +198,97 @@ func populateArchive(archive *modext.Archive) error {
 	}
 
 	if len(title) == 0 {
-		return nil
+		return
 	}
 
-	titleSlug := Slugify(title)
-	if v, ok := Aliases.ArchiveMatches[titleSlug]; ok {
+	titleSlug = Slugify(title)
+	if v, ok := aliases.ArchiveMatches[titleSlug]; ok {
 		titleSlug = Slugify(title)
 		title = v
 	}
 
-	if _, ok := Blacklists.ArchiveMatches[titleSlug]; ok {
-		return nil
-	}
-
-	for _, v := range Blacklists.ArchiveWildcards {
-		if strings.Contains(titleSlug, v) {
-			return nil
-		}
-	}
-
 	for slug, artist := range artists {
-		if v, ok := Aliases.ArtistMatches[slug]; ok {
+		if v, ok := aliases.ArtistMatches[slug]; ok {
 			slug = Slugify(v)
 			artist = v
 		}
-		if _, ok := Blacklists.ArtistMatches[slug]; ok {
-			return nil
-		}
 		archive.Artists = append(archive.Artists,
-			&modext.Artist{Slug: slug, Name: artist})
+			&modext.Taxonomy{Kind: "artist", Slug: slug, Name: artist})
 	}
 
 	for slug, circle := range circles {
-		if v, ok := Aliases.CircleMatches[slug]; ok {
+		if v, ok := aliases.CircleMatches[slug]; ok {
 			slug = Slugify(v)
 			circle = v
 		}
-		if _, ok := Blacklists.CircleMatches[slug]; ok {
-			return nil
-		}
 		archive.Circles = append(archive.Circles,
-			&modext.Circle{Slug: slug, Name: circle})
+			&modext.Taxonomy{Kind: "circle", Slug: slug, Name: circle})
 	}
 
 	for slug, magazine := range magazines {
-		if v, ok := Aliases.MagazineMatches[slug]; ok {
+		if v, ok := aliases.MagazineMatches[slug]; ok {
 			slug = Slugify(v)
 			magazine = v
 		}
-		if _, ok := Blacklists.MagazineMatches[slug]; ok {
-			return nil
-		}
 		archive.Magazines = append(archive.Magazines,
-			&modext.Magazine{Slug: slug, Name: magazine})
+			&modext.Taxonomy{Kind: "magazine", Slug: slug, Name: magazine})
 	}
 
 	for slug, parody := range parodies {
-		if v, ok := Aliases.ParodyMatches[slug]; ok {
+		if v, ok := aliases.ParodyMatches[slug]; ok {
 			slug = Slugify(v)
 			parody = v
 		}
 		archive.Parodies = append(archive.Parodies,
-			&modext.Parody{Slug: slug, Name: parody})
+			&modext.Taxonomy{Kind: "parody", Slug: slug, Name: parody})
 	}
 
+	names := make([]string, 0, len(tags))
 	for slug, tag := range tags {
-		if v, ok := Aliases.TagMatches[slug]; ok {
-			slug = Slugify(v)
+		if v, ok := aliases.TagMatches[slug]; ok {
 			tag = v
 		}
-		if _, ok := Blacklists.TagMatches[slug]; ok {
-			return nil
-		}
+		names = append(names, tag)
+	}
 
+	for _, tag := range GetImplications().Apply(names) {
+		slug := TagSlug(tag)
 		isDuplicate := false
 		for _, t := range archive.Tags {
-			if slug == Slugify(t.Name) {
+			if slug == t.Slug {
 				isDuplicate = true
 				break
 			}
 		}
 
 		if !isDuplicate {
+			namespace, name := ParseTagName(tag)
 			archive.Tags = append(archive.Tags,
-				&modext.Tag{Slug: slug, Name: tag})
+				&modext.Taxonomy{Kind: "tag", Slug: slug, Namespace: namespace, Name: name})
 		}
 	}
+	return
+}
+
+func populateArchive(archive *modext.Archive) error {
+	if stat, err := os.Stat(archive.Path); err == nil {
+		archive.Size = stat.Size()
+	} else {
+		return err
+	}
+
+	title, titleSlug := parseArchiveName(archive)
+	if len(title) == 0 {
+		return nil
+	}
+
+	subject := *archive
+	subject.Title, subject.Slug = title, titleSlug
+	if match := GetBlacklists().Match(&subject); match != nil {
+		log.Printf("Archive %s is blacklisted by rule %d (%s)\n",
+			filepath.Base(archive.Path), match.ID, match.Rule)
+		return nil
+	}
 
 	zf, err := zip.OpenReader(archive.Path)
 	if err != nil {
@@ -310,52 +324,6 @@ func populateArchive(archive *modext.Archive) error {
 	return nil
 }
 
-func moderateArchives() {
-	InitBlacklists()
-
-	archives, err := models.Archives(Load(ArchiveRels.Artists), Load(ArchiveRels.Tags)).AllG()
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	for _, archive := range archives {
-		titleSlug := Slugify(archive.Title)
-		_, isRemove := Blacklists.ArchiveMatches[titleSlug]
-
-		if archive.R != nil && len(archive.R.Artists) > 0 {
-			for _, artist := range archive.R.Artists {
-				if _, ok := Blacklists.ArtistMatches[Slugify(artist.Name)]; ok {
-					artist.DeleteG()
-					isRemove = true
-				}
-			}
-		}
-
-		if !isRemove {
-			for _, slug := range Blacklists.ArchiveWildcards {
-				if strings.Contains(titleSlug, slug) {
-					isRemove = true
-					break
-				}
-			}
-		}
-
-		if !isRemove && archive.R != nil && len(archive.R.Tags) > 0 {
-			for _, tag := range archive.R.Tags {
-				if _, ok := Blacklists.TagMatches[Slugify(tag.Name)]; ok {
-					tag.DeleteG()
-					isRemove = true
-				}
-			}
-		}
-
-		if isRemove {
-			log.Println("Removing archive", archive.Path)
-			DeleteArchive(archive.ID)
-		}
-	}
-}
-
 func indexArchive(path string, reindex bool) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return
@@ -394,6 +362,8 @@ func indexArchive(path string, reindex bool) {
 
 func indexArchives(reindex bool) {
 	InitAliases()
+	InitImplications()
+	InitTaxonomyKinds()
 	InitBlacklists()
 	InitMetadatas()
 
@@ -719,3 +689,45 @@ func purgeSymlinks() {
 		log.Fatalln(err)
 	}
 }
+
+func testBlacklist(path string) {
+	InitAliases()
+	InitImplications()
+	InitTaxonomyKinds()
+	InitBlacklists()
+	InitMetadatas()
+
+	archive := &modext.Archive{Path: path}
+	archive.Title, archive.Slug = parseArchiveName(archive)
+
+	fmt.Println("Path:", archive.Path)
+	fmt.Println("Title:", archive.Title)
+	for _, v := range archive.Artists {
+		fmt.Println("Artist:", v.Name)
+	}
+	for _, v := range archive.Circles {
+		fmt.Println("Circle:", v.Name)
+	}
+	for _, v := range archive.Magazines {
+		fmt.Println("Magazine:", v.Name)
+	}
+	for _, v := range archive.Parodies {
+		fmt.Println("Parody:", v.Name)
+	}
+	for _, v := range archive.Tags {
+		fmt.Println("Tag:", v.Name)
+	}
+
+	matches := GetBlacklists().MatchAll(archive)
+	if len(matches) == 0 {
+		fmt.Println("Not blacklisted")
+		return
+	}
+
+	for _, match := range matches {
+		fmt.Printf("Blacklisted by rule %d: %s\n", match.ID, match.Rule)
+		for _, reason := range match.Reasons {
+			fmt.Println("  -", reason)
+		}
+	}
+}
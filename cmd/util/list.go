@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	. "koushoku/config"
+	. "koushoku/services"
+)
+
+func importLists() {
+	n, err := ImportAliases(Config.Paths.Alias)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Imported %d aliases from %s\n", n, Config.Paths.Alias)
+
+	n, err = ImportBlacklistRules(Config.Paths.Blacklist)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("Imported %d blacklist rules from %s\n", n, Config.Paths.Blacklist)
+}
+
+func printAliases(kind string) {
+	aliases, err := ListAliases(kind)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, alias := range aliases {
+		fmt.Printf("%d: %s:%s:%s\n", alias.ID, alias.Kind, alias.Name, alias.Target)
+	}
+}
+
+func addAliases(lines []string) {
+	for _, line := range lines {
+		kind, name, target, ok := ParseAlias(line)
+		if !ok {
+			log.Fatalf("Invalid alias %q, expected kind:name:target\n", line)
+		}
+
+		alias, err := CreateAlias(kind, name, target)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Added alias %d: %s:%s:%s\n", alias.ID, alias.Kind, alias.Name, alias.Target)
+	}
+}
+
+func removeAliases(ids []int64) {
+	for _, id := range ids {
+		if err := DeleteAlias(id); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("Removed alias", id)
+	}
+}
+
+func printBlacklist(kind string) {
+	rules, err := ListBlacklistRules(kind)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, rule := range rules {
+		fmt.Printf("%d: %s:%s\n", rule.ID, rule.Kind, rule.Value)
+	}
+}
+
+func addBlacklist(lines []string) {
+	for _, line := range lines {
+		kind, value, ok := ParseBlacklistRule(line)
+		if !ok {
+			log.Fatalf("Invalid blacklist rule %q, expected kind:value\n", line)
+		}
+
+		rule, err := CreateBlacklistRule(kind, value)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Added blacklist rule %d: %s:%s\n", rule.ID, rule.Kind, rule.Value)
+	}
+}
+
+func removeBlacklist(ids []int64) {
+	for _, id := range ids {
+		if err := DeleteBlacklistRule(id); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("Removed blacklist rule", id)
+	}
+}
+
+func printImplications() {
+	implications, err := ListTagImplications()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, v := range implications {
+		fmt.Printf("%d: %s => %s\n", v.ID, v.Name, v.Implied)
+	}
+}
+
+func addImplications(lines []string) {
+	for _, line := range lines {
+		tag, implied, ok := ParseTagImplication(line)
+		if !ok {
+			log.Fatalf("Invalid tag implication %q, expected tag => implied\n", line)
+		}
+
+		v, err := CreateTagImplication(tag, implied)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Added tag implication %d: %s => %s\n", v.ID, v.Name, v.Implied)
+	}
+}
+
+func removeImplications(ids []int64) {
+	for _, id := range ids {
+		if err := DeleteTagImplication(id); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("Removed tag implication", id)
+	}
+}
+
+func printTaxonomyKinds() {
+	InitTaxonomyKinds()
+	for _, kind := range GetTaxonomyKinds().Kinds {
+		if kind.Builtin {
+			fmt.Printf("%s:%s:%s (built in)\n", kind.Kind, kind.Name, kind.Plural)
+		} else {
+			fmt.Printf("%s:%s:%s (/%s)\n", kind.Kind, kind.Name, kind.Plural, kind.Route)
+		}
+	}
+}
+
+func addTaxonomyKinds(lines []string) {
+	for _, line := range lines {
+		strs := strings.Split(line, ":")
+		if len(strs) < 2 {
+			log.Fatalf("Invalid taxonomy kind %q, expected kind:name:plural\n", line)
+		}
+
+		var plural string
+		if len(strs) > 2 {
+			plural = strs[2]
+		}
+
+		kind, err := CreateTaxonomyKind(strs[0], strs[1], plural)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Added taxonomy kind %s:%s:%s\n", kind.Kind, kind.Name, kind.Plural)
+	}
+}
+
+func removeTaxonomyKinds(kinds []string) {
+	for _, kind := range kinds {
+		if err := DeleteTaxonomyKind(kind); err != nil {
+			log.Fatalln(err)
+		}
+		log.Println("Removed taxonomy kind", kind)
+	}
+}
+
+func printProfile(str string) {
+	kind, slug, ok := ParseTaxonomyProfile(str)
+	if !ok {
+		log.Fatalf("Invalid profile %q, expected kind:slug\n", str)
+	}
+
+	profile, err := GetTaxonomyProfile(kind, slug)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Printf("%s:%s\n", profile.Kind, profile.Slug)
+	fmt.Println("Description:", profile.Description)
+	fmt.Println("Links:", strings.Join(profile.Links, ", "))
+	fmt.Println("Alternative names:", strings.Join(profile.AltNames, ", "))
+	fmt.Println("Image:", profile.Image)
+}
+
+func setProfile(str string) {
+	kind, slug, ok := ParseTaxonomyProfile(str)
+	if !ok {
+		log.Fatalf("Invalid profile %q, expected kind:slug\n", str)
+	}
+
+	update := TaxonomyProfileUpdate{Description: opts.Description}
+	if len(opts.ProfileLinks) > 0 {
+		update.Links = &opts.ProfileLinks
+	}
+	if len(opts.AltNames) > 0 {
+		update.AltNames = &opts.AltNames
+	}
+
+	if update.Description != nil || update.Links != nil || update.AltNames != nil {
+		if _, err := UpdateTaxonomyProfile(kind, slug, update); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if opts.RemoveImage {
+		if _, err := RemoveTaxonomyProfileImage(kind, slug); err != nil {
+			log.Fatalln(err)
+		}
+	} else if len(opts.Image) > 0 {
+		if _, err := SetTaxonomyProfileImage(kind, slug, opts.Image); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	log.Printf("Updated profile %s:%s\n", kind, slug)
+}
@@ -21,15 +21,22 @@ var opts struct {
 	Unpublish    []int64 `long:"unpublish" description:"Unpublish archive(s) by id"`
 	UnpublishAll bool    `long:"unpublish-all" description:"Unpublish all archives"`
 
-	Moderate bool     `long:"moderate" description:"Moderate all archives (blacklist)"`
-	Index    bool     `long:"index" description:"Index archives"`
-	Reindex  bool     `long:"reindex" description:"Reindex archives"`
-	Add      []string `long:"add" description:"Index archive(s) from path"`
+	Moderate    bool    `long:"moderate" description:"Quarantine archives matching the blacklist and report them, without hiding them yet"`
+	Apply       bool    `long:"apply" description:"Hide the quarantined archives from the public (with --moderate)"`
+	Report      string  `long:"report" description:"Write the moderation report to a file (.json or text)"`
+	Moderations string  `long:"moderations" optional:"true" optional-value:"all" description:"List moderated archives, optionally by state (quarantined or hidden)"`
+	Release     []int64 `long:"release" description:"Release archive(s) by id from moderation"`
+
+	Index   bool     `long:"index" description:"Index archives"`
+	Reindex bool     `long:"reindex" description:"Reindex archives"`
+	Add     []string `long:"add" description:"Index archive(s) from path"`
 
 	UpdateSlugs bool `long:"update-slugs" description:"Update slugs for all archives"`
 	Purge       bool `long:"purge" description:"Purge symlinks"`
 	Remap       bool `long:"remap" description:"Remap symlinks"`
 
+	ReindexSearch bool `long:"reindex-search" description:"Rebuild the full-text search documents of all archives"`
+
 	PurgeThumbnails    bool `long:"purge-thumbnails" description:"Purge thumbnails"`
 	GenerateThumbnails bool `long:"generate-thumbnails" description:"Generate thumbnails"`
 
@@ -47,6 +54,39 @@ var opts struct {
 	Sub  int64   `long:"sub" description:"Submission id"`
 	Link []int64 `long:"link" description:"Link archive(s) by id to a submission"`
 
+	Kind            string   `long:"kind" description:"Filter aliases or blacklist rules by kind"`
+	Aliases         bool     `long:"aliases" description:"List aliases"`
+	AddAlias        []string `long:"add-alias" description:"Add alias(es) in the format of kind:name:target"`
+	RemoveAlias     []int64  `long:"remove-alias" description:"Remove alias(es) by id"`
+	Blacklist       bool     `long:"blacklist" description:"List blacklist rules"`
+	AddBlacklist    []string `long:"add-blacklist" description:"Add blacklist rule(s) in the format of kind:value"`
+	RemoveBlacklist []int64  `long:"remove-blacklist" description:"Remove blacklist rule(s) by id"`
+	TestBlacklist   []string `long:"test-blacklist" description:"Explain which blacklist rules match archive(s) from path"`
+	ImportLists     bool     `long:"import-lists" description:"Import aliases and blacklist rules from alias.txt and blacklist.txt"`
+
+	Implications      bool     `long:"implications" description:"List tag implications"`
+	AddImplication    []string `long:"add-implication" description:"Add tag implication(s) in the format of tag => implied"`
+	RemoveImplication []int64  `long:"remove-implication" description:"Remove tag implication(s) by id"`
+
+	TaxonomyKinds      bool     `long:"taxonomy-kinds" description:"List taxonomy kinds"`
+	AddTaxonomyKind    []string `long:"add-taxonomy-kind" description:"Add taxonomy kind(s) in the format of kind:name:plural"`
+	RemoveTaxonomyKind []string `long:"remove-taxonomy-kind" description:"Remove taxonomy kind(s) declared in the database"`
+
+	Profile      string   `long:"profile" description:"Print the profile of an artist, circle, magazine or parody in the format of kind:slug"`
+	SetProfile   string   `long:"set-profile" description:"Edit the profile of an artist, circle, magazine or parody in the format of kind:slug"`
+	Description  *string  `long:"description" description:"Description of the profile (with --set-profile)"`
+	ProfileLinks []string `long:"profile-link" description:"External link(s) of the profile, replaces the existing ones, an empty value clears them (with --set-profile)"`
+	AltNames     []string `long:"alt-name" description:"Alternative name(s) of the profile, replaces the existing ones, an empty value clears them (with --set-profile)"`
+	Image        string   `long:"image" description:"Path to the avatar or cover image of the profile (with --set-profile)"`
+	RemoveImage  bool     `long:"remove-image" description:"Remove the avatar or cover image of the profile (with --set-profile)"`
+
+	MergeTaxonomy bool   `long:"merge-taxonomy" description:"Merge or rename a taxonomy, requires --kind, --from and --into"`
+	From          string `long:"from" description:"Slug or name of the taxonomy to merge from"`
+	Into          string `long:"into" description:"Name of the taxonomy to merge into"`
+
+	WebhookDeliveries string  `long:"webhook-deliveries" optional:"true" optional-value:"all" description:"List the latest webhook deliveries, optionally by state (pending, delivered or failed)"`
+	RetryDelivery     []int64 `long:"retry-delivery" description:"Retry webhook delivery(ies) by id"`
+
 	Archives []int64 `long:"archive"`
 	Expunge  bool    `long:"expunge"`
 	Redirect int64   `long:"redirect"`
@@ -60,6 +100,7 @@ var opts struct {
 	PurgeTemplatesCache   bool `long:"purge-templates-cache"`
 	PurgeSubmissionsCache bool `long:"purge-submissions-cache"`
 	ReloadTemplates       bool `long:"reload-templates"`
+	ReloadLists           bool `long:"reload-lists" description:"Reload aliases, blacklists, metadatas and taxonomy kinds"`
 }
 
 func main() {
@@ -71,6 +112,102 @@ func main() {
 	}
 	database.Init()
 
+	if opts.ImportLists {
+		log.Println("Importing aliases and blacklist rules...")
+		importLists()
+	}
+
+	if len(opts.AddAlias) > 0 {
+		log.Println("Adding aliases...")
+		addAliases(opts.AddAlias)
+	}
+
+	if len(opts.RemoveAlias) > 0 {
+		log.Println("Removing aliases...")
+		removeAliases(opts.RemoveAlias)
+	}
+
+	if len(opts.AddBlacklist) > 0 {
+		log.Println("Adding blacklist rules...")
+		addBlacklist(opts.AddBlacklist)
+	}
+
+	if len(opts.RemoveBlacklist) > 0 {
+		log.Println("Removing blacklist rules...")
+		removeBlacklist(opts.RemoveBlacklist)
+	}
+
+	if len(opts.AddImplication) > 0 {
+		log.Println("Adding tag implications...")
+		addImplications(opts.AddImplication)
+	}
+
+	if len(opts.RemoveImplication) > 0 {
+		log.Println("Removing tag implications...")
+		removeImplications(opts.RemoveImplication)
+	}
+
+	if len(opts.AddTaxonomyKind) > 0 {
+		log.Println("Adding taxonomy kinds...")
+		addTaxonomyKinds(opts.AddTaxonomyKind)
+	}
+
+	if len(opts.RemoveTaxonomyKind) > 0 {
+		log.Println("Removing taxonomy kinds...")
+		removeTaxonomyKinds(opts.RemoveTaxonomyKind)
+	}
+
+	if len(opts.AddTaxonomyKind) > 0 || len(opts.RemoveTaxonomyKind) > 0 {
+		reloadLists(opts.StartPort, opts.EndPort, ReloadListsOptions{TaxonomyKinds: true})
+	}
+
+	if opts.MergeTaxonomy {
+		log.Printf("Merging %s %s into %s...\n", opts.Kind, opts.From, opts.Into)
+		InitTaxonomyKinds()
+		if err := MergeTaxonomy(opts.Kind, opts.From, opts.Into); err != nil {
+			log.Fatalln(err)
+		}
+		purgeCaches(opts.StartPort, opts.EndPort, PurgeCacheOptions{
+			Archives:   true,
+			Taxonomies: true,
+			Templates:  true,
+		})
+	}
+
+	if len(opts.SetProfile) > 0 {
+		log.Println("Editing profile...")
+		setProfile(opts.SetProfile)
+		reloadLists(opts.StartPort, opts.EndPort, ReloadListsOptions{Aliases: true})
+		purgeCaches(opts.StartPort, opts.EndPort, PurgeCacheOptions{
+			Taxonomies: true,
+			Templates:  true,
+		})
+	}
+
+	if len(opts.Profile) > 0 {
+		printProfile(opts.Profile)
+	}
+
+	if opts.Aliases {
+		printAliases(opts.Kind)
+	}
+
+	if opts.Blacklist {
+		printBlacklist(opts.Kind)
+	}
+
+	if opts.Implications {
+		printImplications()
+	}
+
+	if opts.TaxonomyKinds {
+		printTaxonomyKinds()
+	}
+
+	for _, path := range opts.TestBlacklist {
+		testBlacklist(path)
+	}
+
 	if len(opts.Delete) > 0 {
 		log.Println("Deleting archives from the database...")
 		for _, id := range opts.Delete {
@@ -135,8 +272,52 @@ func main() {
 	}
 
 	if opts.Moderate {
-		log.Println("Moderating archives...")
-		moderateArchives()
+		if opts.Apply {
+			log.Println("Applying moderation...")
+			applyModeration()
+		} else {
+			log.Println("Moderating archives...")
+			moderateArchives(opts.Report)
+		}
+	}
+
+	if len(opts.Release) > 0 {
+		log.Println("Releasing archives...")
+		for _, id := range opts.Release {
+			if err := ReleaseArchive(id); err != nil {
+				log.Fatalln(err)
+			}
+		}
+	}
+
+	if opts.Moderate || len(opts.Release) > 0 {
+		purgeCaches(opts.StartPort, opts.EndPort, PurgeCacheOptions{
+			Archives:  true,
+			Templates: true,
+		})
+	}
+
+	if len(opts.Moderations) > 0 {
+		state := opts.Moderations
+		if state == "all" {
+			state = ""
+		}
+		printModerations(state)
+	}
+
+	if len(opts.WebhookDeliveries) > 0 {
+		state := opts.WebhookDeliveries
+		if state == "all" {
+			state = ""
+		}
+		printWebhookDeliveries(state)
+	}
+
+	for _, id := range opts.RetryDelivery {
+		log.Println("Retrying webhook delivery", id)
+		if err := RetryWebhookDelivery(id); err != nil {
+			log.Fatalln(err)
+		}
 	}
 
 	if len(opts.Accept) > 0 {
@@ -222,6 +403,17 @@ func main() {
 		updateSlugs()
 	}
 
+	if opts.ReindexSearch {
+		log.Println("Rebuilding search documents...")
+		if err := RefreshArchivesSearch(); err != nil {
+			log.Fatalln(err)
+		}
+		purgeCaches(opts.StartPort, opts.EndPort, PurgeCacheOptions{
+			Archives:  true,
+			Templates: true,
+		})
+	}
+
 	if len(opts.Archives) > 0 && (opts.Redirect > 0 || opts.Expunge || len(opts.Source) > 0) {
 		for _, id := range opts.Archives {
 			if opts.Expunge {
@@ -256,4 +448,14 @@ func main() {
 		log.Println("Reloading templates...")
 		reloadTemplates(opts.StartPort, opts.EndPort)
 	}
+
+	if opts.ReloadLists {
+		log.Println("Reloading lists...")
+		reloadLists(opts.StartPort, opts.EndPort, ReloadListsOptions{
+			Aliases:       true,
+			Blacklists:    true,
+			Metadatas:     true,
+			TaxonomyKinds: true,
+		})
+	}
 }
@@ -105,7 +105,7 @@ func searchF(model *models.Archive) (path string, err error) {
 				return true
 			}
 
-			if v, ok := Aliases.ArtistMatches[artistSlug]; ok {
+			if v, ok := GetAliases().ArtistMatches[artistSlug]; ok {
 				artistSlug = Slugify(v)
 			}
 
@@ -120,7 +120,7 @@ func searchF(model *models.Archive) (path string, err error) {
 	return
 }
 
-func scrapeF(fn, fnSlug, path string, model *models.Archive) (ok bool) {
+func scrapeF(fn, path string, model *models.Archive, metadata *Metadata) (ok bool) {
 	if len(path) == 0 {
 		var err error
 		path, err = searchF(model)
@@ -150,11 +150,6 @@ func scrapeF(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 	}
 
 	log.Println("[F] metadata found:", fn)
-	metadata, ok := Metadatas.Map[fnSlug]
-	if !ok {
-		metadata = &Metadata{}
-		Metadatas.Map[fnSlug] = metadata
-	}
 
 	metadata.Title = strings.TrimSpace(document.Find("body > div > div.grid > div > div > div[class*='table-cell'] > h1").Text())
 	fields := document.Find("body > div > div.grid > div > div > div[class*='table-cell'] > .text-sm")
@@ -168,7 +163,7 @@ func scrapeF(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 			artists := strings.Split(strings.TrimSpace(s.Children().Last().Text()), ",")
 			for _, artist := range artists {
 				artist = strings.TrimSpace(artist)
-				if v, ok := Aliases.ArtistMatches[Slugify(artist)]; ok {
+				if v, ok := GetAliases().ArtistMatches[Slugify(artist)]; ok {
 					artist = v
 				}
 
@@ -187,7 +182,7 @@ func scrapeF(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 			circles := strings.Split(strings.TrimSpace(s.Children().Last().Text()), ",")
 			for _, circle := range circles {
 				circle = strings.TrimSpace(circle)
-				if v, ok := Aliases.CircleMatches[Slugify(circle)]; ok {
+				if v, ok := GetAliases().CircleMatches[Slugify(circle)]; ok {
 					circle = v
 				}
 
@@ -206,7 +201,7 @@ func scrapeF(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 			parodies := strings.Split(strings.TrimSpace(s.Children().Last().Text()), ",")
 			for _, parody := range parodies {
 				parody = strings.TrimSpace(parody)
-				if v, ok := Aliases.ParodyMatches[Slugify(parody)]; ok {
+				if v, ok := GetAliases().ParodyMatches[Slugify(parody)]; ok {
 					parody = v
 				}
 
@@ -225,7 +220,7 @@ func scrapeF(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 			magazines := strings.Split(strings.TrimSpace(s.Children().Last().Text()), ",")
 			for _, magazine := range magazines {
 				magazine = strings.TrimSpace(magazine)
-				if v, ok := Aliases.MagazineMatches[Slugify(magazine)]; ok {
+				if v, ok := GetAliases().MagazineMatches[Slugify(magazine)]; ok {
 					magazine = v
 				}
 
@@ -248,7 +243,7 @@ func scrapeF(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 		href, _ := s.Attr("href")
 		if len(href) > 0 {
 			tag := strings.TrimSpace(s.Text())
-			if v, ok := Aliases.TagMatches[Slugify(tag)]; ok {
+			if v, ok := GetAliases().TagMatches[TagSlug(tag)]; ok {
 				tag = v
 			}
 
@@ -332,7 +327,7 @@ func searchI(model *models.Archive) (path string, err error) {
 	return
 }
 
-func scrapeI(fn, fnSlug, path string, model *models.Archive) (ok bool) {
+func scrapeI(fn, path string, model *models.Archive, metadata *Metadata) (ok bool) {
 	if len(path) == 0 {
 		var err error
 		path, err = searchI(model)
@@ -366,18 +361,13 @@ func scrapeI(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 	}
 
 	log.Print("[I] metadata found:", fn)
-	metadata, ok := Metadatas.Map[fnSlug]
-	if !ok {
-		metadata = &Metadata{}
-		Metadatas.Map[fnSlug] = metadata
-	}
 
 	metadata.Title = strings.TrimSpace(document.Find("h1.title.page-title").Text())
 	artists := document.Find(".product-manufacturer a")
 	if artists.Length() > 0 {
 		artists.Each(func(i int, s *goquery.Selection) {
 			artist := strings.TrimSpace(s.Text())
-			if v, ok := Aliases.ArtistMatches[Slugify(artist)]; ok {
+			if v, ok := GetAliases().ArtistMatches[Slugify(artist)]; ok {
 				artist = v
 			}
 
@@ -414,7 +404,7 @@ func scrapeI(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 	if tags.Length() > 0 {
 		tags.Each(func(i int, s *goquery.Selection) {
 			tag := strings.TrimSpace(s.Text())
-			if v, ok := Aliases.TagMatches[Slugify(tag)]; ok {
+			if v, ok := GetAliases().TagMatches[TagSlug(tag)]; ok {
 				tag = v
 			}
 
@@ -433,9 +423,62 @@ func scrapeI(fn, fnSlug, path string, model *models.Archive) (ok bool) {
 	return true
 }
 
+// scrapedMetadatas is a copy of the loaded metadatas, which are shared
+// and read-only, that the scrapers add the metadatas they found to.
+type scrapedMetadatas struct {
+	*MetadataSet
+	sync.Mutex
+}
+
+func newScrapedMetadatas() *scrapedMetadatas {
+	return &scrapedMetadatas{MetadataSet: GetMetadatas().Clone()}
+}
+
+func (s *scrapedMetadatas) has(fnSlug string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.Map[fnSlug]
+	return ok
+}
+
+// scrape scrapes the metadata of the archive into a copy of its
+// current metadata, which replaces it if the scraping succeeded.
+func (s *scrapedMetadatas) scrape(model *models.Archive, fpath, ipath string) {
+	fn := FileName(model.Path)
+	fnSlug := Slugify(fn)
+
+	s.Lock()
+	metadata := s.Map[fnSlug].Clone()
+	s.Unlock()
+
+	if scrapeF(fn, fpath, model, metadata) || scrapeI(fn, ipath, model, metadata) {
+		s.Lock()
+		s.Map[fnSlug] = metadata
+		s.Unlock()
+	}
+}
+
+// save writes the metadatas to metadata.json and loads them.
+func (s *scrapedMetadatas) save() {
+	s.Lock()
+	defer s.Unlock()
+
+	buf, err := json.Marshal(s.Map)
+	if err == nil {
+		err = os.WriteFile("metadata.json", buf, 755)
+	}
+
+	if err != nil {
+		log.Fatalln(errors.WithStack(err))
+	}
+	StoreMetadatas(s.MetadataSet)
+}
+
 func scrapeMetadata() {
 	initHttpClient()
 	InitAliases()
+	InitImplications()
+	InitTaxonomyKinds()
 	InitMetadatas()
 
 	archives, err := models.Archives(
@@ -450,6 +493,7 @@ func scrapeMetadata() {
 	total := len(archives)
 	log.Println(fmt.Sprintf("%d archives found", total))
 
+	scraped := newScrapedMetadatas()
 	c := make(chan bool, 10)
 	defer close(c)
 
@@ -464,33 +508,21 @@ func scrapeMetadata() {
 				<-c
 			}()
 
-			fn := FileName(model.Path)
-			fnSlug := Slugify(fn)
-
-			if _, ok := Metadatas.Map[fnSlug]; ok {
+			if scraped.has(Slugify(FileName(model.Path))) {
 				return
 			}
-
-			if !scrapeF(fn, fnSlug, "", model) {
-				scrapeI(fn, fnSlug, "", model)
-			}
+			scraped.scrape(model, "", "")
 		}(i, model)
 	}
 	wg.Wait()
-
-	buf, err := json.Marshal(Metadatas.Map)
-	if err == nil {
-		err = os.WriteFile("metadata.json", buf, 755)
-	}
-
-	if err != nil {
-		log.Fatalln(errors.WithStack(err))
-	}
+	scraped.save()
 }
 
 func scrapeMetadataById(id int64, fpath, ipath string) {
 	initHttpClient()
 	InitAliases()
+	InitImplications()
+	InitTaxonomyKinds()
 	InitMetadatas()
 
 	model, err := models.Archives(
@@ -503,21 +535,9 @@ func scrapeMetadataById(id int64, fpath, ipath string) {
 		log.Fatalln(err)
 	}
 
-	fn := FileName(model.Path)
-	fnSlug := Slugify(fn)
-
-	if !scrapeF(fn, fnSlug, fpath, model) {
-		scrapeI(fn, fnSlug, ipath, model)
-	}
-
-	buf, err := json.Marshal(Metadatas.Map)
-	if err == nil {
-		err = os.WriteFile("metadata.json", buf, 755)
-	}
-
-	if err != nil {
-		log.Fatalln(errors.WithStack(err))
-	}
+	scraped := newScrapedMetadatas()
+	scraped.scrape(model, fpath, ipath)
+	scraped.save()
 }
 
 func importMetadata() {
@@ -556,7 +576,7 @@ func importMetadata() {
 			fn := FileName(model.Path)
 			fnSlug := Slugify(fn)
 
-			metadata, ok := Metadatas.Map[fnSlug]
+			metadata, ok := GetMetadatas().Map[fnSlug]
 			if !ok {
 				return
 			}
@@ -566,7 +586,7 @@ func importMetadata() {
 
 			for _, artist := range metadata.Artists {
 				slug := Slugify(artist)
-				if v, ok := Aliases.ArtistMatches[slug]; ok {
+				if v, ok := GetAliases().ArtistMatches[slug]; ok {
 					slug = Slugify(v)
 					artist = v
 				}
@@ -581,13 +601,13 @@ func importMetadata() {
 
 				if !isDuplicate {
 					archive.Artists = append(archive.Artists,
-						&modext.Artist{Name: artist})
+						&modext.Taxonomy{Kind: "artist", Name: artist})
 				}
 			}
 
 			for _, circle := range metadata.Circles {
 				slug := Slugify(circle)
-				if v, ok := Aliases.CircleMatches[slug]; ok {
+				if v, ok := GetAliases().CircleMatches[slug]; ok {
 					slug = Slugify(v)
 					circle = v
 				}
@@ -602,13 +622,13 @@ func importMetadata() {
 
 				if !isDuplicate {
 					archive.Circles = append(archive.Circles,
-						&modext.Circle{Name: circle})
+						&modext.Taxonomy{Kind: "circle", Name: circle})
 				}
 			}
 
 			for _, magazine := range metadata.Magazines {
 				slug := Slugify(magazine)
-				if v, ok := Aliases.MagazineMatches[slug]; ok {
+				if v, ok := GetAliases().MagazineMatches[slug]; ok {
 					slug = Slugify(v)
 					magazine = v
 				}
@@ -623,13 +643,13 @@ func importMetadata() {
 
 				if !isDuplicate {
 					archive.Magazines = append(archive.Magazines,
-						&modext.Magazine{Name: magazine})
+						&modext.Taxonomy{Kind: "magazine", Name: magazine})
 				}
 			}
 
 			for _, parody := range metadata.Parodies {
 				slug := Slugify(parody)
-				if v, ok := Aliases.ParodyMatches[slug]; ok {
+				if v, ok := GetAliases().ParodyMatches[slug]; ok {
 					slug = Slugify(v)
 					parody = v
 				}
@@ -644,17 +664,20 @@ func importMetadata() {
 
 				if !isDuplicate {
 					archive.Parodies = append(archive.Parodies,
-						&modext.Parody{Name: parody})
+						&modext.Taxonomy{Kind: "parody", Name: parody})
 				}
 			}
 
+			tags := make([]string, 0, len(metadata.Tags))
 			for _, tag := range metadata.Tags {
-				slug := Slugify(tag)
-				if v, ok := Aliases.TagMatches[slug]; ok {
-					slug = Slugify(v)
+				if v, ok := GetAliases().TagMatches[TagSlug(tag)]; ok {
 					tag = v
 				}
+				tags = append(tags, tag)
+			}
 
+			for _, tag := range GetImplications().Apply(tags) {
+				slug := TagSlug(tag)
 				isDuplicate := false
 				for _, t := range archive.Tags {
 					if t.Slug == slug {
@@ -664,8 +687,24 @@ func importMetadata() {
 				}
 
 				if !isDuplicate {
+					namespace, name := ParseTagName(tag)
 					archive.Tags = append(archive.Tags,
-						&modext.Tag{Name: tag})
+						&modext.Taxonomy{Kind: "tag", Slug: slug, Namespace: namespace, Name: name})
+				}
+			}
+
+			kinds := GetTaxonomyKinds()
+			for kind, values := range metadata.Taxonomies {
+				if !kinds.IsCustom(kind) {
+					continue
+				}
+
+				if archive.Taxonomies == nil {
+					archive.Taxonomies = make(map[string][]*modext.Taxonomy)
+				}
+				for _, v := range values {
+					archive.Taxonomies[kind] = append(archive.Taxonomies[kind],
+						&modext.Taxonomy{Kind: kind, Slug: Slugify(v), Name: v})
 				}
 			}
 
@@ -673,7 +712,7 @@ func importMetadata() {
 				model.Title = metadata.Title
 				model.Slug = Slugify(model.Title)
 
-				if v, ok := Aliases.ArchiveMatches[model.Slug]; ok {
+				if v, ok := GetAliases().ArchiveMatches[model.Slug]; ok {
 					model.Slug = Slugify(v)
 					model.Title = v
 				}
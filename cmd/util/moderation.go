@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	. "koushoku/services"
+
+	"koushoku/models"
+	"koushoku/modext"
+
+	. "github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// moderateArchives quarantines the archives matching the blacklist rules
+// and releases the quarantined archives that no longer match any rule.
+// Nothing is deleted or hidden yet, the quarantined archives stay visible
+// and the report is meant to be reviewed before they are hidden with --apply.
+func moderateArchives(reportPath string) {
+	InitBlacklists()
+	blacklists := GetBlacklists()
+
+	moderations, err := ListModerations("")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	states := make(map[int64]string)
+	manual := make(map[int64]bool)
+	for _, m := range moderations {
+		states[m.ArchiveID] = m.State
+		manual[m.ArchiveID] = m.Rule == ManualModerationRule
+	}
+
+	archives, err := models.Archives(
+		Load(ArchiveRels.Artists),
+		Load(ArchiveRels.Circles),
+		Load(ArchiveRels.Magazines),
+		Load(ArchiveRels.Parodies),
+		Load(ArchiveRels.Tags),
+		OrderBy("id ASC")).AllG()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var quarantined, released int
+	for _, model := range archives {
+		archive := modext.NewArchive(model).LoadRels(model)
+		state := states[archive.ID]
+		if manual[archive.ID] {
+			continue
+		}
+
+		match := blacklists.Match(archive)
+		if match == nil {
+			if state == ModerationQuarantined {
+				if err := ReleaseArchive(archive.ID); err != nil {
+					log.Fatalln(err)
+				}
+				fmt.Printf("Released %d: %s\n", archive.ID, archive.Title)
+				released++
+			}
+			continue
+		}
+
+		if state == ModerationHidden {
+			continue
+		}
+
+		if err := QuarantineArchive(archive.ID, match); err != nil {
+			log.Fatalln(err)
+		}
+
+		if len(state) == 0 {
+			fmt.Printf("Quarantined %d: %s\n", archive.ID, archive.Title)
+			fmt.Printf("  Path: %s\n", archive.Path)
+			fmt.Printf("  Rule %d: %s\n", match.ID, match.Rule)
+			for _, reason := range match.Reasons {
+				fmt.Println("  -", reason)
+			}
+			quarantined++
+		}
+	}
+
+	moderations, err = ListModerations(ModerationQuarantined)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	log.Printf("%d archive(s) quarantined, %d released, %d awaiting review\n",
+		quarantined, released, len(moderations))
+
+	if len(reportPath) > 0 {
+		writeModerationReport(reportPath, moderations)
+	}
+}
+
+func writeModerationReport(path string, moderations []*modext.ArchiveModeration) {
+	var buf []byte
+	var err error
+
+	if strings.HasSuffix(path, ".json") {
+		buf, err = json.MarshalIndent(moderations, "", "  ")
+		if err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		var sb strings.Builder
+		for _, m := range moderations {
+			fmt.Fprintf(&sb, "%d\t%s\t%s\n", m.ArchiveID, m.State, m.Title)
+			fmt.Fprintf(&sb, "\tPath: %s\n", m.Path)
+			fmt.Fprintf(&sb, "\tRule %d: %s\n", m.RuleID, m.Rule)
+			for _, reason := range m.Reasons {
+				fmt.Fprintf(&sb, "\t- %s\n", reason)
+			}
+		}
+		buf = []byte(sb.String())
+	}
+
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		log.Fatalln(err)
+	}
+	log.Println("Moderation report written to", path)
+}
+
+func printModerations(state string) {
+	moderations, err := ListModerations(state)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, m := range moderations {
+		fmt.Printf("%d (%s): %s\n", m.ArchiveID, m.State, m.Title)
+		fmt.Printf("  Rule %d: %s\n", m.RuleID, m.Rule)
+		for _, reason := range m.Reasons {
+			fmt.Println("  -", reason)
+		}
+	}
+}
+
+func applyModeration() {
+	n, err := ApplyModeration()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("%d quarantined archive(s) are now hidden\n", n)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	. "koushoku/services"
+)
+
+func printWebhookDeliveries(state string) {
+	deliveries, err := ListWebhookDeliveries(state, "", 100, 0)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, d := range deliveries {
+		fmt.Printf("%d (%s): %s to %s, %d attempt(s)\n", d.ID, d.State, d.Event, d.Webhook, d.Attempts)
+		if len(d.Error) > 0 {
+			fmt.Println("  Error:", d.Error)
+		}
+		if d.NextAttemptAt > 0 {
+			fmt.Println("  Next attempt:", time.Unix(d.NextAttemptAt, 0).Format(time.RFC1123))
+		}
+	}
+}
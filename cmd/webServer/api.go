@@ -2,71 +2,96 @@ package main
 
 import (
 	"log"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"koushoku/cache"
-	. "koushoku/config"
+	"koushoku/errs"
 	"koushoku/server"
+	"koushoku/services"
 )
 
-type ApiPayload struct {
-	ApiKey string `json:"key"`
-}
-
-type PurgeCachePayload struct {
-	ApiPayload
-	Archives    bool `json:"archives"`
-	Taxonomies  bool `json:"taxonomies"`
-	Templates   bool `json:"templates"`
-	Submissions bool `json:"submissions"`
-}
+// doReloadLists reloads the requested lists and purges
+// the caches that depend on them.
+func doReloadLists(aliases, blacklists, implications, metadatas bool) error {
+	if aliases {
+		log.Println("Reloading aliases...")
+		if err := services.ReloadAliases(); err != nil {
+			log.Println(err)
+			return err
+		}
 
-func purgeCache(c *server.Context) {
-	payload := &PurgeCachePayload{}
-	if err := c.BindJSON(payload); err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
+		services.PurgeArchivesResults()
+		server.PurgeTemplates(searchTmplName, taxonomyTmplName, opdsAcquisitionTmplName, feedAtomTmplName, feedRSSTmplName)
 	}
 
-	if payload.ApiKey != Config.HTTP.ApiKey {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
+	if blacklists {
+		log.Println("Reloading blacklists...")
+		if err := services.ReloadBlacklists(); err != nil {
+			log.Println(err)
+			return err
+		}
 	}
 
-	if payload.Archives {
-		log.Println("Purging archives cache...")
-		cache.Archives.Purge()
+	if implications {
+		log.Println("Reloading tag implications...")
+		if err := services.ReloadImplications(); err != nil {
+			log.Println(err)
+			return err
+		}
 	}
 
-	if payload.Taxonomies {
-		log.Println("Purging taxonomies cache...")
-		cache.Taxonomies.Purge()
+	if metadatas {
+		log.Println("Reloading metadatas...")
+		if err := services.ReloadMetadatas(); err != nil {
+			log.Println(err)
+			return err
+		}
 	}
+	return nil
+}
 
-	if payload.Templates {
-		log.Println("Purging templates cache...")
-		cache.Templates.Purge()
+// doReloadTaxonomyKinds reloads the taxonomy kinds and purges the
+// caches that depend on them. The routes of the kinds are resolved
+// on every request, so that a new kind is served right away.
+func doReloadTaxonomyKinds() error {
+	log.Println("Reloading taxonomy kinds...")
+	if err := services.ReloadTaxonomyKinds(); err != nil {
+		log.Println(err)
+		return err
 	}
 
-	if payload.Submissions {
-		log.Println("Purging submissions cache...")
-		cache.Submissions.Purge()
-	}
+	services.PurgeTaxonomies()
+	services.PurgeArchivesResults()
+	server.PurgeTemplates(archiveTmplName, searchTmplName, sitemapTmplName, listingTmplName, taxonomyTmplName,
+		opdsNavigationTmplName, opdsAcquisitionTmplName, feedAtomTmplName, feedRSSTmplName)
+	return nil
 }
 
-func reloadTemplates(c *server.Context) {
-	payload := &ApiPayload{}
-	if err := c.BindJSON(payload); err != nil {
-		c.AbortWithStatus(http.StatusBadRequest)
-		return
-	}
+// isAdminRequest returns whether the request is authorized with
+// a key of the archives scope as a bearer token.
+func isAdminRequest(c *server.Context) bool {
+	key := requestApiKey(c)
+	return key != nil && key.HasScope(scopeArchives)
+}
 
-	if payload.ApiKey != Config.HTTP.ApiKey {
-		c.AbortWithStatus(http.StatusUnauthorized)
-		return
-	}
+// textError writes the error as plain text, for the
+// clients of the feeds, which expect no HTML.
+func textError(c *server.Context, err error) {
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.String(errs.Status(err), err.Error())
+}
+
+// handleSignals reloads all lists whenever SIGHUP is received.
+func handleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
 
-	log.Println("Reloading templates...")
-	server.LoadTemplates()
-	cache.Templates.Purge()
+	go func() {
+		for range c {
+			log.Println("Received SIGHUP")
+			doReloadLists(true, true, true, true)
+			doReloadTaxonomyKinds()
+		}
+	}()
 }
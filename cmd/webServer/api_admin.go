@@ -0,0 +1,753 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	. "koushoku/config"
+
+	"koushoku/cache"
+	"koushoku/errs"
+	"koushoku/modext"
+	"koushoku/server"
+	"koushoku/services"
+)
+
+// The routes of the admin API, authorized with the keys of the config
+// sent as bearer tokens, each route requiring one of the scopes below.
+const (
+	apiAdminPrefix = "/api/admin"
+
+	scopeArchives    = "archives"
+	scopeSubmissions = "submissions"
+	scopeLists       = "lists"
+	scopeTaxonomies  = "taxonomies"
+	scopeCaches      = "caches"
+	scopeWebhooks    = "webhooks"
+
+	adminDeliveriesLimit    = 50
+	adminMaxDeliveriesLimit = 500
+)
+
+// AdminKey is the key the request is authorized with, without its value.
+type AdminKey struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// AdminWebhook is a webhook of the config, without its secret.
+type AdminWebhook struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"`
+}
+
+// AdminSubmission is a submission along with its
+// fields that are not shown publicly.
+type AdminSubmission struct {
+	*modext.Submission
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Notes   string `json:"notes,omitempty"`
+}
+
+// findApiKey returns the key of the config matching the token. Every
+// key is compared in constant time, whichever of them matches.
+func findApiKey(token string) *ApiKey {
+	var found *ApiKey
+	for i := range Config.HTTP.ApiKeys {
+		key := &Config.HTTP.ApiKeys[i]
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.Key)) == 1 && found == nil {
+			found = key
+		}
+	}
+	return found
+}
+
+// requestApiKey returns the key the request is authorized with
+// as a bearer token, or nil if there is none or it is invalid.
+func requestApiKey(c *server.Context) *ApiKey {
+	token := c.GetHeader("Authorization")
+	if len(token) < 7 || !strings.EqualFold(token[:7], "Bearer ") {
+		return nil
+	}
+
+	token = strings.TrimSpace(token[7:])
+	if len(token) == 0 {
+		return nil
+	}
+	return findApiKey(token)
+}
+
+// withAdminScope authorizes the requests with a key of the scope,
+// and logs which key is used for what.
+func withAdminScope(scope string) server.Handler {
+	return func(c *server.Context) {
+		c.Header("Cache-Control", "no-store")
+
+		key := requestApiKey(c)
+		if key == nil {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s"`, Config.Meta.Title))
+			writeV1Error(c, errs.ApiKeyInvalid)
+			return
+		}
+
+		if len(scope) > 0 && !key.HasScope(scope) {
+			writeV1Error(c, errs.ApiKeyScopeMissing)
+			return
+		}
+		log.Printf("Admin API: %s %s by %s\n", c.Request.Method, c.Request.URL.Path, key.Name)
+	}
+}
+
+// bindAdminPayload binds the JSON body of the request, if any.
+func bindAdminPayload(c *server.Context, payload any) bool {
+	if err := c.ShouldBindJSON(payload); err != nil && !errors.Is(err, io.EOF) {
+		writeV1Error(c, err)
+		return false
+	}
+	return true
+}
+
+// getAdminID returns the id parameter, answering with the
+// given not found error if it is not a valid id.
+func getAdminID(c *server.Context, notFound error) (int64, bool) {
+	id, err := c.ParamInt64("id")
+	if err != nil || id <= 0 {
+		writeV1Error(c, notFound)
+		return 0, false
+	}
+	return id, true
+}
+
+func writeAdmin(c *server.Context, status int, data any) {
+	c.JSON(status, &V1Response{Data: data})
+}
+
+// purgeArchiveCaches purges the caches of this server that depend on the
+// archives. The other servers have to be purged with /caches/purge.
+func purgeArchiveCaches() {
+	cache.Archives.Purge()
+	cache.Templates.Purge()
+}
+
+func adminKey(c *server.Context) {
+	key := requestApiKey(c)
+	writeAdmin(c, http.StatusOK, &AdminKey{Name: key.Name, Scopes: key.Scopes})
+}
+
+type adminArchiveFunc func(id int64) (*modext.Archive, error)
+
+// adminArchive runs fn on the archive of the id parameter
+// and answers with the updated archive.
+func adminArchive(fn adminArchiveFunc) server.Handler {
+	return func(c *server.Context) {
+		id, ok := getAdminID(c, errs.ArchiveNotFound)
+		if !ok {
+			return
+		}
+
+		archive, err := fn(id)
+		if err != nil {
+			writeV1Error(c, err)
+			return
+		}
+
+		purgeArchiveCaches()
+		writeAdmin(c, http.StatusOK, archive)
+	}
+}
+
+func adminRedirectArchive(c *server.Context) {
+	payload := &struct {
+		Target int64 `json:"target"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	adminArchive(func(id int64) (*modext.Archive, error) {
+		return services.RedirectArchive(id, payload.Target)
+	})(c)
+}
+
+func adminSetArchiveSource(c *server.Context) {
+	payload := &struct {
+		Source string `json:"source"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	adminArchive(func(id int64) (*modext.Archive, error) {
+		return services.SetArchiveSource(id, strings.TrimSpace(payload.Source))
+	})(c)
+}
+
+func expungeArchive(id int64) (*modext.Archive, error) {
+	return services.SetArchiveExpunged(id, true)
+}
+
+func restoreArchive(id int64) (*modext.Archive, error) {
+	return services.SetArchiveExpunged(id, false)
+}
+
+// adminQuarantineArchive quarantines an archive by hand, with the given
+// reasons, until the moderation is applied or the archive is released.
+func adminQuarantineArchive(c *server.Context) {
+	id, ok := getAdminID(c, errs.ArchiveNotFound)
+	if !ok {
+		return
+	}
+
+	payload := &struct {
+		Reasons []string `json:"reasons"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	match := &services.BlacklistMatch{Rule: services.ManualModerationRule, Reasons: payload.Reasons}
+	if err := services.QuarantineArchive(id, match); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	purgeArchiveCaches()
+	c.Status(http.StatusNoContent)
+}
+
+func adminDeleteArchive(c *server.Context) {
+	id, ok := getAdminID(c, errs.ArchiveNotFound)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteArchive(id); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	purgeArchiveCaches()
+	c.Status(http.StatusNoContent)
+}
+
+func adminReleaseArchive(c *server.Context) {
+	id, ok := getAdminID(c, errs.ArchiveNotFound)
+	if !ok {
+		return
+	}
+
+	if err := services.ReleaseArchive(id); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	purgeArchiveCaches()
+	c.Status(http.StatusNoContent)
+}
+
+// adminArchives runs fn, which updates every archive at once.
+func adminArchives(fn func() error) server.Handler {
+	return func(c *server.Context) {
+		if err := fn(); err != nil {
+			writeV1Error(c, err)
+			return
+		}
+
+		purgeArchiveCaches()
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// adminDeleteArchives deletes every archive, which has
+// to be confirmed with confirm=true.
+func adminDeleteArchives(c *server.Context) {
+	if c.Query("confirm") != "true" {
+		writeV1Error(c, errs.ArchiveDeleteUnconfirmed)
+		return
+	}
+	adminArchives(services.DeleteArchives)(c)
+}
+
+func adminModerations(c *server.Context) {
+	moderations, err := services.ListModerations(c.Query("state"))
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	writeAdmin(c, http.StatusOK, moderations)
+}
+
+func adminApplyModeration(c *server.Context) {
+	n, err := services.ApplyModeration()
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	purgeArchiveCaches()
+	writeAdmin(c, http.StatusOK, map[string]int64{"hidden": n})
+}
+
+func adminSubmissions(c *server.Context) {
+	submissions, err := services.ListSubmissions()
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	result := make([]*AdminSubmission, len(submissions))
+	for i, submission := range submissions {
+		result[i] = &AdminSubmission{
+			Submission: submission,
+			Name:       submission.Name,
+			Content:    submission.Content,
+			Notes:      submission.Notes,
+		}
+	}
+	writeAdmin(c, http.StatusOK, result)
+}
+
+// adminReviewSubmission accepts or rejects the submission
+// of the id parameter with fn, along with a note.
+func adminReviewSubmission(fn func(id int64, note string) error) server.Handler {
+	return func(c *server.Context) {
+		id, ok := getAdminID(c, errs.SubmissionNotFound)
+		if !ok {
+			return
+		}
+
+		payload := &struct {
+			Note string `json:"note"`
+		}{}
+		if !bindAdminPayload(c, payload) {
+			return
+		}
+
+		if err := fn(id, payload.Note); err != nil {
+			writeV1Error(c, err)
+			return
+		}
+
+		cache.Submissions.Purge()
+		server.PurgeTemplates(submissionsTmplname)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// adminLinkSubmission links the archives given as
+// archives to the submission of the id parameter.
+func adminLinkSubmission(c *server.Context) {
+	id, ok := getAdminID(c, errs.SubmissionNotFound)
+	if !ok {
+		return
+	}
+
+	payload := &struct {
+		Archives []int64 `json:"archives"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	for _, archiveID := range payload.Archives {
+		if err := services.LinkSubmission(archiveID, id); err != nil {
+			writeV1Error(c, err)
+			return
+		}
+	}
+
+	cache.Submissions.Purge()
+	purgeArchiveCaches()
+	c.Status(http.StatusNoContent)
+}
+
+func adminAliases(c *server.Context) {
+	aliases, err := services.ListAliases(c.Query("kind"))
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	writeAdmin(c, http.StatusOK, aliases)
+}
+
+func adminAddAlias(c *server.Context) {
+	payload := &struct {
+		Kind   string `json:"kind"`
+		Name   string `json:"name"`
+		Target string `json:"target"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	alias, err := services.CreateAlias(payload.Kind, payload.Name, payload.Target)
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadLists(true, false, false, false); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	writeAdmin(c, http.StatusCreated, alias)
+}
+
+func adminRemoveAlias(c *server.Context) {
+	id, ok := getAdminID(c, errs.AliasNotFound)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteAlias(id); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadLists(true, false, false, false); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func adminBlacklist(c *server.Context) {
+	rules, err := services.ListBlacklistRules(c.Query("kind"))
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	writeAdmin(c, http.StatusOK, rules)
+}
+
+func adminAddBlacklist(c *server.Context) {
+	payload := &struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	rule, err := services.CreateBlacklistRule(payload.Kind, payload.Value)
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadLists(false, true, false, false); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	writeAdmin(c, http.StatusCreated, rule)
+}
+
+func adminRemoveBlacklist(c *server.Context) {
+	id, ok := getAdminID(c, errs.BlacklistNotFound)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteBlacklistRule(id); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadLists(false, true, false, false); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func adminImplications(c *server.Context) {
+	implications, err := services.ListTagImplications()
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	writeAdmin(c, http.StatusOK, implications)
+}
+
+// adminAddImplication adds a tag implication, which
+// applies to the archives indexed from then on.
+func adminAddImplication(c *server.Context) {
+	payload := &struct {
+		Tag     string `json:"tag"`
+		Implied string `json:"implied"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	implication, err := services.CreateTagImplication(payload.Tag, payload.Implied)
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadLists(false, false, true, false); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	writeAdmin(c, http.StatusCreated, implication)
+}
+
+func adminRemoveImplication(c *server.Context) {
+	id, ok := getAdminID(c, errs.ImplicationNotFound)
+	if !ok {
+		return
+	}
+
+	if err := services.DeleteTagImplication(id); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadLists(false, false, true, false); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// adminAddTaxonomyKind declares a taxonomy kind and reloads the kinds,
+// so that its routes are served right away by this server.
+func adminAddTaxonomyKind(c *server.Context) {
+	payload := &struct {
+		Kind   string `json:"kind"`
+		Name   string `json:"name"`
+		Plural string `json:"plural"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	kind, err := services.CreateTaxonomyKind(payload.Kind, payload.Name, payload.Plural)
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadTaxonomyKinds(); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	writeAdmin(c, http.StatusCreated, kind)
+}
+
+func adminRemoveTaxonomyKind(c *server.Context) {
+	if err := services.DeleteTaxonomyKind(c.Param("kind")); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadTaxonomyKinds(); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// adminMergeTaxonomy merges the taxonomy given as from into the one
+// given as into, of the kind parameter, or renames it.
+func adminMergeTaxonomy(c *server.Context) {
+	kind, ok := getV1TaxonomyKind(c)
+	if !ok {
+		return
+	}
+
+	payload := &struct {
+		From string `json:"from"`
+		Into string `json:"into"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	if err := services.MergeTaxonomy(kind.Kind, payload.From, payload.Into); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	services.PurgeTaxonomies()
+	purgeArchiveCaches()
+	c.Status(http.StatusNoContent)
+}
+
+func adminProfile(c *server.Context) {
+	profile, err := services.GetTaxonomyProfile(c.Param("kind"), c.Param("slug"))
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	writeAdmin(c, http.StatusOK, profile)
+}
+
+// adminUpdateProfile updates the fields of the profile that are given,
+// the alternative names being reloaded along with the aliases.
+func adminUpdateProfile(c *server.Context) {
+	payload := &services.TaxonomyProfileUpdate{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	profile, err := services.UpdateTaxonomyProfile(c.Param("kind"), c.Param("slug"), *payload)
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	if err := doReloadLists(true, false, false, false); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+	writeAdmin(c, http.StatusOK, profile)
+}
+
+// adminSetProfileImage sets the avatar or cover image
+// of the profile to the image sent as the body.
+func adminSetProfileImage(c *server.Context) {
+	data, err := c.GetRawData()
+	if err != nil || len(data) == 0 {
+		writeV1Error(c, errs.ProfileImageInvalid)
+		return
+	}
+
+	profile, err := services.SetTaxonomyProfileImageData(c.Param("kind"), c.Param("slug"), data)
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	server.PurgeTemplates(taxonomyTmplName)
+	writeAdmin(c, http.StatusOK, profile)
+}
+
+func adminRemoveProfileImage(c *server.Context) {
+	profile, err := services.RemoveTaxonomyProfileImage(c.Param("kind"), c.Param("slug"))
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	server.PurgeTemplates(taxonomyTmplName)
+	writeAdmin(c, http.StatusOK, profile)
+}
+
+// adminReindexSearch rebuilds the search documents of every archive.
+func adminReindexSearch(c *server.Context) {
+	if err := services.RefreshArchivesSearch(); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	purgeArchiveCaches()
+	c.Status(http.StatusNoContent)
+}
+
+func adminPurgeCaches(c *server.Context) {
+	payload := &struct {
+		Archives    bool `json:"archives"`
+		Taxonomies  bool `json:"taxonomies"`
+		Templates   bool `json:"templates"`
+		Submissions bool `json:"submissions"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	if payload.Archives {
+		log.Println("Purging archives cache...")
+		cache.Archives.Purge()
+	}
+
+	if payload.Taxonomies {
+		log.Println("Purging taxonomies cache...")
+		services.PurgeTaxonomies()
+	}
+
+	if payload.Templates {
+		log.Println("Purging templates cache...")
+		cache.Templates.Purge()
+	}
+
+	if payload.Submissions {
+		log.Println("Purging submissions cache...")
+		cache.Submissions.Purge()
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func adminReloadTemplates(c *server.Context) {
+	log.Println("Reloading templates...")
+	server.LoadTemplates()
+	cache.Templates.Purge()
+	c.Status(http.StatusNoContent)
+}
+
+func adminReloadLists(c *server.Context) {
+	payload := &struct {
+		Aliases       bool `json:"aliases"`
+		Blacklists    bool `json:"blacklists"`
+		Implications  bool `json:"implications"`
+		Metadatas     bool `json:"metadatas"`
+		TaxonomyKinds bool `json:"taxonomyKinds"`
+	}{}
+	if !bindAdminPayload(c, payload) {
+		return
+	}
+
+	if err := doReloadLists(payload.Aliases, payload.Blacklists, payload.Implications, payload.Metadatas); err != nil {
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+
+	if payload.TaxonomyKinds {
+		if err := doReloadTaxonomyKinds(); err != nil {
+			writeV1Error(c, errs.Unknown)
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func adminWebhooks(c *server.Context) {
+	webhooks := make([]*AdminWebhook, len(Config.Webhooks))
+	for i, webhook := range Config.Webhooks {
+		webhooks[i] = &AdminWebhook{Name: webhook.Name, URL: webhook.URL, Events: webhook.Events}
+	}
+	writeAdmin(c, http.StatusOK, webhooks)
+}
+
+// adminDeliveries lists the latest deliveries of the webhooks,
+// optionally only those of a state or of a webhook.
+func adminDeliveries(c *server.Context) {
+	page, limit := getV1Pagination(c, adminDeliveriesLimit, adminMaxDeliveriesLimit)
+	deliveries, err := services.ListWebhookDeliveries(c.Query("state"), c.Query("webhook"), limit, limit*(page-1))
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	writeAdmin(c, http.StatusOK, deliveries)
+}
+
+func adminRetryDelivery(c *server.Context) {
+	id, ok := getAdminID(c, errs.DeliveryNotFound)
+	if !ok {
+		return
+	}
+
+	if err := services.RetryWebhookDelivery(id); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
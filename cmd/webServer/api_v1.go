@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	. "koushoku/config"
+
+	"koushoku/errs"
+	"koushoku/modext"
+	"koushoku/server"
+	"koushoku/services"
+)
+
+// The routes of the public API, whose responses are all wrapped in the
+// same envelopes: {"data": ..., "meta": ...} or {"error": ...}.
+const (
+	apiV1Prefix = "/api/v1"
+
+	apiV1ArchivesLimit      = 25
+	apiV1MaxArchivesLimit   = 100
+	apiV1TaxonomiesLimit    = 200
+	apiV1MaxTaxonomiesLimit = 1000
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// V1Response is the envelope of the successful responses.
+type V1Response struct {
+	Data any     `json:"data"`
+	Meta *V1Meta `json:"meta,omitempty"`
+}
+
+// V1Meta is the pagination of a list, along with the facets and the
+// corrected query of the archive searches.
+type V1Meta struct {
+	Total      int    `json:"total"`
+	Page       int    `json:"page,omitempty"`
+	Limit      int    `json:"limit,omitempty"`
+	TotalPages int    `json:"totalPages,omitempty"`
+	Next       string `json:"next,omitempty"`
+
+	Facets     []*services.Facet `json:"facets,omitempty"`
+	DidYouMean string            `json:"didYouMean,omitempty"`
+}
+
+// V1Error is the body of the error responses, Code being
+// one of the codes of the errs package.
+type V1Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// Position is the offset in the query of a search syntax error.
+	Position *int `json:"position,omitempty"`
+}
+
+// V1Page is an entry of the page manifest of an archive.
+type V1Page struct {
+	Page      int    `json:"page"`
+	URL       string `json:"url"`
+	Thumbnail string `json:"thumbnail"`
+}
+
+// V1Pages is the page manifest of an archive.
+type V1Pages struct {
+	ID    int64     `json:"id"`
+	Pages []*V1Page `json:"pages"`
+}
+
+// V1Submission is a submission along with its name,
+// which is shown publicly on the submissions page.
+type V1Submission struct {
+	*modext.Submission
+	Name string `json:"name"`
+}
+
+// V1Stats are the statistics of the archives and of the taxonomies.
+type V1Stats struct {
+	ArchiveCount     int64 `json:"archiveCount"`
+	ArtistCount      int64 `json:"artistCount"`
+	CircleCount      int64 `json:"circleCount"`
+	MagazineCount    int64 `json:"magazineCount"`
+	ParodyCount      int64 `json:"parodyCount"`
+	TagCount         int64 `json:"tagCount"`
+	PageCount        int64 `json:"pageCount"`
+	AveragePageCount int64 `json:"averagePageCount"`
+	Size             int64 `json:"size"`
+	AverageSize      int64 `json:"averageSize"`
+}
+
+// writeV1 writes the response with an ETag of its body, answering
+// with 304 Not Modified if the client already has it. The responses
+// to admin requests may differ and are kept out of shared caches.
+func writeV1(c *server.Context, v any) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		writeV1Error(c, errs.Unknown)
+		return
+	}
+
+	sum := sha1.Sum(buf)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	c.Header("ETag", etag)
+	if isAdminRequest(c) {
+		c.Header("Cache-Control", "private, no-cache")
+	} else {
+		c.Header("Cache-Control", "public, no-cache")
+	}
+
+	for _, v := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", buf)
+}
+
+// writeV1Error writes the error with its code and status.
+func writeV1Error(c *server.Context, err error) {
+	body := &V1Error{Code: errs.Code(err), Message: err.Error()}
+
+	var syntaxErr *services.SearchSyntaxError
+	if errors.As(err, &syntaxErr) {
+		body.Code = "search_syntax_invalid"
+		body.Message = syntaxErr.Message
+		body.Position = &syntaxErr.Pos
+	}
+	c.AbortWithStatusJSON(errs.Status(err), map[string]any{"error": body})
+}
+
+// getV1Pagination returns the page and the limit of a list,
+// given as page= and limit= with the default and max limit.
+func getV1Pagination(c *server.Context, defaultLimit, maxLimit int) (page, limit int) {
+	page, _ = strconv.Atoi(c.Query("page"))
+	page = services.Max(page, 1)
+
+	limit, _ = strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	return page, services.Min(limit, maxLimit)
+}
+
+func newV1Meta(total, page, limit int) *V1Meta {
+	return &V1Meta{
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: int(math.Ceil(float64(total) / float64(limit))),
+	}
+}
+
+// apiV1Archives searches the archives with the full search syntax, the
+// requests authorized with the API key can use the admin-only fields.
+func apiV1Archives(c *server.Context) {
+	q := createNewSearchQueries(c)
+	page, limit := getV1Pagination(c, apiV1ArchivesLimit, apiV1MaxArchivesLimit)
+	q.Page = page
+
+	opts, err := getSearchOptions(c, q, isAdminRequest(c))
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	opts.Limit = limit
+	opts.Offset = limit * (page - 1)
+	opts.Cursor = q.Cursor
+	opts.Preloads = append(opts.Preloads, services.ArchiveRels.Parodies, services.TaxonomiesRel)
+
+	result := services.GetArchives(opts)
+	if result.Err != nil {
+		writeV1Error(c, result.Err)
+		return
+	}
+
+	meta := newV1Meta(result.Total, page, limit)
+	meta.Next = result.Next
+	meta.Facets = result.Facets
+	if result.Total == 0 && len(q.Search) > 0 {
+		meta.DidYouMean, _ = services.CorrectSearchQuery(q.Search)
+	}
+	writeV1(c, &V1Response{Data: result.Archives, Meta: meta})
+}
+
+// getV1Archive returns the archive of the id parameter.
+func getV1Archive(c *server.Context, preloads ...string) (*modext.Archive, bool) {
+	id, err := c.ParamInt64("id")
+	if err != nil {
+		writeV1Error(c, errs.ArchiveNotFound)
+		return nil, false
+	}
+
+	result := services.GetArchive(id, services.GetArchiveOptions{Preloads: preloads})
+	if result.Err != nil {
+		writeV1Error(c, result.Err)
+		return nil, false
+	}
+	return result.Archive, true
+}
+
+func apiV1Archive(c *server.Context) {
+	archive, ok := getV1Archive(c,
+		services.ArchiveRels.Artists,
+		services.ArchiveRels.Circles,
+		services.ArchiveRels.Magazines,
+		services.ArchiveRels.Parodies,
+		services.ArchiveRels.Tags,
+		services.ArchiveRels.Submission,
+		services.TaxonomiesRel,
+	)
+	if !ok {
+		return
+	}
+
+	// Like the archive pages, redirected archives redirect to their target.
+	if archive.RedirectId > 0 && archive.RedirectId != archive.ID {
+		c.Redirect(http.StatusFound, fmt.Sprintf("%s/archives/%d", apiV1Prefix, archive.RedirectId))
+		return
+	}
+	writeV1(c, &V1Response{Data: archive})
+}
+
+// apiV1ArchivePages returns the URLs of the pages of an archive
+// and of their thumbnails, served by the data server.
+func apiV1ArchivePages(c *server.Context) {
+	archive, ok := getV1Archive(c)
+	if !ok {
+		return
+	}
+
+	manifest := &V1Pages{ID: archive.ID, Pages: make([]*V1Page, archive.Pages)}
+	for i := range manifest.Pages {
+		manifest.Pages[i] = &V1Page{
+			Page:      i + 1,
+			URL:       fmt.Sprintf("%s/data/%d/%d.jpg", Config.Meta.DataBaseURL, archive.ID, i+1),
+			Thumbnail: fmt.Sprintf("%s/data/%d/%d/320.webp", Config.Meta.DataBaseURL, archive.ID, i+1),
+		}
+	}
+	writeV1(c, &V1Response{Data: manifest})
+}
+
+// apiV1TaxonomyKinds lists the taxonomy kinds, built in and user-defined.
+func apiV1TaxonomyKinds(c *server.Context) {
+	kinds := services.GetTaxonomyKinds().Kinds
+	writeV1(c, &V1Response{Data: kinds, Meta: &V1Meta{Total: len(kinds)}})
+}
+
+// getV1TaxonomyKind returns the kind parameter, either a kind or its route.
+func getV1TaxonomyKind(c *server.Context) (*modext.TaxonomyKind, bool) {
+	kind, ok := findTaxonomyKind(c.Param("kind"))
+	if !ok {
+		writeV1Error(c, errs.TaxonomyKindNotFound)
+	}
+	return kind, ok
+}
+
+// apiV1Taxonomies lists the taxonomies of a kind that have archives,
+// sorted and filtered like the listings of the website.
+func apiV1Taxonomies(c *server.Context) {
+	kind, ok := getV1TaxonomyKind(c)
+	if !ok {
+		return
+	}
+
+	page, limit := getV1Pagination(c, apiV1TaxonomiesLimit, apiV1MaxTaxonomiesLimit)
+	offset := limit * (page - 1)
+	opts := getListingOptions(c)
+
+	result := services.GetTaxonomies(kind.Kind, services.GetTaxonomiesOptions{Limit: limit, Offset: offset, TaxonomyListOptions: opts})
+	if result.Err != nil {
+		writeV1Error(c, result.Err)
+		return
+	}
+	writeV1(c, &V1Response{Data: result.Taxonomies, Meta: newV1Meta(result.Total, page, limit)})
+}
+
+// apiV1Taxonomy returns a taxonomy along with its profile, or redirects
+// to the new location of a taxonomy that has been merged or renamed.
+func apiV1Taxonomy(c *server.Context) {
+	kind, ok := getV1TaxonomyKind(c)
+	if !ok {
+		return
+	}
+
+	slug := c.Param("slug")
+	data, err := getTaxonomy(kind.Kind, slug)
+	if err != nil {
+		if target, ok := services.GetTaxonomyRedirect(kind.Kind, slug); ok {
+			c.Redirect(http.StatusMovedPermanently, fmt.Sprintf("%s/taxonomies/%s/%s", apiV1Prefix, kind.Kind, target))
+			return
+		}
+		writeV1Error(c, err)
+		return
+	}
+	writeV1(c, &V1Response{Data: data})
+}
+
+func apiV1Stats(c *server.Context) {
+	stats := services.GetStats()
+	writeV1(c, &V1Response{Data: &V1Stats{
+		ArchiveCount:     stats.ArchiveCount,
+		ArtistCount:      stats.ArtistCount,
+		CircleCount:      stats.CircleCount,
+		MagazineCount:    stats.MagazineCount,
+		ParodyCount:      stats.ParodyCount,
+		TagCount:         stats.TagCount,
+		PageCount:        stats.PageCount,
+		AveragePageCount: stats.AveragePageCount,
+		Size:             stats.Size,
+		AverageSize:      stats.AverageSize,
+	}})
+}
+
+func apiV1Submissions(c *server.Context) {
+	page, limit := getV1Pagination(c, listingLimit, listingLimit)
+	result := services.GetSubmissions(services.GetSubmissionsOptions{
+		Limit:  limit,
+		Offset: limit * (page - 1),
+	})
+	if result.Err != nil {
+		writeV1Error(c, result.Err)
+		return
+	}
+
+	submissions := make([]*V1Submission, len(result.Submissions))
+	for i, submission := range result.Submissions {
+		submissions[i] = &V1Submission{Submission: submission, Name: submission.Name}
+	}
+	writeV1(c, &V1Response{Data: submissions, Meta: newV1Meta(result.Total, page, limit)})
+}
+
+// apiV1Submit creates a submission from a JSON body of the
+// same fields as the form of the submit page.
+func apiV1Submit(c *server.Context) {
+	payload := &struct {
+		Name      string `json:"name"`
+		Submitter string `json:"submitter"`
+		Content   string `json:"content"`
+	}{}
+	if err := c.ShouldBindJSON(payload); err != nil {
+		writeV1Error(c, err)
+		return
+	}
+
+	submission, err := services.CreateSubmission(payload.Name, payload.Submitter, payload.Content)
+	if err != nil {
+		writeV1Error(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, &V1Response{Data: &V1Submission{Submission: submission, Name: submission.Name}})
+}
+
+func apiV1OpenAPI(c *server.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", openAPIDocument)
+}
+
+// apiV1NotFound answers the unknown routes of the API.
+func apiV1NotFound(c *server.Context) {
+	c.AbortWithStatusJSON(http.StatusNotFound, map[string]any{
+		"error": &V1Error{Code: "route_not_found", Message: "Route does not exist"},
+	})
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"strings"
 
+	"koushoku/errs"
+	"koushoku/modext"
 	"koushoku/server"
 	"koushoku/services"
 )
@@ -12,19 +14,30 @@ import (
 const (
 	archiveTmplName = "archive.html"
 	readerTmplName  = "reader.html"
+
+	relatedArchives = 12
 )
 
-func archive(c *server.Context) {
-	if c.TryCache(archiveTmplName) {
-		return
-	}
+// ArchiveJSON is an archive along with its related archives.
+type ArchiveJSON struct {
+	*modext.Archive
+	Related []*modext.Archive `json:"related"`
+}
 
+func archive(c *server.Context) {
 	id, err := c.ParamInt64("id")
 	if err != nil {
 		c.HTML(http.StatusInternalServerError, "error.html")
 		return
 	}
 
+	// The views are counted before the cache is looked up,
+	// those of archives that do not exist are dropped on flush.
+	services.RecordArchiveView(id)
+	if c.TryCache(archiveTmplName) {
+		return
+	}
+
 	result := services.GetArchive(id, services.GetArchiveOptions{
 		Preloads: []string{
 			services.ArchiveRels.Artists,
@@ -33,6 +46,7 @@ func archive(c *server.Context) {
 			services.ArchiveRels.Parodies,
 			services.ArchiveRels.Tags,
 			services.ArchiveRels.Submission,
+			services.TaxonomiesRel,
 		},
 	})
 	if result.Err != nil {
@@ -61,10 +75,19 @@ func archive(c *server.Context) {
 		return
 	}
 
+	related, err := services.GetRelatedArchives(id, relatedArchives)
+	if err != nil {
+		c.SetData("error", err)
+		c.HTML(http.StatusInternalServerError, "error.html")
+		return
+	}
+
 	if isJson {
-		c.JSON(http.StatusOK, result.Archive)
+		c.JSON(http.StatusOK, &ArchiveJSON{Archive: result.Archive, Related: related})
 	} else {
 		c.SetData("archive", result.Archive)
+		c.SetData("archives", related)
+		c.SetData("taxonomyKinds", services.GetTaxonomyKinds().Custom())
 		c.Cache(http.StatusOK, archiveTmplName)
 	}
 }
@@ -112,3 +135,57 @@ func read(c *server.Context) {
 	c.SetData("pageNum", pageNum)
 	c.Cache(http.StatusOK, readerTmplName)
 }
+
+// getRandomArchive returns a random archive matching the search query
+// of the request. The responses vary on every request, so they are never
+// cached, whether by the server or by the browsers and proxies.
+func getRandomArchive(c *server.Context, preloads ...string) (*services.GetArchiveResult, error) {
+	c.Header("Cache-Control", "no-store")
+
+	q := createNewSearchQueries(c)
+	opts, err := getSearchOptions(c, q, false)
+	if err != nil {
+		return nil, err
+	}
+	return services.GetRandomArchive(opts, services.GetArchiveOptions{Preloads: preloads}), nil
+}
+
+func random(c *server.Context) {
+	result, err := getRandomArchive(c)
+	if err != nil {
+		c.SetData("error", err)
+		c.HTML(http.StatusBadRequest, "error.html")
+		return
+	} else if result.Err == errs.ArchiveNotFound {
+		c.SetData("error", "There are no archives that match your search criteria")
+		c.HTML(http.StatusNotFound, "error.html")
+		return
+	} else if result.Err != nil {
+		c.SetData("error", result.Err)
+		c.HTML(http.StatusInternalServerError, "error.html")
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/archive/%d/%s", result.Archive.ID, result.Archive.Slug))
+}
+
+func randomJSON(c *server.Context) {
+	result, err := getRandomArchive(c,
+		services.ArchiveRels.Artists,
+		services.ArchiveRels.Circles,
+		services.ArchiveRels.Magazines,
+		services.ArchiveRels.Parodies,
+		services.ArchiveRels.Tags,
+		services.TaxonomiesRel,
+	)
+	if err != nil {
+		c.ErrorJSON(http.StatusBadRequest, "Invalid search query", err)
+		return
+	} else if result.Err == errs.ArchiveNotFound {
+		c.ErrorJSON(http.StatusNotFound, "No archive matches the search query", result.Err)
+		return
+	} else if result.Err != nil {
+		c.ErrorJSON(http.StatusInternalServerError, "Failed to get a random archive", result.Err)
+		return
+	}
+	c.JSON(http.StatusOK, result.Archive)
+}
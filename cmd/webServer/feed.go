@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	. "koushoku/config"
+
+	"koushoku/server"
+	"koushoku/services"
+)
+
+const (
+	feedAtomTmplName = "feed_atom.xml"
+	feedRSSTmplName  = "feed_rss.xml"
+
+	atomContentType = "application/atom+xml; charset=utf-8"
+	rssContentType  = "application/rss+xml; charset=utf-8"
+)
+
+// feedTemplate returns the template of the feed format asked with
+// format=rss or format=atom, Atom by default, and sets its content type.
+func feedTemplate(c *server.Context) string {
+	if strings.EqualFold(c.Query("format"), "rss") {
+		c.Header("Content-Type", rssContentType)
+		return feedRSSTmplName
+	}
+	c.Header("Content-Type", atomContentType)
+	return feedAtomTmplName
+}
+
+// renderFeed renders the latest published archives matching the search
+// query as a feed, link being the page of the website it follows.
+func renderFeed(c *server.Context, tmplName string, q *SearchQueries, name, link string) {
+	opts, err := getSearchOptions(c, q, false)
+	if err != nil {
+		textError(c, err)
+		return
+	}
+
+	// The feeds follow the new releases, whatever the sort of the page.
+	opts.Sort = "published_at"
+	opts.Order = "desc"
+	opts.Offset = 0
+	opts.Preloads = append(opts.Preloads, services.ArchiveRels.Parodies)
+
+	result := services.GetArchives(opts)
+	if result.Err != nil {
+		textError(c, result.Err)
+		return
+	}
+
+	if len(name) > 0 {
+		c.SetData("name", fmt.Sprintf("%s: %s", Config.Meta.Title, name))
+	} else {
+		c.SetData("name", Config.Meta.Title)
+	}
+	c.SetData("link", link)
+	c.SetData("archives", result.Archives)
+	c.Cache(http.StatusOK, tmplName)
+}
+
+// feed follows the latest archives.
+func feed(c *server.Context) {
+	tmplName := feedTemplate(c)
+	if c.TryCache(tmplName) {
+		return
+	}
+
+	q := createNewSearchQueries(c)
+	q.Search = ""
+	renderFeed(c, tmplName, q, "", "/")
+}
+
+// searchFeed follows the archives matching the search query given as q.
+func searchFeed(c *server.Context) {
+	tmplName := feedTemplate(c)
+	if c.TryCache(tmplName) {
+		return
+	}
+
+	q := createNewSearchQueries(c)
+	renderFeed(c, tmplName, q, fmt.Sprintf("Search: %s", q.Search), "/search?"+url.Values{"q": {q.Search}}.Encode())
+}
+
+// taxonomyFeed follows the archives of a taxonomy of a kind, either
+// built in or user-defined, or redirects to the new location of a
+// taxonomy that has been merged or renamed.
+func taxonomyFeed(c *server.Context) {
+	tmplName := feedTemplate(c)
+	if c.TryCache(tmplName) {
+		return
+	}
+
+	kind, ok := services.GetTaxonomyKinds().GetByRoute(c.Param("kind"))
+	if !ok {
+		notFound(c)
+		return
+	}
+
+	slug := c.Param("slug")
+	taxonomy, err := services.GetTaxonomy(kind.Kind, slug)
+	if err != nil {
+		if target, ok := services.GetTaxonomyRedirect(kind.Kind, slug); ok {
+			location := fmt.Sprintf("/%s/%s/feed.xml", kind.Route, target)
+			if len(c.Request.URL.RawQuery) > 0 {
+				location += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusMovedPermanently, location)
+			return
+		}
+		textError(c, err)
+		return
+	}
+
+	q := createNewSearchQueries(c)
+	q.Search = fmt.Sprintf("%s:%s", kind.Kind, slug)
+	renderFeed(c, tmplName, q, taxonomy.Name, fmt.Sprintf("/%s/%s", kind.Route, slug))
+}
@@ -4,10 +4,11 @@ import (
 	"fmt"
 	"math"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
 
+	"koushoku/errs"
+	"koushoku/modext"
 	"koushoku/server"
 	"koushoku/services"
 )
@@ -17,10 +18,15 @@ type SearchQueries struct {
 	Page   int    `form:"page"`
 	Sort   string `form:"sort"`
 	Order  string `form:"order"`
+
+	// Cursor is only used by the JSON endpoints, in place of the page.
+	Cursor string `form:"cursor"`
 }
 
 const (
 	indexLimit      = 25
+	popularLimit    = 5
+	searchFacets    = 10
 	indexTmplName   = "index.html"
 	aboutTmplName   = "about.html"
 	statsTmplName   = "stats.html"
@@ -78,6 +84,26 @@ func index(c *server.Context) {
 		c.SetData("name", "Home")
 	}
 
+	if q.Page <= 1 {
+		popular := services.GetArchives(&services.GetArchivesOptions{
+			Limit: popularLimit,
+			Preloads: []string{
+				services.ArchiveRels.Artists,
+				services.ArchiveRels.Circles,
+				services.ArchiveRels.Magazines,
+				services.ArchiveRels.Tags,
+			},
+			Sort:     services.SortTrending,
+			Trending: true,
+		})
+		if popular.Err != nil {
+			c.SetData("error", popular.Err)
+			c.HTML(http.StatusInternalServerError, "error.html")
+			return
+		}
+		c.SetData("popular", popular.Archives)
+	}
+
 	totalPages := int(math.Ceil(float64(result.Total) / float64(indexLimit)))
 	c.SetData("archives", result.Archives)
 	c.SetData("total", result.Total)
@@ -86,20 +112,11 @@ func index(c *server.Context) {
 	c.Cache(http.StatusOK, indexTmplName)
 }
 
-var rgx = regexp.MustCompile(`(?i)-?(artist|circle|magazine|parody|tag|title|pages)(&|\|)?(\*)?:(<=?|>=?)?(\".*?\"|[^\s]+)`)
-
-const (
-	opAnd      = "&"
-	opOr       = "|"
-	opWildcard = "*"
-)
-
-func search(c *server.Context) {
-	if c.TryCache(searchTmplName) {
-		return
-	}
-
-	q := createNewSearchQueries(c)
+// getSearchOptions returns the options of the archives matching the search
+// query, or the syntax error of the query. A query made only of words looks
+// for the taxonomies of that name first, and then for the words themselves.
+// The admin-only fields are allowed when admin is set.
+func getSearchOptions(c *server.Context, q *SearchQueries, admin bool) (*services.GetArchivesOptions, error) {
 	opts := &services.GetArchivesOptions{
 		Limit:  indexLimit,
 		Offset: indexLimit * (q.Page - 1),
@@ -111,189 +128,66 @@ func search(c *server.Context) {
 		},
 		Sort:  q.Sort,
 		Order: q.Order,
+		Admin: admin,
 	}
 
-	if len(q.Search) > 0 {
-		matches := rgx.FindAllStringSubmatch(q.Search, -1)
-		if len(matches) > 0 {
-			for _, match := range matches {
-				isExclude := strings.HasPrefix(match[0], "-")
-				isAnd := strings.EqualFold(strings.TrimSpace(match[2]), opAnd)
-				isWildcard := strings.EqualFold(strings.TrimSpace(match[3]), opWildcard)
-
-				value := match[5]
-				values := strings.Split(value, ",")
-				numOp := strings.TrimSpace(match[4])
-
-				switch match[1] {
-				case "artist":
-					for _, v := range values {
-						v = strings.TrimSpace(v)
-						if isExclude {
-							if isWildcard {
-								opts.ExcludedArtistsWildcard = append(opts.ExcludedArtistsWildcard, v)
-							} else {
-								opts.ExcludedArtistsMatch = append(opts.ExcludedArtistsMatch, v)
-							}
-						} else if isAnd {
-							if isWildcard {
-								opts.ArtistsWildcardAnd = append(opts.ArtistsWildcardAnd, v)
-							} else {
-								opts.ArtistsMatchAnd = append(opts.ArtistsMatchAnd, v)
-							}
-						} else if isWildcard {
-							opts.ArtistsWildcard = append(opts.ArtistsWildcard, v)
-						} else {
-							opts.ArtistsMatch = append(opts.ArtistsMatch, v)
-						}
-					}
-				case "circle":
-					for _, v := range values {
-						v = strings.TrimSpace(v)
-						if isExclude {
-							if isWildcard {
-								opts.ExcludedCirclesWildcard = append(opts.ExcludedCirclesWildcard, v)
-							} else {
-								opts.ExcludedCirclesMatch = append(opts.ExcludedCirclesMatch, v)
-							}
-						} else if isAnd {
-							if isWildcard {
-								opts.CirclesWildcardAnd = append(opts.CirclesWildcardAnd, v)
-							} else {
-								opts.CirclesMatchAnd = append(opts.CirclesMatchAnd, v)
-							}
-						} else if isWildcard {
-							opts.CirclesWildcard = append(opts.CirclesWildcard, v)
-						} else {
-							opts.CirclesMatch = append(opts.CirclesMatch, v)
-						}
-					}
-				case "magazine":
-					for _, v := range values {
-						v = strings.TrimSpace(v)
-						if isExclude {
-							if isWildcard {
-								opts.ExcludedMagazinesWildcard = append(opts.ExcludedMagazinesWildcard, v)
-							} else {
-								opts.ExcludedMagazinesMatch = append(opts.ExcludedMagazinesMatch, v)
-							}
-						} else if isAnd {
-							if isWildcard {
-								opts.MagazinesWildcardAnd = append(opts.MagazinesWildcardAnd, v)
-							} else {
-								opts.MagazinesMatchAnd = append(opts.MagazinesMatchAnd, v)
-							}
-						} else if isWildcard {
-							opts.MagazinesWildcard = append(opts.MagazinesWildcard, v)
-						} else {
-							opts.MagazinesMatch = append(opts.MagazinesMatch, v)
-						}
-					}
-				case "pages":
-					n, _ := strconv.Atoi(value)
-					if strings.EqualFold(numOp, ">") {
-						opts.PagesGt = n
-					} else if strings.EqualFold(numOp, ">=") {
-						opts.PagesGte = n
-					} else if strings.EqualFold(numOp, "<") {
-						opts.PagesLt = n
-					} else if strings.EqualFold(numOp, "<=") {
-						opts.PagesLte = n
-					} else {
-						opts.PagesEq = n
-					}
-				case "parody":
-					for _, v := range values {
-						v = strings.TrimSpace(v)
-						if isExclude {
-							if isWildcard {
-								opts.ExcludedParodiesWildcard = append(opts.ExcludedParodiesWildcard, v)
-							} else {
-								opts.ExcludedParodiesMatch = append(opts.ExcludedParodiesMatch, v)
-							}
-						} else if isAnd {
-							if isWildcard {
-								opts.ParodiesWildcardAnd = append(opts.ParodiesWildcardAnd, v)
-							} else {
-								opts.ParodiesMatchAnd = append(opts.ParodiesMatchAnd, v)
-							}
-						} else if isWildcard {
-							opts.ParodiesWildcard = append(opts.ParodiesWildcard, v)
-						} else {
-							opts.ParodiesMatch = append(opts.ParodiesMatch, v)
-						}
-					}
-				case "tag":
-					for _, v := range values {
-						v = strings.TrimSpace(v)
-						if isExclude {
-							if isWildcard {
-								opts.ExcludedTagsWildcard = append(opts.ExcludedTagsWildcard, v)
-							} else {
-								opts.ExcludedTagsMatch = append(opts.ExcludedTagsMatch, v)
-							}
-						} else if isAnd {
-							if isWildcard {
-								opts.TagsWildcardAnd = append(opts.TagsWildcardAnd, v)
-							} else {
-								opts.TagsMatchAnd = append(opts.TagsMatchAnd, v)
-							}
-						} else if isWildcard {
-							opts.TagsWildcard = append(opts.TagsWildcard, v)
-						} else {
-							opts.TagsMatch = append(opts.TagsMatch, v)
-						}
-					}
-				case "title":
-					if isWildcard {
-						opts.TitleWildcard = strings.Join(values, ",")
-					} else {
-						opts.TitleMatch = strings.Join(values, ",")
-					}
-				}
-			}
-		} else {
-			if services.IsArtistValid(q.Search) {
-				opts.ArtistsMatch = append(opts.ArtistsMatch, q.Search)
-			}
+	parse := services.ParseSearchQuery
+	if admin {
+		parse = services.ParseAdminSearchQuery
+	}
 
-			if services.IsCircleValid(q.Search) {
-				opts.CirclesMatch = append(opts.CirclesMatch, q.Search)
-			}
+	query, err := parse(q.Search)
+	if err != nil {
+		return nil, err
+	} else if query == nil {
+		return opts, nil
+	}
+	opts.Facets = searchFacets
 
-			if services.IsParodyValid(q.Search) {
-				opts.ParodiesMatch = append(opts.ParodiesMatch, q.Search)
-			}
+	if !query.IsText() {
+		opts.Query = query
+		return opts, nil
+	}
+
+	for _, kind := range services.GetTaxonomyKinds().Kinds {
+		if services.IsTaxonomyValid(kind.Kind, q.Search) {
+			tq := opts.Taxonomy(kind.Kind)
+			tq.Match = append(tq.Match, q.Search)
+		}
+	}
 
-			if services.IsTagValid(q.Search) {
-				opts.TagsMatch = append(opts.TagsMatch, q.Search)
-			} else {
-				arr := strings.Split(q.Search, " ")
-				if len(arr) > 1 {
-					for _, v := range arr {
-						if services.IsTagValid(v) {
-							opts.TagsMatch = append(opts.TagsMatch, v)
-						}
-					}
+	if _, ok := opts.Taxonomies["tag"]; !ok {
+		arr := strings.Split(q.Search, " ")
+		if len(arr) > 1 {
+			for _, v := range arr {
+				if services.IsTaxonomyValid("tag", v) {
+					tq := opts.Taxonomy("tag")
+					tq.Match = append(tq.Match, v)
 				}
 			}
+		}
+	}
 
-			if len(opts.ArtistsMatch) == 0 && len(opts.CirclesMatch) == 0 &&
-				len(opts.MagazinesMatch) == 0 && len(opts.ParodiesMatch) == 0 &&
-				len(opts.TagsMatch) == 0 {
-				opts.Path = q.Search
-			}
+	if len(opts.Taxonomies) == 0 {
+		opts.Text = query.Value
+
+		// A free-text search is ranked by relevance unless asked otherwise.
+		if len(c.Query("sort")) == 0 {
+			q.Sort = services.SortRelevance
+			opts.Sort = q.Sort
 		}
 	}
+	return opts, nil
+}
 
-	result := services.GetArchives(opts)
-	if result.Err != nil {
-		c.SetData("error", result.Err)
-		c.HTML(http.StatusInternalServerError, "error.html")
+func search(c *server.Context) {
+	if c.TryCache(searchTmplName) {
 		return
 	}
 
+	q := createNewSearchQueries(c)
 	c.SetData("queries", q)
+
 	hasQueries := len(q.Search) > 0
 	c.SetData("hasQueries", hasQueries)
 
@@ -303,8 +197,24 @@ func search(c *server.Context) {
 		c.SetData("name", "Browse")
 	}
 
+	opts, err := getSearchOptions(c, q, false)
+	if err != nil {
+		// Not cached, every malformed query would take an entry of its own.
+		c.SetData("searchError", err)
+		c.HTML(http.StatusBadRequest, searchTmplName)
+		return
+	}
+
+	result := services.GetArchives(opts)
+	if result.Err != nil {
+		c.SetData("error", result.Err)
+		c.HTML(http.StatusInternalServerError, "error.html")
+		return
+	}
+
 	c.SetData("archives", result.Archives)
 	c.SetData("total", result.Total)
+	c.SetData("facets", result.Facets)
 
 	totalPages := int(math.Ceil(float64(result.Total) / float64(indexLimit)))
 	c.SetData("pagination", services.CreatePagination(q.Page, totalPages))
@@ -312,10 +222,65 @@ func search(c *server.Context) {
 	if len(result.Archives) > 0 {
 		c.Cache(http.StatusOK, searchTmplName)
 	} else {
+		if result.Total == 0 {
+			if query, ok := services.CorrectSearchQuery(q.Search); ok {
+				c.SetData("didYouMean", query)
+			}
+		}
 		c.Cache(http.StatusNotFound, searchTmplName)
 	}
 }
 
+// SearchJSONResult is the result of a search, along with
+// a corrected query when it has no results.
+type SearchJSONResult struct {
+	*services.GetArchivesResult
+	DidYouMean string `json:"didYouMean,omitempty"`
+}
+
+// searchJSON returns the search results as JSON, or the syntax error
+// of the query with its position. The results can be paged either with
+// page or with the cursor given as next by the previous page, and come
+// with a corrected query as didYouMean when there are none. The requests
+// authorized with the API key as a bearer token can use the admin-only fields.
+func searchJSON(c *server.Context) {
+	q := createNewSearchQueries(c)
+	opts, err := getSearchOptions(c, q, isAdminRequest(c))
+	if err != nil {
+		c.ErrorJSON(http.StatusBadRequest, "Invalid search query", err)
+		return
+	}
+	opts.Cursor = q.Cursor
+
+	result := services.GetArchives(opts)
+	if result.Err == errs.CursorInvalid {
+		c.ErrorJSON(http.StatusBadRequest, "Invalid cursor", result.Err)
+		return
+	} else if result.Err != nil {
+		c.ErrorJSON(http.StatusInternalServerError, "Failed to get archives", result.Err)
+		return
+	}
+
+	res := &SearchJSONResult{GetArchivesResult: result}
+	if result.Total == 0 && len(q.Search) > 0 {
+		res.DidYouMean, _ = services.CorrectSearchQuery(q.Search)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func suggest(c *server.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	suggestions, err := services.GetSuggestions(c.Query("q"), c.Query("kind"), limit)
+	if err == errs.TaxonomyKindInvalid {
+		c.ErrorJSON(http.StatusBadRequest, "Invalid taxonomy kind", err)
+		return
+	} else if err != nil {
+		c.ErrorJSON(http.StatusInternalServerError, "Failed to get suggestions", err)
+		return
+	}
+	c.JSON(http.StatusOK, suggestions)
+}
+
 func about(c *server.Context) {
 	if !c.TryCache(aboutTmplName) {
 		c.Cache(http.StatusOK, aboutTmplName)
@@ -340,27 +305,22 @@ func sitemap(c *server.Context) {
 		return
 	}
 
-	artists := services.GetArtists(services.GetArtistsOptions{Limit: 10000})
-	if artists.Err != nil {
-		c.ErrorJSON(http.StatusInternalServerError, "Failed to get artists", artists.Err)
-		return
+	type sitemapTaxonomies struct {
+		Route      string
+		Taxonomies []*modext.Taxonomy
 	}
 
-	magazines := services.GetMagazines(services.GetMagazinesOptions{Limit: 10000})
-	if magazines.Err != nil {
-		c.ErrorJSON(http.StatusInternalServerError, "Failed to get magazines", magazines.Err)
-		return
-	}
-
-	tags := services.GetTags(services.GetTagsOptions{Limit: 10000})
-	if tags.Err != nil {
-		c.ErrorJSON(http.StatusInternalServerError, "Failed to get tags", tags.Err)
-		return
+	var taxonomies []sitemapTaxonomies
+	for _, kind := range services.GetTaxonomyKinds().Kinds {
+		result := services.GetTaxonomies(kind.Kind, services.GetTaxonomiesOptions{Limit: 10000})
+		if result.Err != nil {
+			c.ErrorJSON(http.StatusInternalServerError, fmt.Sprintf("Failed to get %s", strings.ToLower(kind.Plural)), result.Err)
+			return
+		}
+		taxonomies = append(taxonomies, sitemapTaxonomies{kind.Route, result.Taxonomies})
 	}
 
 	c.SetData("archives", archives.Archives)
-	c.SetData("artists", artists.Artists)
-	c.SetData("magazines", magazines.Magazines)
-	c.SetData("tags", tags.Tags)
+	c.SetData("taxonomies", taxonomies)
 	c.Cache(http.StatusOK, sitemapTmplName)
 }
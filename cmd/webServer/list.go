@@ -5,7 +5,9 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"koushoku/modext"
 	"koushoku/server"
 	"koushoku/services"
 )
@@ -15,121 +17,77 @@ const (
 	listingTmplName = "list.html"
 )
 
-func artists(c *server.Context) {
-	if c.TryCache(listingTmplName) {
-		return
-	}
-
-	page, _ := strconv.Atoi(c.Query("page"))
-	result := services.GetArtists(services.GetArtistsOptions{
-		Limit:  listingLimit,
-		Offset: listingLimit * (page - 1),
-	})
-	if result.Err != nil {
-		c.SetData("error", result.Err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
-	}
-
-	c.SetData("page", page)
-	if page > 0 {
-		c.SetData("name", fmt.Sprintf("Artists: Page %d", page))
-	} else {
-		c.SetData("name", "Artists")
-	}
-
-	c.SetData("taxonomy", "artists")
-	c.SetData("taxonomyTitle", "Artists")
-
-	c.SetData("data", result.Artists)
-	c.SetData("total", result.Total)
-
-	totalPages := int(math.Ceil(float64(result.Total) / float64(listingLimit)))
-	c.SetData("pagination", services.CreatePagination(page, totalPages))
+// ListingJSON is a page of a taxonomy listing.
+type ListingJSON struct {
+	Data       any `json:"data"`
+	Total      int `json:"total"`
+	Page       int `json:"page,omitempty"`
+	TotalPages int `json:"totalPages,omitempty"`
+}
 
-	c.Cache(http.StatusOK, listingTmplName)
+// getListingOptions returns the sort and the filters of a listing, given
+// as sort=name|count, order=asc|desc, prefix= and filter=.
+func getListingOptions(c *server.Context) services.TaxonomyListOptions {
+	opts := services.TaxonomyListOptions{
+		Sort:   c.Query("sort"),
+		Order:  c.Query("order"),
+		Prefix: c.Query("prefix"),
+		Filter: c.Query("filter"),
+	}
+	opts.Validate()
+	return opts
 }
 
-func circles(c *server.Context) {
-	if c.TryCache(listingTmplName) {
-		return
+// renderListing renders a listing as JSON if its path ends with .json,
+// or as HTML along with its sort and filters.
+func renderListing(c *server.Context, opts services.TaxonomyListOptions, data any, total, page int, paginated bool) {
+	totalPages := 1
+	if paginated {
+		totalPages = int(math.Ceil(float64(total) / float64(listingLimit)))
 	}
 
-	page, _ := strconv.Atoi(c.Query("page"))
-	result := services.GetCircles(services.GetCirclesOptions{
-		Limit:  listingLimit,
-		Offset: listingLimit * (page - 1),
-	})
-	if result.Err != nil {
-		c.SetData("error", result.Err)
-		c.HTML(http.StatusInternalServerError, "error.html")
+	if strings.HasSuffix(c.Request.URL.Path, ".json") {
+		c.JSON(http.StatusOK, &ListingJSON{Data: data, Total: total, Page: services.Max(page, 1), TotalPages: totalPages})
 		return
 	}
 
-	c.SetData("page", page)
-	if page > 0 {
-		c.SetData("name", fmt.Sprintf("Circles: Page %d", page))
-	} else {
-		c.SetData("name", "Circles")
+	c.SetData("listing", opts)
+	c.SetData("data", data)
+	c.SetData("total", total)
+	if paginated {
+		c.SetData("pagination", services.CreatePagination(page, totalPages))
 	}
-
-	totalPages := int(math.Ceil(float64(result.Total) / float64(listingLimit)))
-	c.SetData("taxonomy", "circles")
-	c.SetData("taxonomyTitle", "Circles")
-	c.SetData("data", result.Circles)
-	c.SetData("total", result.Total)
-	c.SetData("pagination", services.CreatePagination(page, totalPages))
-
 	c.Cache(http.StatusOK, listingTmplName)
 }
 
-func magazines(c *server.Context) {
+// taxonomies lists the taxonomies of a kind, either built in or user-defined,
+// under the route of the kind, e.g. /artists or /artists.json. The kinds are
+// looked up on every request, so that the kinds declared at runtime are
+// listed as well. The tags are listed on a single page, grouped by namespace.
+func taxonomies(c *server.Context) {
 	if c.TryCache(listingTmplName) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.Query("page"))
-	opts := services.GetMagazinesOptions{
-		Limit:  listingLimit,
-		Offset: listingLimit * (page - 1),
-	}
-
-	result := services.GetMagazines(opts)
-	if result.Err != nil {
-		c.SetData("error", result.Err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
-	}
-
-	c.SetData("page", page)
-	if page > 0 {
-		c.SetData("name", fmt.Sprintf("Magazines: Page %d", page))
-	} else {
-		c.SetData("name", "Magazines")
-	}
-
-	totalPages := int(math.Ceil(float64(result.Total) / float64(listingLimit)))
-	c.SetData("taxonomy", "magazines")
-	c.SetData("taxonomyTitle", "Magazines")
-	c.SetData("data", result.Magazines)
-	c.SetData("total", result.Total)
-	c.SetData("pagination", services.CreatePagination(page, totalPages))
-
-	c.Cache(http.StatusOK, listingTmplName)
-}
-
-func parodies(c *server.Context) {
-	if c.TryCache(listingTmplName) {
+	kind, ok := services.GetTaxonomyKinds().GetByRoute(strings.TrimSuffix(c.Param("kind"), ".json"))
+	if !ok {
+		notFound(c)
 		return
 	}
 
 	page, _ := strconv.Atoi(c.Query("page"))
-	opts := services.GetParodiesOptions{
-		Limit:  listingLimit,
-		Offset: listingLimit * (page - 1),
+	opts := getListingOptions(c)
+	paginated := kind.Kind != "tag"
+
+	listing := services.GetTaxonomiesOptions{TaxonomyListOptions: opts}
+	if paginated {
+		listing.Limit = listingLimit
+		listing.Offset = listingLimit * (page - 1)
+	} else {
+		page = 0
 	}
 
-	result := services.GetParodies(opts)
+	result := services.GetTaxonomies(kind.Kind, listing)
 	if result.Err != nil {
 		c.SetData("error", result.Err)
 		c.HTML(http.StatusInternalServerError, "error.html")
@@ -138,38 +96,24 @@ func parodies(c *server.Context) {
 
 	c.SetData("page", page)
 	if page > 0 {
-		c.SetData("name", fmt.Sprintf("Parodies: Page %d", page))
+		c.SetData("name", fmt.Sprintf("%s: Page %d", kind.Plural, page))
 	} else {
-		c.SetData("name", "Parodies")
+		c.SetData("name", kind.Plural)
 	}
 
-	totalPages := int(math.Ceil(float64(result.Total) / float64(listingLimit)))
-	c.SetData("taxonomy", "parodies")
-	c.SetData("taxonomyTitle", "Parodies")
-	c.SetData("data", result.Parodies)
-	c.SetData("total", result.Total)
-	c.SetData("pagination", services.CreatePagination(page, totalPages))
-
-	c.Cache(http.StatusOK, listingTmplName)
-}
-
-func tags(c *server.Context) {
-	if c.TryCache(listingTmplName) {
-		return
+	c.SetData("taxonomy", kind.Route)
+	c.SetData("taxonomyTitle", kind.Plural)
+	if !paginated {
+		c.SetData("groups", services.GroupTagsByNamespace(result.Taxonomies))
 	}
+	renderListing(c, opts, result.Taxonomies, result.Total, page, paginated)
+}
 
-	result := services.GetTags(services.GetTagsOptions{})
-	if result.Err != nil {
-		c.SetData("error", result.Err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
+// findTaxonomyKind returns the kind of the given name or route.
+func findTaxonomyKind(s string) (*modext.TaxonomyKind, bool) {
+	kinds := services.GetTaxonomyKinds()
+	if kind, ok := kinds.Get(s); ok {
+		return kind, true
 	}
-
-	c.SetData("name", "Tags")
-	c.SetData("taxonomy", "tags")
-	c.SetData("taxonomyTitle", "Tags")
-	c.SetData("data", result.Tags)
-	c.SetData("total", result.Total)
-
-	c.Cache(http.StatusOK, listingTmplName)
+	return kinds.GetByRoute(s)
 }
@@ -3,6 +3,8 @@ package main
 import (
 	"net/http"
 	"path/filepath"
+	"strings"
+	"time"
 
 	. "koushoku/config"
 
@@ -18,9 +20,17 @@ func main() {
 	database.Init()
 	cache.Init()
 
+	services.InitAliases()
+	services.InitBlacklists()
+	services.InitMetadatas()
+	services.InitTaxonomyKinds()
+	handleSignals()
+
 	if err := services.AnalyzeStats(); err != nil {
 		return
 	}
+	services.StartArchiveStatsFlusher(time.Minute)
+	services.StartWebhookDeliverer(10 * time.Second)
 	server.Init()
 
 	assets := server.Group("/")
@@ -46,33 +56,114 @@ func main() {
 	server.GET("/", index)
 	server.GET("/about", server.WithName("About"), about)
 	server.GET("/search", search)
+	server.GET("/search.json", searchJSON)
+	server.GET("/search/feed.xml", searchFeed)
+	server.GET("/feed.xml", feed)
+	server.GET("/random", random)
+	server.GET("/api/random", randomJSON)
+	server.GET("/api/suggest", server.WithRateLimit("Suggest", "120-M"), suggest)
 	server.GET("/stats", server.WithName("Stats"), stats)
 	server.GET("/sitemap.xml", sitemap)
 
 	server.GET("/archive/:id", archive)
 	server.GET("/archive/:id/:slug", archive)
 	server.GET("/archive/:id/:slug/:pageNum", read)
-	server.GET("/artists", artists)
-	server.GET("/artists/:slug", artist)
-	server.GET("/circles", circles)
-	server.GET("/circles/:slug", circle)
-	server.GET("/magazines", magazines)
-	server.GET("/magazines/:slug", magazine)
-	server.GET("/parodies", parodies)
-	server.GET("/parodies/:slug", parody)
-	server.GET("/tags", tags)
-	server.GET("/tags/:slug", tag)
+
+	// The taxonomies are routed by the route of their kind, which is looked
+	// up on every request, so that a newly declared kind is routed as well.
+	server.GET("/:kind", taxonomies)
+	server.GET("/:kind/:slug", taxonomy)
+	server.GET("/:kind/:slug/feed.xml", taxonomyFeed)
 
 	server.GET("/submit", server.WithName("Submit"), submit)
 	server.POST("/submit", server.WithName("Submit"), server.WithRateLimit("Submit?", "10-D"), submitPost)
 	server.GET("/submissions", submisisions)
 
-	server.POST("/api/purge-cache", purgeCache)
-	server.POST("/api/reload-templates", reloadTemplates)
-
-	server.NoRoute(func(c *server.Context) {
-		c.HTML(http.StatusNotFound, "error.html")
-	})
+	server.GET(apiV1Prefix+"/openapi.json", apiV1OpenAPI)
+	server.GET(apiV1Prefix+"/archives", apiV1Archives)
+	server.GET(apiV1Prefix+"/archives/:id", apiV1Archive)
+	server.GET(apiV1Prefix+"/archives/:id/pages", apiV1ArchivePages)
+	server.GET(apiV1Prefix+"/taxonomies", apiV1TaxonomyKinds)
+	server.GET(apiV1Prefix+"/taxonomies/:kind", apiV1Taxonomies)
+	server.GET(apiV1Prefix+"/taxonomies/:kind/:slug", apiV1Taxonomy)
+	server.GET(apiV1Prefix+"/stats", apiV1Stats)
+	server.GET(apiV1Prefix+"/submissions", apiV1Submissions)
+	server.POST(apiV1Prefix+"/submissions", server.WithRateLimit("Submit?", "10-D"), apiV1Submit)
+
+	server.GET(opdsPrefix, withOPDSAuth, opdsRoot)
+	server.GET(opdsPrefix+"/search.xml", withOPDSAuth, openSearch)
+	server.GET(opdsPrefix+"/archives", withOPDSAuth, opdsArchives)
+	server.GET(opdsPrefix+"/taxonomies/:kind", withOPDSAuth, opdsTaxonomies)
+	server.GET(opdsPrefix+"/taxonomies/:kind/:slug", withOPDSAuth, opdsTaxonomy)
+
+	archivesScope := withAdminScope(scopeArchives)
+	submissionsScope := withAdminScope(scopeSubmissions)
+	listsScope := withAdminScope(scopeLists)
+	taxonomiesScope := withAdminScope(scopeTaxonomies)
+	cachesScope := withAdminScope(scopeCaches)
+	webhooksScope := withAdminScope(scopeWebhooks)
+
+	server.GET(apiAdminPrefix+"/key", withAdminScope(""), adminKey)
+
+	server.POST(apiAdminPrefix+"/archives/:id/publish", archivesScope, adminArchive(services.PublishArchive))
+	server.POST(apiAdminPrefix+"/archives/:id/unpublish", archivesScope, adminArchive(services.UnpublishArchive))
+	server.POST(apiAdminPrefix+"/archives/:id/expunge", archivesScope, adminArchive(expungeArchive))
+	server.POST(apiAdminPrefix+"/archives/:id/restore", archivesScope, adminArchive(restoreArchive))
+	server.POST(apiAdminPrefix+"/archives/:id/quarantine", archivesScope, adminQuarantineArchive)
+	server.POST(apiAdminPrefix+"/archives/:id/redirect", archivesScope, adminRedirectArchive)
+	server.POST(apiAdminPrefix+"/archives/:id/source", archivesScope, adminSetArchiveSource)
+	server.POST(apiAdminPrefix+"/archives/:id/release", archivesScope, adminReleaseArchive)
+	server.DELETE(apiAdminPrefix+"/archives/:id", archivesScope, adminDeleteArchive)
+	server.POST(apiAdminPrefix+"/archives/publish", archivesScope, adminArchives(services.PublishArchives))
+	server.POST(apiAdminPrefix+"/archives/unpublish", archivesScope, adminArchives(services.UnpublishArchives))
+	server.DELETE(apiAdminPrefix+"/archives", archivesScope, adminDeleteArchives)
+	server.GET(apiAdminPrefix+"/moderations", archivesScope, adminModerations)
+	server.POST(apiAdminPrefix+"/moderations/apply", archivesScope, adminApplyModeration)
+	server.POST(apiAdminPrefix+"/search/reindex", archivesScope, adminReindexSearch)
+
+	server.GET(apiAdminPrefix+"/submissions", submissionsScope, adminSubmissions)
+	server.POST(apiAdminPrefix+"/submissions/:id/accept", submissionsScope, adminReviewSubmission(services.AcceptSubmission))
+	server.POST(apiAdminPrefix+"/submissions/:id/reject", submissionsScope, adminReviewSubmission(services.RejectSubmission))
+	server.POST(apiAdminPrefix+"/submissions/:id/link", submissionsScope, adminLinkSubmission)
+
+	server.GET(apiAdminPrefix+"/aliases", listsScope, adminAliases)
+	server.POST(apiAdminPrefix+"/aliases", listsScope, adminAddAlias)
+	server.DELETE(apiAdminPrefix+"/aliases/:id", listsScope, adminRemoveAlias)
+	server.GET(apiAdminPrefix+"/blacklist", listsScope, adminBlacklist)
+	server.POST(apiAdminPrefix+"/blacklist", listsScope, adminAddBlacklist)
+	server.DELETE(apiAdminPrefix+"/blacklist/:id", listsScope, adminRemoveBlacklist)
+	server.GET(apiAdminPrefix+"/implications", listsScope, adminImplications)
+	server.POST(apiAdminPrefix+"/implications", listsScope, adminAddImplication)
+	server.DELETE(apiAdminPrefix+"/implications/:id", listsScope, adminRemoveImplication)
+	server.POST(apiAdminPrefix+"/taxonomy-kinds", listsScope, adminAddTaxonomyKind)
+	server.DELETE(apiAdminPrefix+"/taxonomy-kinds/:kind", listsScope, adminRemoveTaxonomyKind)
+	server.POST(apiAdminPrefix+"/lists/reload", listsScope, adminReloadLists)
+
+	server.POST(apiAdminPrefix+"/taxonomies/:kind/merge", taxonomiesScope, adminMergeTaxonomy)
+	server.GET(apiAdminPrefix+"/profiles/:kind/:slug", taxonomiesScope, adminProfile)
+	server.PATCH(apiAdminPrefix+"/profiles/:kind/:slug", taxonomiesScope, adminUpdateProfile)
+	server.PUT(apiAdminPrefix+"/profiles/:kind/:slug/image", taxonomiesScope, adminSetProfileImage)
+	server.DELETE(apiAdminPrefix+"/profiles/:kind/:slug/image", taxonomiesScope, adminRemoveProfileImage)
+
+	server.POST(apiAdminPrefix+"/caches/purge", cachesScope, adminPurgeCaches)
+	server.POST(apiAdminPrefix+"/templates/reload", cachesScope, adminReloadTemplates)
+
+	server.GET(apiAdminPrefix+"/webhooks", webhooksScope, adminWebhooks)
+	server.GET(apiAdminPrefix+"/webhooks/deliveries", webhooksScope, adminDeliveries)
+	server.POST(apiAdminPrefix+"/webhooks/deliveries/:id/retry", webhooksScope, adminRetryDelivery)
+
+	server.NoRoute(notFound)
 
 	server.Start(Config.Server.WebPort)
 }
+
+// notFound responds to the requests that match no route, including the
+// ones of the taxonomy routes whose kind does not exist.
+func notFound(c *server.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, apiV1Prefix+"/") ||
+		strings.HasPrefix(c.Request.URL.Path, apiAdminPrefix+"/") {
+		apiV1NotFound(c)
+		return
+	}
+	c.HTML(http.StatusNotFound, "error.html")
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	. "koushoku/config"
+
+	"koushoku/errs"
+	"koushoku/server"
+	"koushoku/services"
+)
+
+// The OPDS 1.2 catalog, browsed by the e-reader apps.
+const (
+	opdsPrefix = "/opds"
+
+	opdsNavigationTmplName  = "opds_navigation.xml"
+	opdsAcquisitionTmplName = "opds_acquisition.xml"
+	openSearchTmplName      = "opensearch.xml"
+
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	openSearchType      = "application/opensearchdescription+xml"
+)
+
+// OPDSLink is a link of a catalog feed to another page of the
+// feed, or a facet, which narrows down or sorts the entries.
+type OPDSLink struct {
+	Rel   string
+	Href  string
+	Type  string
+	Title string
+
+	FacetGroup string
+	Active     bool
+	Count      int64
+}
+
+// OPDSEntry is an entry of a navigation feed.
+type OPDSEntry struct {
+	Title   string
+	Content string
+	Href    string
+	Type    string
+}
+
+// opdsSorts are the facets sorting the archives.
+var opdsSorts = []struct {
+	Title string
+	Sort  string
+	Order string
+}{
+	{"Latest", "created_at", "desc"},
+	{"Recently published", "published_at", "desc"},
+	{"Trending", services.SortTrending, "desc"},
+	{"Popular", services.SortPopular, "desc"},
+	{"Title", "title", "asc"},
+}
+
+// withOPDSAuth authorizes the catalog requests with any of the API keys,
+// given either as the password of a basic authentication, which most of
+// the OPDS clients support, or as a bearer token. The tokens of the users
+// are to be accepted here as well once they can sign in.
+func withOPDSAuth(c *server.Context) {
+	c.Header("Cache-Control", "private, no-cache")
+	if requestApiKey(c) != nil {
+		return
+	}
+
+	if _, password, ok := c.Request.BasicAuth(); ok && len(password) > 0 && findApiKey(password) != nil {
+		return
+	}
+
+	c.Header("WWW-Authenticate", fmt.Sprintf(`Basic realm="%s"`, Config.Meta.Title))
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// opdsHref returns the path of the request on its first page, with
+// the given query parameters replaced, or removed if empty.
+func opdsHref(c *server.Context, params ...string) string {
+	query := c.Request.URL.Query()
+	query.Del("page")
+	for i := 0; i+1 < len(params); i += 2 {
+		if len(params[i+1]) > 0 {
+			query.Set(params[i], params[i+1])
+		} else {
+			query.Del(params[i])
+		}
+	}
+
+	if len(query) == 0 {
+		return c.Request.URL.Path
+	}
+	return c.Request.URL.Path + "?" + query.Encode()
+}
+
+// opdsPagination returns the links to the first, previous,
+// next and last pages of a feed.
+func opdsPagination(c *server.Context, feedType string, page, total, limit int) (links []*OPDSLink) {
+	totalPages := int(math.Ceil(float64(total) / float64(limit)))
+	if totalPages <= 1 {
+		return
+	}
+
+	link := func(rel string, page int) *OPDSLink {
+		return &OPDSLink{Rel: rel, Type: feedType, Href: opdsHref(c, "page", strconv.Itoa(page))}
+	}
+
+	links = append(links, link("first", 1))
+	if page > 1 {
+		links = append(links, link("previous", services.Min(page-1, totalPages)))
+	}
+	if page < totalPages {
+		links = append(links, link("next", page+1))
+	}
+	return append(links, link("last", totalPages))
+}
+
+// opdsRoot is the start of the catalog, which leads to the archives
+// and to the taxonomies of every kind.
+func opdsRoot(c *server.Context) {
+	c.Header("Content-Type", opdsNavigationType)
+	if c.TryCache(opdsNavigationTmplName) {
+		return
+	}
+
+	entries := []*OPDSEntry{
+		{
+			Title:   "Latest",
+			Content: "The latest archives",
+			Href:    opdsPrefix + "/archives",
+			Type:    opdsAcquisitionType,
+		},
+		{
+			Title:   "Trending",
+			Content: "The most read and downloaded archives of the week",
+			Href:    opdsPrefix + "/archives?sort=" + services.SortTrending,
+			Type:    opdsAcquisitionType,
+		},
+	}
+
+	for _, kind := range services.GetTaxonomyKinds().Kinds {
+		entries = append(entries, &OPDSEntry{
+			Title:   kind.Plural,
+			Content: fmt.Sprintf("The archives by %s", strings.ToLower(kind.Name)),
+			Href:    fmt.Sprintf("%s/taxonomies/%s", opdsPrefix, kind.Route),
+			Type:    opdsNavigationType,
+		})
+	}
+
+	c.SetData("name", Config.Meta.Title)
+	c.SetData("entries", entries)
+	c.Cache(http.StatusOK, opdsNavigationTmplName)
+}
+
+// opdsTaxonomies lists the taxonomies of a kind, sorted and
+// filtered with the same parameters as the listings.
+func opdsTaxonomies(c *server.Context) {
+	c.Header("Content-Type", opdsNavigationType)
+	if c.TryCache(opdsNavigationTmplName) {
+		return
+	}
+
+	kind, ok := findTaxonomyKind(c.Param("kind"))
+	if !ok {
+		textError(c, errs.TaxonomyKindNotFound)
+		return
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	page = services.Max(page, 1)
+
+	result := services.GetTaxonomies(kind.Kind, services.GetTaxonomiesOptions{
+		Limit:               listingLimit,
+		Offset:              listingLimit * (page - 1),
+		TaxonomyListOptions: getListingOptions(c),
+	})
+	if result.Err != nil {
+		textError(c, result.Err)
+		return
+	}
+
+	var entries []*OPDSEntry
+	for _, taxonomy := range result.Taxonomies {
+		entries = append(entries, &OPDSEntry{
+			Title:   taxonomy.QualifiedName(),
+			Content: fmt.Sprintf("%d archives", taxonomy.Count),
+			Href:    fmt.Sprintf("%s/taxonomies/%s/%s", opdsPrefix, kind.Route, url.PathEscape(taxonomy.Slug)),
+			Type:    opdsAcquisitionType,
+		})
+	}
+
+	c.SetData("name", kind.Plural)
+	c.SetData("entries", entries)
+	c.SetData("links", opdsPagination(c, opdsNavigationType, page, result.Total, listingLimit))
+	c.Cache(http.StatusOK, opdsNavigationTmplName)
+}
+
+// opdsTaxonomy lists the archives of a taxonomy, or redirects to the
+// new location of a taxonomy that has been merged or renamed.
+func opdsTaxonomy(c *server.Context) {
+	c.Header("Content-Type", opdsAcquisitionType)
+	if c.TryCache(opdsAcquisitionTmplName) {
+		return
+	}
+
+	kind, ok := findTaxonomyKind(c.Param("kind"))
+	if !ok {
+		textError(c, errs.TaxonomyKindNotFound)
+		return
+	}
+
+	slug := c.Param("slug")
+	taxonomy, err := services.GetTaxonomy(kind.Kind, slug)
+	if err != nil {
+		if target, ok := services.GetTaxonomyRedirect(kind.Kind, slug); ok {
+			c.Redirect(http.StatusMovedPermanently, fmt.Sprintf("%s/taxonomies/%s/%s", opdsPrefix, kind.Route, target))
+			return
+		}
+		textError(c, err)
+		return
+	}
+
+	q := createNewSearchQueries(c)
+	q.Search = fmt.Sprintf("%s:%s", kind.Kind, slug)
+	renderOPDSArchives(c, q, taxonomy.Name)
+}
+
+// opdsArchives lists the latest archives, or those matching the search
+// query given as q, which is the target of the OpenSearch description.
+func opdsArchives(c *server.Context) {
+	c.Header("Content-Type", opdsAcquisitionType)
+	if c.TryCache(opdsAcquisitionTmplName) {
+		return
+	}
+
+	q := createNewSearchQueries(c)
+	name := "Latest"
+	if len(q.Search) > 0 {
+		name = fmt.Sprintf("Search: %s", q.Search)
+	}
+	renderOPDSArchives(c, q, name)
+}
+
+// renderOPDSArchives renders the archives matching the search query as an
+// acquisition feed, along with the facets to sort them and, for searches,
+// those of the most common taxonomies to narrow them down.
+func renderOPDSArchives(c *server.Context, q *SearchQueries, name string) {
+	opts, err := getSearchOptions(c, q, false)
+	if err != nil {
+		textError(c, err)
+		return
+	}
+	opts.Preloads = append(opts.Preloads, services.ArchiveRels.Parodies)
+
+	result := services.GetArchives(opts)
+	if result.Err != nil {
+		textError(c, result.Err)
+		return
+	}
+
+	page := services.Max(q.Page, 1)
+	links := opdsPagination(c, opdsAcquisitionType, page, result.Total, indexLimit)
+
+	for _, sort := range opdsSorts {
+		links = append(links, &OPDSLink{
+			Rel:        "http://opds-spec.org/facet",
+			Href:       opdsHref(c, "sort", sort.Sort, "order", sort.Order),
+			Type:       opdsAcquisitionType,
+			Title:      sort.Title,
+			FacetGroup: "Sort",
+			Active:     q.Sort == sort.Sort,
+		})
+	}
+
+	for _, facet := range result.Facets {
+		for _, v := range facet.Values {
+			// The query is grouped so that the facet narrows all of it down.
+			query := fmt.Sprintf("(%s) %s:%s", q.Search, facet.Kind, v.Slug)
+			links = append(links, &OPDSLink{
+				Rel:        "http://opds-spec.org/facet",
+				Href:       opdsPrefix + "/archives?" + url.Values{"q": {query}}.Encode(),
+				Type:       opdsAcquisitionType,
+				Title:      v.Name,
+				FacetGroup: facet.Name,
+				Count:      v.Count,
+			})
+		}
+	}
+
+	c.SetData("name", name)
+	c.SetData("archives", result.Archives)
+	c.SetData("total", result.Total)
+	c.SetData("limit", indexLimit)
+	c.SetData("startIndex", indexLimit*(page-1)+1)
+	c.SetData("links", links)
+	c.Cache(http.StatusOK, opdsAcquisitionTmplName)
+}
+
+// openSearch describes the search of the catalog to the OPDS clients.
+func openSearch(c *server.Context) {
+	c.Header("Content-Type", openSearchType)
+	if !c.TryCache(openSearchTmplName) {
+		c.Cache(http.StatusOK, openSearchTmplName)
+	}
+}
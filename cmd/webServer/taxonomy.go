@@ -4,225 +4,95 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"strings"
 
+	"koushoku/modext"
 	"koushoku/server"
 	"koushoku/services"
 )
 
 const taxonomyTmplName = "taxonomy.html"
 
-func artist(c *server.Context) {
-	if c.TryCache(taxonomyTmplName) {
-		return
+// parseTaxonomySlug returns the slug of the taxonomy
+// and whether its JSON representation is requested.
+func parseTaxonomySlug(c *server.Context) (string, bool) {
+	slug := c.Param("slug")
+	if strings.HasSuffix(slug, ".json") {
+		return strings.TrimSuffix(slug, ".json"), true
 	}
+	return slug, false
+}
 
-	artist, err := services.GetArtist(c.Param("slug"))
-	if err != nil {
-		c.SetData("error", err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
-	}
-
-	q := createNewSearchQueries(c)
-	result := services.GetArchives(&services.GetArchivesOptions{
-		ArtistsMatch: []string{artist.Name},
-		Limit:        indexLimit,
-		Offset:       indexLimit * (q.Page - 1),
-		Preloads: []string{
-			services.ArchiveRels.Artists,
-			services.ArchiveRels.Circles,
-			services.ArchiveRels.Magazines,
-			services.ArchiveRels.Tags,
-		},
-
-		Sort:  q.Sort,
-		Order: q.Order,
-	})
-	if result.Err != nil {
-		c.SetData("error", result.Err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
+// redirectTaxonomy redirects to the new location of a taxonomy
+// that has been merged or renamed, if there is one.
+func redirectTaxonomy(c *server.Context, kind, route string) bool {
+	slug, isJson := parseTaxonomySlug(c)
+	target, ok := services.GetTaxonomyRedirect(kind, slug)
+	if !ok {
+		return false
 	}
 
-	c.SetData("queries", q)
-	if q.Page > 0 {
-		c.SetData("name", fmt.Sprintf("%s: Page %d", artist.Name, q.Page))
-	} else {
-		c.SetData("name", artist.Name)
+	if isJson {
+		target += ".json"
 	}
-
-	totalPages := int(math.Ceil(float64(result.Total) / float64(indexLimit)))
-	c.SetData("taxonomy", artist.Name)
-	c.SetData("archives", result.Archives)
-	c.SetData("total", result.Total)
-	c.SetData("pagination", services.CreatePagination(q.Page, totalPages))
-
-	c.Cache(http.StatusOK, taxonomyTmplName)
+	c.Redirect(http.StatusMovedPermanently, fmt.Sprintf("%s/%s", route, target))
+	return true
 }
 
-func circle(c *server.Context) {
-	if c.TryCache(taxonomyTmplName) {
-		return
-	}
-
-	circle, err := services.GetCircle(c.Param("slug"))
+// getTaxonomyProfile returns the profile of the taxonomy, if it has one.
+func getTaxonomyProfile(kind, slug string) *modext.TaxonomyProfile {
+	profile, err := services.GetTaxonomyProfile(kind, slug)
 	if err != nil {
-		c.SetData("error", err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
+		return nil
 	}
-
-	q := createNewSearchQueries(c)
-	result := services.GetArchives(&services.GetArchivesOptions{
-		CirclesMatch: []string{circle.Name},
-		Limit:        indexLimit,
-		Offset:       indexLimit * (q.Page - 1),
-		Preloads: []string{
-			services.ArchiveRels.Artists,
-			services.ArchiveRels.Circles,
-			services.ArchiveRels.Magazines,
-			services.ArchiveRels.Tags,
-		},
-
-		Sort:  q.Sort,
-		Order: q.Order,
-	})
-	if result.Err != nil {
-		c.SetData("error", result.Err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
-	}
-
-	c.SetData("queries", q)
-	if q.Page > 0 {
-		c.SetData("name", fmt.Sprintf("%s: Page %d", circle.Name, q.Page))
-	} else {
-		c.SetData("name", circle.Name)
-	}
-
-	totalPages := int(math.Ceil(float64(result.Total) / float64(indexLimit)))
-	c.SetData("taxonomy", circle.Name)
-	c.SetData("archives", result.Archives)
-	c.SetData("total", result.Total)
-	c.SetData("pagination", services.CreatePagination(q.Page, totalPages))
-
-	c.Cache(http.StatusOK, taxonomyTmplName)
+	return profile
 }
 
-func magazine(c *server.Context) {
-	if c.TryCache(taxonomyTmplName) {
-		return
-	}
-
-	magazine, err := services.GetMagazine(c.Param("slug"))
+// getTaxonomy returns a taxonomy of a kind, either built
+// in or user-defined, along with its profile if it has one.
+func getTaxonomy(kind, slug string) (*modext.Taxonomy, error) {
+	taxonomy, err := services.GetTaxonomy(kind, slug)
 	if err != nil {
-		c.SetData("error", err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
+		return nil, err
 	}
-
-	q := createNewSearchQueries(c)
-	result := services.GetArchives(&services.GetArchivesOptions{
-		MagazinesMatch: []string{magazine.Name},
-		Limit:          indexLimit,
-		Offset:         indexLimit * (q.Page - 1),
-		Preloads: []string{
-			services.ArchiveRels.Artists,
-			services.ArchiveRels.Circles,
-			services.ArchiveRels.Magazines,
-			services.ArchiveRels.Tags,
-		},
-
-		Sort:  q.Sort,
-		Order: q.Order,
-	})
-	if result.Err != nil {
-		c.SetData("error", result.Err)
-		c.HTML(http.StatusInternalServerError, "error.html")
-		return
-	}
-
-	c.SetData("queries", q)
-	if q.Page > 0 {
-		c.SetData("name", fmt.Sprintf("%s: Page %d", magazine.Name, q.Page))
-	} else {
-		c.SetData("name", magazine.Name)
-	}
-
-	totalPages := int(math.Ceil(float64(result.Total) / float64(indexLimit)))
-	c.SetData("taxonomy", magazine.Name)
-	c.SetData("archives", result.Archives)
-	c.SetData("total", result.Total)
-	c.SetData("pagination", services.CreatePagination(q.Page, totalPages))
-
-	c.Cache(http.StatusOK, taxonomyTmplName)
+	taxonomy.Profile = getTaxonomyProfile(kind, taxonomy.Slug)
+	return taxonomy, nil
 }
 
-func parody(c *server.Context) {
+// taxonomy lists the archives of a taxonomy of a kind, either built in or
+// user-defined, under the route of the kind, e.g. /artists/:slug. The kinds
+// are looked up on every request, like the listings.
+func taxonomy(c *server.Context) {
 	if c.TryCache(taxonomyTmplName) {
 		return
 	}
 
-	parody, err := services.GetParody(c.Param("slug"))
-	if err != nil {
-		c.SetData("error", err)
-		c.HTML(http.StatusInternalServerError, "error.html")
+	kind, ok := services.GetTaxonomyKinds().GetByRoute(c.Param("kind"))
+	if !ok {
+		notFound(c)
 		return
 	}
 
-	q := createNewSearchQueries(c)
-	result := services.GetArchives(&services.GetArchivesOptions{
-		ParodiesMatch: []string{parody.Name},
-		Limit:         indexLimit,
-		Offset:        indexLimit * (q.Page - 1),
-		Preloads: []string{
-			services.ArchiveRels.Artists,
-			services.ArchiveRels.Circles,
-			services.ArchiveRels.Magazines,
-			services.ArchiveRels.Tags,
-		},
-		Sort:  q.Sort,
-		Order: q.Order,
-	})
-	if result.Err != nil {
-		c.SetData("error", result.Err)
+	slug, isJson := parseTaxonomySlug(c)
+	taxonomy, err := getTaxonomy(kind.Kind, slug)
+	if err != nil {
+		if redirectTaxonomy(c, kind.Kind, "/"+kind.Route) {
+			return
+		}
+		c.SetData("error", err)
 		c.HTML(http.StatusInternalServerError, "error.html")
 		return
 	}
 
-	c.SetData("queries", q)
-	if q.Page > 0 {
-		c.SetData("name", fmt.Sprintf("%s: Page %d", parody.Name, q.Page))
-	} else {
-		c.SetData("name", parody.Name)
-	}
-
-	totalPages := int(math.Ceil(float64(result.Total) / float64(indexLimit)))
-	c.SetData("taxonomy", parody.Name)
-	c.SetData("archives", result.Archives)
-	c.SetData("total", result.Total)
-	c.SetData("pagination", services.CreatePagination(q.Page, totalPages))
-
-	c.Cache(http.StatusOK, taxonomyTmplName)
-}
-
-func tag(c *server.Context) {
-	if c.TryCache(taxonomyTmplName) {
-		return
-	}
-
-	tag, err := services.GetTag(c.Param("slug"))
-	if err != nil {
-		c.SetData("error", err)
-		c.HTML(http.StatusInternalServerError, "error.html")
+	if isJson {
+		c.JSON(http.StatusOK, taxonomy)
 		return
 	}
 
 	q := createNewSearchQueries(c)
-	result := services.GetArchives(&services.GetArchivesOptions{
-		TagsMatch: []string{tag.Name},
-		Limit:     indexLimit,
-		Offset:    indexLimit * (q.Page - 1),
+	opts := &services.GetArchivesOptions{
+		Limit:  indexLimit,
+		Offset: indexLimit * (q.Page - 1),
 		Preloads: []string{
 			services.ArchiveRels.Artists,
 			services.ArchiveRels.Circles,
@@ -231,7 +101,10 @@ func tag(c *server.Context) {
 		},
 		Sort:  q.Sort,
 		Order: q.Order,
-	})
+	}
+	opts.Taxonomy(kind.Kind).Match = []string{taxonomy.QualifiedName()}
+
+	result := services.GetArchives(opts)
 	if result.Err != nil {
 		c.SetData("error", result.Err)
 		c.HTML(http.StatusInternalServerError, "error.html")
@@ -240,13 +113,14 @@ func tag(c *server.Context) {
 
 	c.SetData("queries", q)
 	if q.Page > 0 {
-		c.SetData("name", fmt.Sprintf("%s: Page %d", tag.Name, q.Page))
+		c.SetData("name", fmt.Sprintf("%s: Page %d", taxonomy.Name, q.Page))
 	} else {
-		c.SetData("name", tag.Name)
+		c.SetData("name", taxonomy.Name)
 	}
 
 	totalPages := int(math.Ceil(float64(result.Total) / float64(indexLimit)))
-	c.SetData("taxonomy", tag.Name)
+	c.SetData("taxonomy", taxonomy.Name)
+	c.SetData("profile", taxonomy.Profile)
 	c.SetData("archives", result.Archives)
 	c.SetData("total", result.Total)
 	c.SetData("pagination", services.CreatePagination(q.Page, totalPages))
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/google/uuid"
@@ -61,7 +62,12 @@ var Config struct {
 
 	HTTP struct {
 		Cookie string
+
+		// ApiKey is the key of the util command, which has every scope.
 		ApiKey string
+
+		// ApiKeys are the named keys of the admin API.
+		ApiKeys []ApiKey
 	}
 
 	Cloudflare struct {
@@ -77,8 +83,16 @@ var Config struct {
 		Symlinks   string
 		Templates  string
 		Thumbnails string
+		Profiles   string
 	}
 
+	// Taxonomies are the additional taxonomy kinds, on top of
+	// artists, circles, magazines, parodies and tags.
+	Taxonomies []TaxonomyKind
+
+	// Webhooks are the URLs notified of the changes of the catalog.
+	Webhooks []Webhook
+
 	Paths struct {
 		Alias     string
 		Blacklist string
@@ -86,6 +100,52 @@ var Config struct {
 	}
 }
 
+type TaxonomyKind struct {
+	Kind   string
+	Name   string
+	Plural string
+}
+
+// ApiKey is a key of the admin API, allowed to use the
+// routes of its scopes, or all of them with the * scope.
+type ApiKey struct {
+	Name   string
+	Key    string
+	Scopes []string
+}
+
+// Webhook is a URL the events are posted to, signed with the secret.
+// It receives every event unless it is given some.
+type Webhook struct {
+	Name   string
+	URL    string
+	Secret string
+	Events []string
+}
+
+// HasEvent returns whether the webhook receives the event.
+func (w *Webhook) HasEvent(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, v := range w.Events {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope returns whether the key is allowed to use the routes of the scope.
+func (k *ApiKey) HasScope(scope string) bool {
+	for _, v := range k.Scopes {
+		if v == "*" || v == scope {
+			return true
+		}
+	}
+	return false
+}
+
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
@@ -124,6 +184,14 @@ func init() {
 		}
 	}
 
+	Config.Directories.Profiles = filepath.Join(Config.Directories.Root, "profiles")
+	if _, err := os.Stat(Config.Directories.Profiles); os.IsNotExist(err) {
+		log.Println("Creating profiles directory...")
+		if err := os.MkdirAll(Config.Directories.Profiles, 0755); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
 	Config.Paths.Blacklist = filepath.Join(Config.Directories.Root, "blacklist.txt")
 	if _, err := os.Stat(Config.Paths.Blacklist); os.IsNotExist(err) {
 		log.Println("No blacklist file found, creating one...")
@@ -205,6 +273,44 @@ func init() {
 		}
 	}
 
+	for _, key := range file.Section("taxonomies").Keys() {
+		strs := strings.Split(key.String(), ",")
+		kind := TaxonomyKind{Kind: key.Name(), Name: strings.TrimSpace(strs[0])}
+		if len(strs) > 1 {
+			kind.Plural = strings.TrimSpace(strs[1])
+		}
+		Config.Taxonomies = append(Config.Taxonomies, kind)
+	}
+
+	Config.HTTP.ApiKeys = []ApiKey{{Name: "default", Key: Config.HTTP.ApiKey, Scopes: []string{"*"}}}
+	for _, key := range file.Section("api_keys").Keys() {
+		strs := strings.Split(key.String(), ",")
+		apiKey := ApiKey{Name: key.Name(), Key: strings.TrimSpace(strs[0])}
+		for _, scope := range strs[1:] {
+			if scope = strings.TrimSpace(scope); len(scope) > 0 {
+				apiKey.Scopes = append(apiKey.Scopes, scope)
+			}
+		}
+		if len(apiKey.Key) > 0 {
+			Config.HTTP.ApiKeys = append(Config.HTTP.ApiKeys, apiKey)
+		}
+	}
+
+	for _, key := range file.Section("webhooks").Keys() {
+		strs := strings.Split(key.String(), ",")
+		if len(strs) < 2 {
+			log.Fatalf("Webhook %s must be given as url, secret, events...\n", key.Name())
+		}
+
+		webhook := Webhook{Name: key.Name(), URL: strings.TrimSpace(strs[0]), Secret: strings.TrimSpace(strs[1])}
+		for _, event := range strs[2:] {
+			if event = strings.TrimSpace(event); len(event) > 0 {
+				webhook.Events = append(webhook.Events, event)
+			}
+		}
+		Config.Webhooks = append(Config.Webhooks, webhook)
+	}
+
 	Save()
 
 	if len(opts.Mode) > 0 {
@@ -17,13 +17,19 @@ CREATE UNIQUE INDEX IF NOT EXISTS circle_slug_uindex ON circle(slug);
 CREATE UNIQUE INDEX IF NOT EXISTS circle_name_uindex ON circle(name);
 
 CREATE TABLE IF NOT EXISTS tag (
-  id   BIGSERIAL PRIMARY KEY,
-  slug VARCHAR(32) NOT NULL DEFAULT NULL,
-  name VARCHAR(32) NOT NULL DEFAULT NULL
+  id        BIGSERIAL PRIMARY KEY,
+  slug      VARCHAR(192) NOT NULL DEFAULT NULL,
+  name      VARCHAR(128) NOT NULL DEFAULT NULL,
+  namespace VARCHAR(32) NOT NULL DEFAULT ''
 );
 
+ALTER TABLE tag ADD COLUMN IF NOT EXISTS namespace VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE tag ALTER COLUMN slug TYPE VARCHAR(192), ALTER COLUMN name TYPE VARCHAR(128);
+DROP INDEX IF EXISTS tag_name_uindex;
+
 CREATE UNIQUE INDEX IF NOT EXISTS tag_slug_uindex ON tag(slug);
-CREATE UNIQUE INDEX IF NOT EXISTS tag_name_uindex ON tag(name);
+CREATE UNIQUE INDEX IF NOT EXISTS tag_namespace_name_uindex ON tag(namespace, name);
+CREATE INDEX IF NOT EXISTS tag_namespace_index ON tag(namespace);
 
 CREATE TABLE IF NOT EXISTS magazine (
   id BIGSERIAL PRIMARY KEY,
@@ -198,4 +204,172 @@ BEGIN
       ADD COLUMN redirect_id BIGINT DEFAULT NULL REFERENCES archive(id) ON DELETE CASCADE;
   END IF;
 END;
-$$;
\ No newline at end of file
+$$;
+
+CREATE TABLE IF NOT EXISTS alias (
+  id         BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  deleted_at TIMESTAMP,
+
+  kind       VARCHAR(16) NOT NULL DEFAULT NULL,
+  slug       VARCHAR(1024) NOT NULL DEFAULT NULL,
+  name       VARCHAR(1024) NOT NULL DEFAULT NULL,
+  target     VARCHAR(1024) NOT NULL DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS alias_kind_slug_uindex ON alias(kind, slug) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS alias_kind_index ON alias(kind);
+CREATE INDEX IF NOT EXISTS alias_deleted_at_index ON alias(deleted_at);
+
+CREATE TABLE IF NOT EXISTS blacklist_rule (
+  id         BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  deleted_at TIMESTAMP,
+
+  kind       VARCHAR(16) NOT NULL DEFAULT NULL,
+  value      VARCHAR(1024) NOT NULL DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS blacklist_rule_kind_value_uindex ON blacklist_rule(kind, value) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS blacklist_rule_kind_index ON blacklist_rule(kind);
+CREATE INDEX IF NOT EXISTS blacklist_rule_deleted_at_index ON blacklist_rule(deleted_at);
+
+CREATE TABLE IF NOT EXISTS taxonomy_redirect (
+  id         BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  kind       VARCHAR(16) NOT NULL DEFAULT NULL,
+  slug       VARCHAR(128) NOT NULL DEFAULT NULL,
+  target     VARCHAR(128) NOT NULL DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS taxonomy_redirect_kind_slug_uindex ON taxonomy_redirect(kind, slug);
+CREATE INDEX IF NOT EXISTS taxonomy_redirect_kind_target_index ON taxonomy_redirect(kind, target);
+
+CREATE TABLE IF NOT EXISTS archive_moderation (
+  archive_id BIGINT PRIMARY KEY REFERENCES archive(id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  state      VARCHAR(16) NOT NULL DEFAULT NULL,
+  rule_id    BIGINT REFERENCES blacklist_rule(id) ON DELETE SET NULL,
+  rule       VARCHAR(1024) NOT NULL DEFAULT '',
+  reasons    TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS archive_moderation_state_index ON archive_moderation(state);
+
+CREATE TABLE IF NOT EXISTS tag_implication (
+  id           BIGSERIAL PRIMARY KEY,
+  created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  slug         VARCHAR(192) NOT NULL DEFAULT NULL,
+  name         VARCHAR(128) NOT NULL DEFAULT NULL,
+  implied_slug VARCHAR(192) NOT NULL DEFAULT NULL,
+  implied      VARCHAR(128) NOT NULL DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tag_implication_slug_implied_slug_uindex ON tag_implication(slug, implied_slug);
+
+CREATE TABLE IF NOT EXISTS taxonomy_kind (
+  id         BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  kind       VARCHAR(32) NOT NULL DEFAULT NULL,
+  name       VARCHAR(64) NOT NULL DEFAULT NULL,
+  plural     VARCHAR(64) NOT NULL DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS taxonomy_kind_kind_uindex ON taxonomy_kind(kind);
+
+CREATE TABLE IF NOT EXISTS taxonomy (
+  id   BIGSERIAL PRIMARY KEY,
+  kind VARCHAR(32) NOT NULL DEFAULT NULL,
+  slug VARCHAR(128) NOT NULL DEFAULT NULL,
+  name VARCHAR(128) NOT NULL DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS taxonomy_kind_slug_uindex ON taxonomy(kind, slug);
+
+CREATE TABLE IF NOT EXISTS archive_taxonomies (
+  archive_id  BIGINT NOT NULL DEFAULT NULL REFERENCES archive(id) ON DELETE CASCADE,
+  taxonomy_id BIGINT NOT NULL DEFAULT NULL REFERENCES taxonomy(id) ON DELETE CASCADE,
+  PRIMARY KEY(archive_id, taxonomy_id)
+);
+
+CREATE INDEX IF NOT EXISTS archive_taxonomies_archive_id_index ON archive_taxonomies(archive_id);
+CREATE INDEX IF NOT EXISTS archive_taxonomies_taxonomy_id_index ON archive_taxonomies(taxonomy_id);
+
+CREATE TABLE IF NOT EXISTS taxonomy_profile (
+  id          BIGSERIAL PRIMARY KEY,
+  created_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  kind        VARCHAR(32) NOT NULL DEFAULT NULL,
+  slug        VARCHAR(128) NOT NULL DEFAULT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  links       TEXT NOT NULL DEFAULT '',
+  image       VARCHAR(255) NOT NULL DEFAULT '',
+  alt_names   TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS taxonomy_profile_kind_slug_uindex ON taxonomy_profile(kind, slug);
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE IF NOT EXISTS archive_search (
+  archive_id BIGINT PRIMARY KEY REFERENCES archive(id) ON DELETE CASCADE,
+  document   TEXT NOT NULL DEFAULT '',
+  vector     TSVECTOR NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS archive_search_vector_index ON archive_search USING GIN(vector);
+CREATE INDEX IF NOT EXISTS archive_search_document_trgm_index ON archive_search USING GIN(document gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS archive_size_index ON archive(size);
+CREATE INDEX IF NOT EXISTS archive_source_index ON archive(source);
+CREATE INDEX IF NOT EXISTS archive_source_trgm_index ON archive USING GIN(source gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS archive_submission_id_index ON archive(submission_id);
+CREATE INDEX IF NOT EXISTS archive_redirect_id_index ON archive(redirect_id);
+
+CREATE INDEX IF NOT EXISTS archive_created_at_id_index ON archive(created_at, id);
+CREATE INDEX IF NOT EXISTS archive_updated_at_id_index ON archive(updated_at, id);
+CREATE INDEX IF NOT EXISTS archive_published_at_id_index ON archive(published_at, id);
+CREATE INDEX IF NOT EXISTS archive_title_id_index ON archive(title, id);
+CREATE INDEX IF NOT EXISTS archive_pages_id_index ON archive(pages, id);
+CREATE INDEX IF NOT EXISTS archive_size_id_index ON archive(size, id);
+
+CREATE TABLE IF NOT EXISTS archive_stats (
+  archive_id BIGINT PRIMARY KEY REFERENCES archive(id) ON DELETE CASCADE,
+  views      BIGINT NOT NULL DEFAULT 0,
+  downloads  BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS archive_daily_stats (
+  archive_id BIGINT NOT NULL DEFAULT NULL REFERENCES archive(id) ON DELETE CASCADE,
+  day        DATE NOT NULL DEFAULT NULL,
+  views      BIGINT NOT NULL DEFAULT 0,
+  downloads  BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY(archive_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS archive_daily_stats_day_index ON archive_daily_stats(day);
+
+CREATE TABLE IF NOT EXISTS webhook_delivery (
+  id              BIGSERIAL PRIMARY KEY,
+  created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+
+  webhook         VARCHAR(64) NOT NULL DEFAULT NULL,
+  event           VARCHAR(32) NOT NULL DEFAULT NULL,
+  payload         TEXT NOT NULL DEFAULT NULL,
+
+  state           VARCHAR(16) NOT NULL DEFAULT 'pending',
+  attempts        INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  status_code     INT NOT NULL DEFAULT 0,
+  error           TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS webhook_delivery_pending_index ON webhook_delivery(next_attempt_at) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_delivery_state_index ON webhook_delivery(state, id);
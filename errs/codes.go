@@ -0,0 +1,131 @@
+package errs
+
+import "net/http"
+
+// codes are the stable identifiers of the errors returned by the API,
+// which the clients can rely on rather than on the messages.
+var codes = map[error]string{
+	Unknown: "unknown",
+
+	ArchiveNotFound:      "archive_not_found",
+	ArtistNotFound:       "artist_not_found",
+	CircleNotFound:       "circle_not_found",
+	MagazineNotFound:     "magazine_not_found",
+	TagNotFound:          "tag_not_found",
+	ParodyNotFound:       "parody_not_found",
+	UserNotFound:         "user_not_found",
+	SubmissionNotFound:   "submission_not_found",
+	AliasNotFound:        "alias_not_found",
+	BlacklistNotFound:    "blacklist_not_found",
+	ModerationNotFound:   "moderation_not_found",
+	ImplicationNotFound:  "implication_not_found",
+	TaxonomyNotFound:     "taxonomy_not_found",
+	TaxonomyKindNotFound: "taxonomy_kind_not_found",
+	ProfileNotFound:      "profile_not_found",
+	DeliveryNotFound:     "delivery_not_found",
+
+	ArchivePathRequired:        "archive_path_required",
+	ArchiveRedirectInvalid:     "archive_redirect_invalid",
+	ArchiveDeleteUnconfirmed:   "archive_delete_unconfirmed",
+	ArtistNameRequired:         "artist_name_required",
+	ArtistNameTooLong:          "artist_name_too_long",
+	CircleNameRequired:         "circle_name_required",
+	CircleNameTooLong:          "circle_name_too_long",
+	MagazineNameRequired:       "magazine_name_required",
+	MagazineNameTooLong:        "magazine_name_too_long",
+	ParodyNameRequired:         "parody_name_required",
+	ParodyNameTooLong:          "parody_name_too_long",
+	TagNameRequired:            "tag_name_required",
+	TagNameTooLong:             "tag_name_too_long",
+	TagNamespaceTooLong:        "tag_namespace_too_long",
+	SubmissionNameRequired:     "submission_name_required",
+	SubmissionNameTooLong:      "submission_name_too_long",
+	SubmissionSubmitterTooLong: "submission_submitter_too_long",
+	SubmissionContentRequired:  "submission_content_required",
+	SubmissionContentTooLong:   "submission_content_too_long",
+	AliasKindInvalid:           "alias_kind_invalid",
+	AliasNameRequired:          "alias_name_required",
+	AliasNameTooLong:           "alias_name_too_long",
+	AliasTargetRequired:        "alias_target_required",
+	AliasTargetTooLong:         "alias_target_too_long",
+	BlacklistKindInvalid:       "blacklist_kind_invalid",
+	BlacklistRuleInvalid:       "blacklist_rule_invalid",
+	BlacklistValueRequired:     "blacklist_value_required",
+	BlacklistValueTooLong:      "blacklist_value_too_long",
+	TaxonomyKindInvalid:        "taxonomy_kind_invalid",
+	TaxonomyKindRequired:       "taxonomy_kind_required",
+	TaxonomyKindTooLong:        "taxonomy_kind_too_long",
+	TaxonomyKindNameTooLong:    "taxonomy_kind_name_too_long",
+	TaxonomyKindReserved:       "taxonomy_kind_reserved",
+	TaxonomyKindBuiltin:        "taxonomy_kind_builtin",
+	TaxonomyNameRequired:       "taxonomy_name_required",
+	TaxonomyNameTooLong:        "taxonomy_name_too_long",
+	TaxonomyMergeRequired:      "taxonomy_merge_required",
+	TaxonomyMergeSame:          "taxonomy_merge_same",
+	ImplicationTagRequired:     "implication_tag_required",
+	ImplicationTagTooLong:      "implication_tag_too_long",
+	ImplicationSelf:            "implication_self",
+	ProfileKindInvalid:         "profile_kind_invalid",
+	ProfileDescriptionTooLong:  "profile_description_too_long",
+	ProfileLinkInvalid:         "profile_link_invalid",
+	ProfileLinksTooMany:        "profile_links_too_many",
+	ProfileAltNameTooLong:      "profile_alt_name_too_long",
+	ProfileAltNamesTooMany:     "profile_alt_names_too_many",
+	ProfileImageInvalid:        "profile_image_invalid",
+	CursorInvalid:              "cursor_invalid",
+
+	UserNameTooShort:   "user_name_too_short",
+	UserNameTooLong:    "user_name_too_long",
+	EmailRequired:      "email_required",
+	EmailTooLong:       "email_too_long",
+	EmailInvalid:       "email_invalid",
+	PasswordTooShort:   "password_too_short",
+	InvalidCredentials: "invalid_credentials",
+
+	ApiKeyInvalid:      "api_key_invalid",
+	ApiKeyScopeMissing: "api_key_scope_missing",
+}
+
+var notFound = map[error]bool{
+	ArchiveNotFound:      true,
+	ArtistNotFound:       true,
+	CircleNotFound:       true,
+	MagazineNotFound:     true,
+	TagNotFound:          true,
+	ParodyNotFound:       true,
+	UserNotFound:         true,
+	SubmissionNotFound:   true,
+	AliasNotFound:        true,
+	BlacklistNotFound:    true,
+	ModerationNotFound:   true,
+	ImplicationNotFound:  true,
+	TaxonomyNotFound:     true,
+	TaxonomyKindNotFound: true,
+	ProfileNotFound:      true,
+	DeliveryNotFound:     true,
+}
+
+// Code returns the code of the error, invalid_request for
+// the errors that are not declared in this package.
+func Code(err error) string {
+	if code, ok := codes[err]; ok {
+		return code
+	}
+	return "invalid_request"
+}
+
+// Status returns the HTTP status of the error, the errors that
+// are not declared in this package being the client's fault.
+func Status(err error) int {
+	switch {
+	case err == Unknown:
+		return http.StatusInternalServerError
+	case err == InvalidCredentials, err == ApiKeyInvalid:
+		return http.StatusUnauthorized
+	case err == ApiKeyScopeMissing:
+		return http.StatusForbidden
+	case notFound[err]:
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
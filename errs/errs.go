@@ -5,18 +5,28 @@ import "errors"
 var Unknown = errors.New("Unknown error")
 
 var (
-	ArchiveNotFound    = errors.New("Archive does not exist")
-	ArtistNotFound     = errors.New("Artist does not exist")
-	CircleNotFound     = errors.New("Circle does not exist")
-	MagazineNotFound   = errors.New("Magazine does not exist")
-	TagNotFound        = errors.New("Tag does not exist")
-	ParodyNotFound     = errors.New("Parody does not exist")
-	UserNotFound       = errors.New("User does not exist")
-	SubmissionNotFound = errors.New("Submission does not exist")
+	ArchiveNotFound      = errors.New("Archive does not exist")
+	ArtistNotFound       = errors.New("Artist does not exist")
+	CircleNotFound       = errors.New("Circle does not exist")
+	MagazineNotFound     = errors.New("Magazine does not exist")
+	TagNotFound          = errors.New("Tag does not exist")
+	ParodyNotFound       = errors.New("Parody does not exist")
+	UserNotFound         = errors.New("User does not exist")
+	SubmissionNotFound   = errors.New("Submission does not exist")
+	AliasNotFound        = errors.New("Alias does not exist")
+	BlacklistNotFound    = errors.New("Blacklist rule does not exist")
+	ModerationNotFound   = errors.New("Archive is not moderated")
+	ImplicationNotFound  = errors.New("Tag implication does not exist")
+	TaxonomyNotFound     = errors.New("Taxonomy does not exist")
+	TaxonomyKindNotFound = errors.New("Taxonomy kind does not exist")
+	ProfileNotFound      = errors.New("Profile does not exist")
+	DeliveryNotFound     = errors.New("Webhook delivery does not exist")
 )
 
 var (
 	ArchivePathRequired        = errors.New("Archive path is required")
+	ArchiveRedirectInvalid     = errors.New("Archive must redirect to another archive")
+	ArchiveDeleteUnconfirmed   = errors.New("Deleting every archive must be confirmed with confirm=true")
 	ArtistNameRequired         = errors.New("Artist name is required")
 	ArtistNameTooLong          = errors.New("Artist name must be at most 128 characters")
 	CircleNameRequired         = errors.New("Circle name is required")
@@ -27,11 +37,42 @@ var (
 	ParodyNameTooLong          = errors.New("Parody name must be at most 128 characters")
 	TagNameRequired            = errors.New("Tag name is required")
 	TagNameTooLong             = errors.New("Tag name must be at most 128 characters")
+	TagNamespaceTooLong        = errors.New("Tag namespace must be at most 32 characters")
 	SubmissionNameRequired     = errors.New("Submission name is required")
 	SubmissionNameTooLong      = errors.New("Submission name must be at most 1024 characters")
 	SubmissionSubmitterTooLong = errors.New("Submission submitter must be at most 128 characters")
 	SubmissionContentRequired  = errors.New("Submission content is required")
 	SubmissionContentTooLong   = errors.New("Submission content must be at most 10240 characters")
+	AliasKindInvalid           = errors.New("Alias kind must be one of title, artist, circle, magazine, parody or tag")
+	AliasNameRequired          = errors.New("Alias name is required")
+	AliasNameTooLong           = errors.New("Alias name must be at most 1024 characters")
+	AliasTargetRequired        = errors.New("Alias target is required")
+	AliasTargetTooLong         = errors.New("Alias target must be at most 1024 characters")
+	BlacklistKindInvalid       = errors.New("Blacklist kind must be one of title, title*, path, artist, circle, magazine, parody, tag or rule")
+	BlacklistRuleInvalid       = errors.New("Blacklist rule is invalid")
+	BlacklistValueRequired     = errors.New("Blacklist value is required")
+	BlacklistValueTooLong      = errors.New("Blacklist value must be at most 1024 characters")
+	TaxonomyKindInvalid        = errors.New("Taxonomy kind is not a known kind")
+	TaxonomyKindRequired       = errors.New("Taxonomy kind and name are required")
+	TaxonomyKindTooLong        = errors.New("Taxonomy kind must be at most 32 characters")
+	TaxonomyKindNameTooLong    = errors.New("Taxonomy kind name must be at most 64 characters")
+	TaxonomyKindReserved       = errors.New("Taxonomy kind is reserved")
+	TaxonomyKindBuiltin        = errors.New("Taxonomy kind is built in and cannot be removed")
+	TaxonomyNameRequired       = errors.New("Taxonomy name is required")
+	TaxonomyNameTooLong        = errors.New("Taxonomy name must be at most 128 characters")
+	TaxonomyMergeRequired      = errors.New("Taxonomy to merge from and into are required")
+	TaxonomyMergeSame          = errors.New("Taxonomy cannot be merged into itself")
+	ImplicationTagRequired     = errors.New("Tag and implied tag are required")
+	ImplicationTagTooLong      = errors.New("Tag and implied tag must be at most 128 characters")
+	ImplicationSelf            = errors.New("Tag cannot imply itself")
+	ProfileKindInvalid         = errors.New("Profile kind must be one of artist, circle, magazine or parody")
+	ProfileDescriptionTooLong  = errors.New("Profile description must be at most 10240 characters")
+	ProfileLinkInvalid         = errors.New("Profile link must be an http or https URL of at most 1024 characters")
+	ProfileLinksTooMany        = errors.New("Profile must have at most 32 links")
+	ProfileAltNameTooLong      = errors.New("Profile alternative name must be at most 128 characters")
+	ProfileAltNamesTooMany     = errors.New("Profile must have at most 64 alternative names")
+	ProfileImageInvalid        = errors.New("Profile image is not a valid image")
+	CursorInvalid              = errors.New("Cursor is invalid or does not match the sort and order")
 )
 
 var (
@@ -43,3 +84,8 @@ var (
 	PasswordTooShort   = errors.New("Password must be at least 6 characters")
 	InvalidCredentials = errors.New("Invalid credentials")
 )
+
+var (
+	ApiKeyInvalid      = errors.New("API key is missing or invalid")
+	ApiKeyScopeMissing = errors.New("API key is not allowed to do this")
+)
@@ -0,0 +1,11 @@
+package modext
+
+type Alias struct {
+	ID        int64  `json:"id"`
+	CreatedAt int64  `json:"createdAt"`
+	DeletedAt int64  `json:"deletedAt,omitempty"`
+	Kind      string `json:"kind"`
+	Slug      string `json:"slug"`
+	Name      string `json:"name"`
+	Target    string `json:"target"`
+}
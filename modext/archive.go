@@ -19,12 +19,16 @@ type Archive struct {
 	Size   int64  `json:"size,omitempty"`
 	Source string `json:"source,omitempty"`
 
-	Artists    []*Artist   `json:"artists,omitempty"`
-	Circles    []*Circle   `json:"circles,omitempty"`
-	Magazines  []*Magazine `json:"magazines,omitempty"`
-	Parodies   []*Parody   `json:"parodies,omitempty"`
-	Tags       []*Tag      `json:"tags,omitempty"`
+	Artists    []*Taxonomy `json:"artists,omitempty"`
+	Circles    []*Taxonomy `json:"circles,omitempty"`
+	Magazines  []*Taxonomy `json:"magazines,omitempty"`
+	Parodies   []*Taxonomy `json:"parodies,omitempty"`
+	Tags       []*Taxonomy `json:"tags,omitempty"`
 	Submission *Submission `json:"submission,omitempty"`
+
+	// Taxonomies are the archive's taxonomies of
+	// the user-defined kinds, grouped by kind.
+	Taxonomies map[string][]*Taxonomy `json:"taxonomies,omitempty"`
 }
 
 func NewArchive(model *models.Archive) *Archive {
@@ -58,6 +62,24 @@ func NewArchive(model *models.Archive) *Archive {
 	return archive
 }
 
+// TaxonomiesOf returns the taxonomies of the archive of
+// the kind, which is either built in or user-defined.
+func (archive *Archive) TaxonomiesOf(kind string) []*Taxonomy {
+	switch kind {
+	case "artist":
+		return archive.Artists
+	case "circle":
+		return archive.Circles
+	case "magazine":
+		return archive.Magazines
+	case "parody":
+		return archive.Parodies
+	case "tag":
+		return archive.Tags
+	}
+	return archive.Taxonomies[kind]
+}
+
 func (archive *Archive) LoadRels(model *models.Archive) *Archive {
 	if model == nil || model.R == nil {
 		return archive
@@ -78,7 +100,7 @@ func (archive *Archive) LoadArtists(model *models.Archive) *Archive {
 		return archive
 	}
 
-	archive.Artists = make([]*Artist, len(model.R.Artists))
+	archive.Artists = make([]*Taxonomy, len(model.R.Artists))
 	for i, artist := range model.R.Artists {
 		archive.Artists[i] = NewArtist(artist)
 	}
@@ -91,7 +113,7 @@ func (archive *Archive) LoadCircle(model *models.Archive) *Archive {
 		return archive
 	}
 
-	archive.Circles = make([]*Circle, len(model.R.Circles))
+	archive.Circles = make([]*Taxonomy, len(model.R.Circles))
 	for i, circle := range model.R.Circles {
 		archive.Circles[i] = NewCircle(circle)
 	}
@@ -104,7 +126,7 @@ func (archive *Archive) LoadMagazine(model *models.Archive) *Archive {
 		return archive
 	}
 
-	archive.Magazines = make([]*Magazine, len(model.R.Magazines))
+	archive.Magazines = make([]*Taxonomy, len(model.R.Magazines))
 	for i, magazine := range model.R.Magazines {
 		archive.Magazines[i] = NewMagazine(magazine)
 	}
@@ -117,7 +139,7 @@ func (archive *Archive) LoadParody(model *models.Archive) *Archive {
 		return archive
 	}
 
-	archive.Parodies = make([]*Parody, len(model.R.Parodies))
+	archive.Parodies = make([]*Taxonomy, len(model.R.Parodies))
 	for i, parody := range model.R.Parodies {
 		archive.Parodies[i] = NewParody(parody)
 	}
@@ -130,7 +152,7 @@ func (archive *Archive) LoadTags(model *models.Archive) *Archive {
 		return archive
 	}
 
-	archive.Tags = make([]*Tag, len(model.R.Tags))
+	archive.Tags = make([]*Taxonomy, len(model.R.Tags))
 	for i, tag := range model.R.Tags {
 		archive.Tags[i] = NewTag(tag)
 	}
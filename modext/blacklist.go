@@ -0,0 +1,9 @@
+package modext
+
+type BlacklistRule struct {
+	ID        int64  `json:"id"`
+	CreatedAt int64  `json:"createdAt"`
+	DeletedAt int64  `json:"deletedAt,omitempty"`
+	Kind      string `json:"kind"`
+	Value     string `json:"value"`
+}
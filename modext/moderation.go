@@ -0,0 +1,15 @@
+package modext
+
+type ArchiveModeration struct {
+	ArchiveID int64 `json:"archiveId"`
+	CreatedAt int64 `json:"createdAt"`
+	UpdatedAt int64 `json:"updatedAt"`
+
+	Title string `json:"title"`
+	Path  string `json:"path"`
+
+	State   string   `json:"state"`
+	RuleID  int64    `json:"ruleId,omitempty"`
+	Rule    string   `json:"rule"`
+	Reasons []string `json:"reasons,omitempty"`
+}
@@ -0,0 +1,10 @@
+package modext
+
+type TagImplication struct {
+	ID          int64  `json:"id"`
+	CreatedAt   int64  `json:"createdAt"`
+	Slug        string `json:"slug"`
+	Name        string `json:"name"`
+	ImpliedSlug string `json:"impliedSlug"`
+	Implied     string `json:"implied"`
+}
@@ -0,0 +1,100 @@
+package modext
+
+import (
+	"strings"
+
+	"koushoku/models"
+)
+
+type TaxonomyKind struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Plural  string `json:"plural"`
+	Route   string `json:"route"`
+	Builtin bool   `json:"builtin,omitempty"`
+}
+
+// Taxonomy is an artist, a circle, a magazine, a parody, a tag
+// or a taxonomy of a user-defined kind.
+type Taxonomy struct {
+	ID    int64  `json:"id"`
+	Kind  string `json:"kind"`
+	Slug  string `json:"slug"`
+	Name  string `json:"name"`
+	Count int64  `json:"count,omitempty"`
+
+	// Namespace is only set for the tags.
+	Namespace string `json:"namespace,omitempty"`
+
+	Profile *TaxonomyProfile `json:"profile,omitempty"`
+}
+
+// NewTaxonomy returns a taxonomy of the kind. The namespace of the tags
+// is read from their slug, which is prefixed with it if they have one.
+func NewTaxonomy(kind string, id int64, slug, name string) *Taxonomy {
+	taxonomy := &Taxonomy{ID: id, Kind: kind, Slug: slug, Name: name}
+	if kind == "tag" {
+		if i := strings.Index(slug, ":"); i > 0 {
+			taxonomy.Namespace = slug[:i]
+		}
+	}
+	return taxonomy
+}
+
+func NewArtist(model *models.Artist) *Taxonomy {
+	if model == nil {
+		return nil
+	}
+	return NewTaxonomy("artist", model.ID, model.Slug, model.Name)
+}
+
+func NewCircle(model *models.Circle) *Taxonomy {
+	if model == nil {
+		return nil
+	}
+	return NewTaxonomy("circle", model.ID, model.Slug, model.Name)
+}
+
+func NewMagazine(model *models.Magazine) *Taxonomy {
+	if model == nil {
+		return nil
+	}
+	return NewTaxonomy("magazine", model.ID, model.Slug, model.Name)
+}
+
+func NewParody(model *models.Parody) *Taxonomy {
+	if model == nil {
+		return nil
+	}
+	return NewTaxonomy("parody", model.ID, model.Slug, model.Name)
+}
+
+// NewTag returns the tag of the model, the namespace
+// column not being part of the generated model.
+func NewTag(model *models.Tag) *Taxonomy {
+	if model == nil {
+		return nil
+	}
+	return NewTaxonomy("tag", model.ID, model.Slug, model.Name)
+}
+
+// QualifiedName returns the name of the taxonomy prefixed with its namespace.
+func (taxonomy *Taxonomy) QualifiedName() string {
+	if len(taxonomy.Namespace) == 0 {
+		return taxonomy.Name
+	}
+	return taxonomy.Namespace + ":" + taxonomy.Name
+}
+
+// TaxonomyProfile describes an artist, circle, magazine or parody.
+// Image is the file name of the image served under /profiles.
+type TaxonomyProfile struct {
+	Kind      string `json:"kind"`
+	Slug      string `json:"slug"`
+	UpdatedAt int64  `json:"updatedAt"`
+
+	Description string   `json:"description,omitempty"`
+	Links       []string `json:"links,omitempty"`
+	Image       string   `json:"image,omitempty"`
+	AltNames    []string `json:"altNames,omitempty"`
+}
@@ -0,0 +1,19 @@
+package modext
+
+import "encoding/json"
+
+type WebhookDelivery struct {
+	ID        int64 `json:"id"`
+	CreatedAt int64 `json:"createdAt"`
+	UpdatedAt int64 `json:"updatedAt"`
+
+	Webhook string          `json:"webhook"`
+	Event   string          `json:"event"`
+	Payload json.RawMessage `json:"payload"`
+
+	State         string `json:"state"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt int64  `json:"nextAttemptAt,omitempty"`
+	StatusCode    int    `json:"statusCode,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
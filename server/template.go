@@ -152,6 +152,19 @@ func setTemplate(name, key string, data any) ([]byte, error) {
 	return buf, nil
 }
 
+// PurgeTemplates removes the cached renders of the given templates.
+func PurgeTemplates(names ...string) {
+	for _, k := range cache.Templates.Keys() {
+		key := fmt.Sprintf("%v", k)
+		for _, name := range names {
+			if key == name || strings.HasPrefix(key, name+":") {
+				cache.Templates.Remove(key)
+				break
+			}
+		}
+	}
+}
+
 func renderTemplate(c *Context, opts *RenderOptions) {
 	var buf []byte
 	if opts.Cache {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"net/url"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -101,6 +102,29 @@ var helper = map[string]any{
 		return fmt.Sprintf("?%s", clone.Encode())
 	},
 
+	// withData returns a copy of the data with the key set to the value,
+	// to render a template such as the feed with other archives.
+	"withData": func(data map[string]any, key string, value any) map[string]any {
+		clone := make(map[string]any, len(data)+1)
+		for k, v := range data {
+			clone[k] = v
+		}
+		clone[key] = value
+		return clone
+	},
+
+	// archiveType returns the media type of an archive file for the
+	// acquisition links of the catalog.
+	"archiveType": func(path string) string {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".cbz":
+			return "application/vnd.comicbook+zip"
+		case ".rar":
+			return "application/vnd.rar"
+		}
+		return "application/zip"
+	},
+
 	"includes": func(slice []string, s string) bool {
 		for _, v := range slice {
 			if strings.EqualFold(v, s) {
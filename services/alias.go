@@ -4,79 +4,328 @@ import (
 	"bufio"
 	"log"
 	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
-	. "koushoku/config"
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/modext"
+
+	"github.com/pkg/errors"
 )
 
-var Aliases struct {
+var AliasKinds = []string{"title", "artist", "circle", "magazine", "parody", "tag"}
+
+type AliasSet struct {
 	ArchiveMatches  map[string]string
 	ArtistMatches   map[string]string
 	CircleMatches   map[string]string
 	MagazineMatches map[string]string
 	ParodyMatches   map[string]string
 	TagMatches      map[string]string
+}
+
+func newAliasSet() *AliasSet {
+	return &AliasSet{
+		ArchiveMatches:  make(map[string]string),
+		ArtistMatches:   make(map[string]string),
+		CircleMatches:   make(map[string]string),
+		MagazineMatches: make(map[string]string),
+		ParodyMatches:   make(map[string]string),
+		TagMatches:      make(map[string]string),
+	}
+}
+
+// matches returns the aliases of the given kind, or nil if it has none.
+func (set *AliasSet) matches(kind string) map[string]string {
+	switch kind {
+	case "title":
+		return set.ArchiveMatches
+	case "artist":
+		return set.ArtistMatches
+	case "circle":
+		return set.CircleMatches
+	case "magazine":
+		return set.MagazineMatches
+	case "parody":
+		return set.ParodyMatches
+	case "tag":
+		return set.TagMatches
+	}
+	return nil
+}
 
-	once sync.Once
+func (set *AliasSet) add(kind, slug, target string) {
+	if m := set.matches(kind); m != nil {
+		m[slug] = target
+	}
+}
+
+var aliases struct {
+	value atomic.Value
+	once  sync.Once
+}
+
+// GetAliases returns the currently loaded aliases.
+// The returned set must be treated as read-only, it is
+// replaced as a whole whenever the aliases are reloaded.
+func GetAliases() *AliasSet {
+	if v, ok := aliases.value.Load().(*AliasSet); ok {
+		return v
+	}
+	return newAliasSet()
 }
 
 func InitAliases() {
-	Aliases.once.Do(func() {
-		Aliases.ArchiveMatches = make(map[string]string)
-		Aliases.ArtistMatches = make(map[string]string)
-		Aliases.CircleMatches = make(map[string]string)
-		Aliases.MagazineMatches = make(map[string]string)
-		Aliases.ParodyMatches = make(map[string]string)
-		Aliases.TagMatches = make(map[string]string)
-
-		stat, err := os.Stat(Config.Paths.Alias)
-		if os.IsNotExist(err) || stat.IsDir() {
-			return
+	aliases.once.Do(func() {
+		if err := ReloadAliases(); err != nil {
+			log.Println(err)
+			aliases.value.Store(newAliasSet())
+		}
+	})
+}
+
+// ReloadAliases reads the aliases from the database and
+// atomically swaps them with the currently loaded aliases.
+func ReloadAliases() error {
+	set, err := loadAliases()
+	if err != nil {
+		return err
+	}
+	aliases.value.Store(set)
+	return nil
+}
+
+func loadAliases() (*AliasSet, error) {
+	// The alternative names of the profiles are loaded first,
+	// so that the aliases take precedence over them.
+	set := newAliasSet()
+	if err := loadProfileAliases(set); err != nil {
+		return nil, err
+	}
+
+	rows, err := database.Conn.Query(`SELECT kind, slug, target FROM alias WHERE deleted_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, slug, target string
+		if err := rows.Scan(&kind, &slug, &target); err != nil {
+			return nil, err
+		}
+		set.add(kind, slug, target)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// resolveAliases replaces the slugs that have an alias
+// with the slug of the name they are aliased to.
+func resolveAliases(matches map[string]string, slugs []string, slugify func(string) string) []string {
+	if len(matches) == 0 || len(slugs) == 0 {
+		return slugs
+	}
+	for i, slug := range slugs {
+		if v, ok := matches[slug]; ok {
+			slugs[i] = slugify(v)
+		}
+	}
+	sort.Strings(slugs)
+	return slugs
+}
+
+func isAliasKindValid(kind string) bool {
+	for _, v := range AliasKinds {
+		if v == kind {
+			return true
+		}
+	}
+	return false
+}
+
+const aliasCols = `id, EXTRACT(EPOCH FROM created_at)::BIGINT,
+	COALESCE(EXTRACT(EPOCH FROM deleted_at)::BIGINT, 0), kind, slug, name, target`
+
+func scanAlias(row interface{ Scan(...any) error }) (*modext.Alias, error) {
+	alias := &modext.Alias{}
+	err := row.Scan(&alias.ID, &alias.CreatedAt, &alias.DeletedAt,
+		&alias.Kind, &alias.Slug, &alias.Name, &alias.Target)
+	if err != nil {
+		return nil, err
+	}
+	return alias, nil
+}
+
+// CreateAlias creates an alias from name to target. An existing
+// alias for the same name is soft deleted so that it stays in the history.
+func CreateAlias(kind, name, target string) (*modext.Alias, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if !isAliasKindValid(kind) {
+		return nil, errs.AliasKindInvalid
+	}
+
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return nil, errs.AliasNameRequired
+	} else if len(name) > 1024 {
+		return nil, errs.AliasNameTooLong
+	}
+
+	target = strings.TrimSpace(target)
+	if len(target) == 0 {
+		return nil, errs.AliasTargetRequired
+	} else if len(target) > 1024 {
+		return nil, errs.AliasTargetTooLong
+	}
+
+	slug := Slugify(name)
+	if kind == "tag" {
+		slug = TagSlug(name)
+	}
+
+	if len(slug) == 0 {
+		return nil, errs.AliasNameRequired
+	}
+
+	tx, err := database.Conn.Begin()
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+
+	_, err = tx.Exec(`UPDATE alias SET deleted_at = NOW()
+		WHERE kind = $1 AND slug = $2 AND deleted_at IS NULL`, kind, slug)
+	if err != nil {
+		tx.Rollback()
+		log.Println(errors.WithStack(err))
+		return nil, errs.Unknown
+	}
+
+	alias, err := scanAlias(tx.QueryRow(`INSERT INTO alias (kind, slug, name, target)
+		VALUES ($1, $2, $3, $4) RETURNING `+aliasCols, kind, slug, name, target))
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		log.Println(errors.WithStack(err))
+		return nil, errs.Unknown
+	}
+	return alias, nil
+}
+
+// ListAliases returns the active aliases, optionally filtered by kind.
+func ListAliases(kind string) ([]*modext.Alias, error) {
+	q := `SELECT ` + aliasCols + ` FROM alias WHERE deleted_at IS NULL`
+	var args []any
+
+	if kind = strings.ToLower(strings.TrimSpace(kind)); len(kind) > 0 {
+		if !isAliasKindValid(kind) {
+			return nil, errs.AliasKindInvalid
 		}
+		q += ` AND kind = $1`
+		args = append(args, kind)
+	}
+
+	rows, err := database.Conn.Query(q+` ORDER BY kind ASC, slug ASC`, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	defer rows.Close()
 
-		f, err := os.Open(Config.Paths.Alias)
+	result := []*modext.Alias{}
+	for rows.Next() {
+		alias, err := scanAlias(rows)
 		if err != nil {
 			log.Println(err)
-			return
+			return nil, errs.Unknown
 		}
-		defer f.Close()
+		result = append(result, alias)
+	}
 
-		scanner := bufio.NewScanner(f)
-		scanner.Split(bufio.ScanLines)
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return result, nil
+}
 
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if len(line) == 0 {
-				continue
-			}
+// DeleteAlias soft deletes an alias by id.
+func DeleteAlias(id int64) error {
+	res, err := database.Conn.Exec(`UPDATE alias SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
 
-			strs := strings.Split(strings.ToLower(line), ":")
-			if len(strs) < 3 {
-				continue
-			}
+	if n, err := res.RowsAffected(); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	} else if n == 0 {
+		return errs.AliasNotFound
+	}
+	return nil
+}
 
-			k := Slugify(strs[1])
-			v := strings.TrimSpace(strings.Join(strs[2:], ":"))
-
-			switch strings.TrimSpace(strs[0]) {
-			case "title":
-				Aliases.ArchiveMatches[k] = v
-			case "artist":
-				Aliases.ArtistMatches[k] = v
-			case "circle":
-				Aliases.CircleMatches[k] = v
-			case "magazine":
-				Aliases.MagazineMatches[k] = v
-			case "parody":
-				Aliases.ParodyMatches[k] = v
-			case "tag":
-				Aliases.TagMatches[k] = v
-			}
+// ParseAlias parses an alias in the format of kind:name:target.
+func ParseAlias(line string) (kind, name, target string, ok bool) {
+	strs := strings.Split(strings.TrimSpace(line), ":")
+	if len(strs) < 3 {
+		return
+	}
+
+	kind = strings.ToLower(strings.TrimSpace(strs[0]))
+	name = strings.TrimSpace(strs[1])
+	target = strings.TrimSpace(strings.Join(strs[2:], ":"))
+	ok = len(kind) > 0 && len(name) > 0 && len(target) > 0
+	return
+}
+
+// ImportAliases imports the aliases from a text file with one
+// kind:name:target per line, and returns how many were imported.
+func ImportAliases(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
 		}
+		return 0, err
+	}
+	defer f.Close()
 
-		if err := scanner.Err(); err != nil {
-			log.Println(err)
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanLines)
+
+	var n int
+	for scanner.Scan() {
+		kind, name, target, ok := ParseAlias(strings.ToLower(scanner.Text()))
+		if !ok {
+			continue
 		}
-	})
+
+		if _, err := CreateAlias(kind, name, target); err != nil {
+			if err == errs.Unknown {
+				return n, err
+			}
+			log.Printf("Skipping alias %q: %s\n", scanner.Text(), err)
+			continue
+		}
+		n++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	return n, nil
 }
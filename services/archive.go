@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -48,28 +49,25 @@ func insertArchive(archive *modext.Archive, upsert bool) (*modext.Archive, error
 	var q []string
 	var args []any
 
-	if len(archive.Artists) > 0 {
-		for _, artist := range archive.Artists {
-			q = append(q, rawSqlArtistsMatch)
-			args = append(args, Slugify(artist.Name))
+	// An archive is told apart by its title along with
+	// its artists, or else its magazines or its circles.
+	for _, kind := range []string{"artist", "magazine", "circle"} {
+		values := archive.TaxonomiesOf(kind)
+		if len(values) == 0 {
+			continue
 		}
-		selectMods = append(selectMods,
-			Where(JoinOR(q...), args...))
-	} else if len(archive.Magazines) > 0 {
-		for _, magazine := range archive.Magazines {
-			q = append(q, rawSqlMagazinesMatch)
-			args = append(args, Slugify(magazine.Name))
-		}
-		selectMods = append(selectMods,
-			Where(JoinOR(q...), args...))
-	} else if len(archive.Circles) > 0 {
-		for _, circle := range archive.Circles {
-			q = append(q, rawSqlCirclesMatch)
-			args = append(args, Slugify(circle.Name))
+
+		t := taxonomyTables[kind]
+		for _, v := range values {
+			sql, sqlArgs := t.match(t.slug(v.Name), false)
+			q = append(q, sql)
+			args = append(args, sqlArgs...)
 		}
-		selectMods = append(selectMods,
-			Where(JoinOR(q...), args...))
-	} else {
+		selectMods = append(selectMods, Where(JoinOR(q...), args...))
+		break
+	}
+
+	if len(q) == 0 {
 		selectMods = append(selectMods,
 			Where("archive.path = ?", archive.Path))
 	}
@@ -128,7 +126,17 @@ func insertArchive(archive *modext.Archive, upsert bool) (*modext.Archive, error
 	}
 
 	// TODO: Purge cache
-	return modext.NewArchive(model), nil
+	result := modext.NewArchive(model)
+	if !isDuplicate {
+		created := *result
+		created.Artists = archive.Artists
+		created.Circles = archive.Circles
+		created.Magazines = archive.Magazines
+		created.Parodies = archive.Parodies
+		created.Tags = archive.Tags
+		emitEvent(EventArchiveCreated, &created)
+	}
+	return result, nil
 }
 
 func CreateArchive(archive *modext.Archive) (*modext.Archive, error) {
@@ -164,9 +172,11 @@ func GetArchive(id int64, opts GetArchiveOptions) (result *GetArchiveResult) {
 		}
 	}()
 
-	selectQueries := []QueryMod{Where("id = ?", id), And("published_at IS NOT NULL")}
+	selectQueries := []QueryMod{Where("id = ?", id), And("published_at IS NOT NULL"), And(rawSqlNotModerated)}
 	for _, v := range opts.Preloads {
-		if v == ArchiveRels.Artists || v == ArchiveRels.Circles || v == ArchiveRels.Tags {
+		if v == TaxonomiesRel {
+			continue
+		} else if v == ArchiveRels.Artists || v == ArchiveRels.Circles || v == ArchiveRels.Tags {
 			selectQueries = append(selectQueries, Load(v, OrderBy("name ASC")))
 		} else {
 			selectQueries = append(selectQueries, Load(v))
@@ -185,50 +195,31 @@ func GetArchive(id int64, opts GetArchiveOptions) (result *GetArchiveResult) {
 	}
 
 	result.Archive = modext.NewArchive(archive).LoadRels(archive)
+	if hasPreload(opts.Preloads, TaxonomiesRel) {
+		if err := loadArchiveTaxonomies(result.Archive); err != nil {
+			log.Println(err)
+			result.Archive = nil
+			result.Err = errs.Unknown
+		}
+	}
 	return
 }
 
+func hasPreload(preloads []string, rel string) bool {
+	for _, v := range preloads {
+		if v == rel {
+			return true
+		}
+	}
+	return false
+}
+
 type GetArchivesOptions struct {
 	Path string `json:"0,omitempty"`
 
 	TitleMatch    string `json:"1,omitempty"`
 	TitleWildcard string `json:"2,omitempty"`
 
-	ArtistsMatch            []string `json:"3,omitempty"`
-	ArtistsMatchAnd         []string `json:"4,omitempty"`
-	ArtistsWildcard         []string `json:"5,omitempty"`
-	ArtistsWildcardAnd      []string `json:"6,omitempty"`
-	ExcludedArtistsMatch    []string `json:"7,omitempty"`
-	ExcludedArtistsWildcard []string `json:"8,omitempty"`
-
-	CirclesMatch            []string `json:"9,omitempty"`
-	CirclesMatchAnd         []string `json:"10,omitempty"`
-	CirclesWildcard         []string `json:"11,omitempty"`
-	CirclesWildcardAnd      []string `json:"12,omitempty"`
-	ExcludedCirclesMatch    []string `json:"13,omitempty"`
-	ExcludedCirclesWildcard []string `json:"14,omitempty"`
-
-	MagazinesMatch            []string `json:"15,omitempty"`
-	MagazinesMatchAnd         []string `json:"16,omitempty"`
-	MagazinesWildcard         []string `json:"17,omitempty"`
-	MagazinesWildcardAnd      []string `json:"18,omitempty"`
-	ExcludedMagazinesMatch    []string `json:"19,omitempty"`
-	ExcludedMagazinesWildcard []string `json:"20,omitempty"`
-
-	ParodiesMatch            []string `json:"21,omitempty"`
-	ParodiesMatchAnd         []string `json:"22,omitempty"`
-	ParodiesWildcard         []string `json:"23,omitempty"`
-	ParodiesWildcardAnd      []string `json:"24,omitempty"`
-	ExcludedParodiesMatch    []string `json:"25,omitempty"`
-	ExcludedParodiesWildcard []string `json:"26,omitempty"`
-
-	TagsMatch            []string `json:"27,omitempty"`
-	TagsMatchAnd         []string `json:"28,omitempty"`
-	TagsWildcard         []string `json:"29,omitempty"`
-	TagsWildcardAnd      []string `json:"30,omitempty"`
-	ExcludedTagsMatch    []string `json:"31,omitempty"`
-	ExcludedTagsWildcard []string `json:"32,omitempty"`
-
 	PagesEq  int `json:"33,omitempty"`
 	PagesGt  int `json:"34,omitempty"`
 	PagesGte int `json:"35,omitempty"`
@@ -241,6 +232,75 @@ type GetArchivesOptions struct {
 	Sort     string   `json:"41,omitempty"`
 	Order    string   `json:"42,omitempty"`
 	All      bool     `json:"43,omitempty"`
+
+	// Taxonomies are the queries of the taxonomies, by kind.
+	Taxonomies map[string]*TaxonomyQuery `json:"44,omitempty"`
+
+	// Text is the free-text search over the titles and taxonomy names.
+	Text string `json:"45,omitempty"`
+
+	// Query is a parsed search query, see ParseSearchQuery.
+	Query *SearchNode `json:"46,omitempty"`
+
+	// Size filters by the size in bytes, like the pages.
+	SizeGt  int64 `json:"47,omitempty"`
+	SizeGte int64 `json:"48,omitempty"`
+	SizeLt  int64 `json:"49,omitempty"`
+	SizeLte int64 `json:"50,omitempty"`
+
+	// The dates are inclusive starts and exclusive ends.
+	CreatedFrom   *time.Time `json:"51,omitempty"`
+	CreatedTo     *time.Time `json:"52,omitempty"`
+	PublishedFrom *time.Time `json:"53,omitempty"`
+	PublishedTo   *time.Time `json:"54,omitempty"`
+
+	HasSource      bool   `json:"55,omitempty"`
+	HasSubmission  bool   `json:"56,omitempty"`
+	SourceWildcard string `json:"57,omitempty"`
+	SubmissionID   int64  `json:"58,omitempty"`
+
+	// Admin enables the filters below, which are otherwise ignored,
+	// and the expunged archives to be matched by the query.
+	Admin      bool  `json:"59,omitempty"`
+	Expunged   *bool `json:"60,omitempty"`
+	Redirected *bool `json:"61,omitempty"`
+
+	// Cursor continues the listing after the last archive of a previous
+	// result, see GetArchivesResult.Next, in place of the offset.
+	Cursor string `json:"62,omitempty"`
+	cursor *archiveCursor
+
+	// Facets is the number of the most common taxonomies
+	// of every kind to return along with the archives.
+	Facets int `json:"63,omitempty"`
+
+	// Trending restricts the archives to those viewed or
+	// downloaded in the days counted by SortTrending.
+	Trending bool `json:"64,omitempty"`
+}
+
+type TaxonomyQuery struct {
+	Match            []string `json:"1,omitempty"`
+	MatchAnd         []string `json:"2,omitempty"`
+	Wildcard         []string `json:"3,omitempty"`
+	WildcardAnd      []string `json:"4,omitempty"`
+	ExcludedMatch    []string `json:"5,omitempty"`
+	ExcludedWildcard []string `json:"6,omitempty"`
+}
+
+// Taxonomy returns the query of a kind, creating it if needed.
+func (opts *GetArchivesOptions) Taxonomy(kind string) *TaxonomyQuery {
+	kind = strings.ToLower(kind)
+	if opts.Taxonomies == nil {
+		opts.Taxonomies = make(map[string]*TaxonomyQuery)
+	}
+
+	q, ok := opts.Taxonomies[kind]
+	if !ok {
+		q = &TaxonomyQuery{}
+		opts.Taxonomies[kind] = q
+	}
+	return q
 }
 
 const (
@@ -250,44 +310,32 @@ const (
 
 func (opts *GetArchivesOptions) Validate() {
 	opts.Path = strings.ToLower(opts.Path)
+	opts.Text = normalizeSearchText(opts.Text)
 	opts.TitleMatch = Slugify(opts.TitleMatch)
 	opts.TitleWildcard = Slugify(opts.TitleWildcard)
 
-	opts.ArtistsMatch = SlugifyStrings(opts.ArtistsMatch)
-	opts.ArtistsMatchAnd = SlugifyStrings(opts.ArtistsMatchAnd)
-	opts.ArtistsWildcard = SlugifyStrings(opts.ArtistsWildcard)
-	opts.ArtistsWildcardAnd = SlugifyStrings(opts.ArtistsWildcardAnd)
-	opts.ExcludedArtistsMatch = SlugifyStrings(opts.ExcludedArtistsMatch)
-	opts.ExcludedArtistsWildcard = SlugifyStrings(opts.ExcludedArtistsWildcard)
-
-	opts.CirclesMatch = SlugifyStrings(opts.CirclesMatch)
-	opts.CirclesMatchAnd = SlugifyStrings(opts.CirclesMatchAnd)
-	opts.CirclesWildcard = SlugifyStrings(opts.CirclesWildcard)
-	opts.CirclesWildcardAnd = SlugifyStrings(opts.CirclesWildcardAnd)
-	opts.ExcludedCirclesMatch = SlugifyStrings(opts.ExcludedCirclesMatch)
-	opts.ExcludedCirclesWildcard = SlugifyStrings(opts.ExcludedCirclesWildcard)
-
-	opts.MagazinesMatch = SlugifyStrings(opts.MagazinesMatch)
-	opts.MagazinesMatchAnd = SlugifyStrings(opts.MagazinesMatchAnd)
-	opts.MagazinesWildcard = SlugifyStrings(opts.MagazinesWildcard)
-	opts.MagazinesWildcardAnd = SlugifyStrings(opts.MagazinesWildcardAnd)
-	opts.ExcludedMagazinesMatch = SlugifyStrings(opts.ExcludedMagazinesMatch)
-	opts.ExcludedMagazinesWildcard = SlugifyStrings(opts.ExcludedMagazinesWildcard)
-
-	opts.ParodiesMatch = SlugifyStrings(opts.ParodiesMatch)
-	opts.ParodiesMatchAnd = SlugifyStrings(opts.ParodiesMatchAnd)
-	opts.ParodiesWildcard = SlugifyStrings(opts.ParodiesWildcard)
-	opts.ParodiesWildcardAnd = SlugifyStrings(opts.ParodiesWildcardAnd)
-	opts.ExcludedParodiesMatch = SlugifyStrings(opts.ExcludedParodiesMatch)
-	opts.ExcludedParodiesWildcard = SlugifyStrings(opts.ExcludedParodiesWildcard)
-
-	opts.TagsMatch = SlugifyStrings(opts.TagsMatch)
-	opts.TagsMatchAnd = SlugifyStrings(opts.TagsMatchAnd)
-	opts.TagsWildcard = SlugifyStrings(opts.TagsWildcard)
-	opts.TagsWildcardAnd = SlugifyStrings(opts.TagsWildcardAnd)
-	opts.ExcludedTagsMatch = SlugifyStrings(opts.ExcludedTagsMatch)
-	opts.ExcludedTagsWildcard = SlugifyStrings(opts.ExcludedTagsWildcard)
+	aliases := GetAliases()
+	for kind, q := range opts.Taxonomies {
+		t, ok := getTaxonomyTable(kind)
+		if !ok {
+			delete(opts.Taxonomies, kind)
+			continue
+		}
+
+		q.Match = t.slugs(q.Match)
+		q.MatchAnd = t.slugs(q.MatchAnd)
+		q.Wildcard = t.slugs(q.Wildcard)
+		q.WildcardAnd = t.slugs(q.WildcardAnd)
+		q.ExcludedMatch = t.slugs(q.ExcludedMatch)
+		q.ExcludedWildcard = t.slugs(q.ExcludedWildcard)
+
+		matches := aliases.matches(kind)
+		q.Match = resolveAliases(matches, q.Match, t.slug)
+		q.MatchAnd = resolveAliases(matches, q.MatchAnd, t.slug)
+		q.ExcludedMatch = resolveAliases(matches, q.ExcludedMatch, t.slug)
+	}
 
+	opts.Facets = Min(Max(opts.Facets, 0), maxFacets)
 	if !opts.All {
 		opts.Limit = Max(opts.Limit, 0)
 		opts.Limit = Min(opts.Limit, 100)
@@ -305,6 +353,14 @@ func (opts *GetArchivesOptions) Validate() {
 		opts.Sort = ArchiveCols.Title
 	} else if strings.EqualFold(opts.Sort, ArchiveCols.Pages) {
 		opts.Sort = ArchiveCols.Pages
+	} else if strings.EqualFold(opts.Sort, ArchiveCols.Size) {
+		opts.Sort = ArchiveCols.Size
+	} else if strings.EqualFold(opts.Sort, SortPopular) {
+		opts.Sort = SortPopular
+	} else if strings.EqualFold(opts.Sort, SortTrending) {
+		opts.Sort = SortTrending
+	} else if strings.EqualFold(opts.Sort, SortRelevance) && len(opts.Text) > 0 {
+		opts.Sort = SortRelevance
 	} else {
 		opts.Sort = ArchiveCols.CreatedAt
 	}
@@ -316,9 +372,9 @@ func (opts *GetArchivesOptions) Validate() {
 	}
 }
 
+// ToQueries returns the mods of the listing and of its COUNT(*), the
+// latter sharing the conditions but not the order, limit and preloads.
 func (opts *GetArchivesOptions) ToQueries() (selectMods, countMods []QueryMod) {
-	countMods = []QueryMod{Select("1")}
-
 	var rawQueries []string
 	var rawArgs []any
 
@@ -327,6 +383,22 @@ func (opts *GetArchivesOptions) ToQueries() (selectMods, countMods []QueryMod) {
 		rawArgs = append(rawArgs, opts.Path)
 	}
 
+	if len(opts.Text) > 0 {
+		rawQueries = append(rawQueries, rawSqlSearch)
+		rawArgs = append(rawArgs, opts.Text, opts.Text)
+	}
+
+	if opts.Query != nil {
+		// The query has been compiled once when parsed, it can only fail
+		// if its kind has been removed since then, which matches nothing.
+		if sql, args, err := opts.Query.ToSql(); err == nil {
+			rawQueries = append(rawQueries, sql)
+			rawArgs = append(rawArgs, args...)
+		} else {
+			rawQueries = append(rawQueries, "FALSE")
+		}
+	}
+
 	if len(opts.TitleMatch) > 0 {
 		rawQueries = append(rawQueries, "archive.slug = ?")
 		rawArgs = append(rawArgs, opts.TitleMatch)
@@ -335,272 +407,163 @@ func (opts *GetArchivesOptions) ToQueries() (selectMods, countMods []QueryMod) {
 		rawArgs = append(rawArgs, opts.TitleWildcard)
 	}
 
-	if len(opts.ArtistsMatch) > 0 {
-		var q []string
-		for _, artist := range opts.ArtistsMatch {
-			q = append(q, rawSqlArtistsMatch)
-			rawArgs = append(rawArgs, artist)
-		}
-		rawQueries = append(rawQueries, JoinOR(q...))
+	kinds := make([]string, 0, len(opts.Taxonomies))
+	for kind := range opts.Taxonomies {
+		kinds = append(kinds, kind)
 	}
+	sort.Strings(kinds)
 
-	if len(opts.ArtistsMatchAnd) > 0 {
-		for _, artist := range opts.ArtistsMatchAnd {
-			rawQueries = append(rawQueries, rawSqlArtistsMatch)
-			rawArgs = append(rawArgs, artist)
+	for _, kind := range kinds {
+		t, ok := getTaxonomyTable(kind)
+		if !ok {
+			continue
 		}
-	}
 
-	if len(opts.ArtistsWildcard) > 0 {
-		var q []string
-		for _, artist := range opts.ArtistsWildcard {
-			q = append(q, rawSqlArtistsWildcard)
-			rawArgs = append(rawArgs, artist)
-		}
-		rawQueries = append(rawQueries, JoinOR(q...))
-	}
+		match := func(values []string, wildcard, or, exclude bool) {
+			var q []string
+			for _, v := range values {
+				sql, args := t.match(v, wildcard)
+				if exclude {
+					sql = "NOT " + sql
+				}
+				q = append(q, sql)
+				rawArgs = append(rawArgs, args...)
+			}
 
-	if len(opts.ArtistsWildcardAnd) > 0 {
-		for _, artist := range opts.ArtistsWildcardAnd {
-			rawQueries = append(rawQueries, rawSqlArtistsWildcard)
-			rawArgs = append(rawArgs, artist)
+			if or && len(q) > 0 {
+				rawQueries = append(rawQueries, JoinOR(q...))
+			} else {
+				rawQueries = append(rawQueries, q...)
+			}
 		}
-	}
 
-	if len(opts.ExcludedArtistsMatch) > 0 {
-		for _, artist := range opts.ExcludedArtistsMatch {
-			rawQueries = append(rawQueries, rawSqlExcludeArtistsMatch)
-			rawArgs = append(rawArgs, artist)
-		}
+		tq := opts.Taxonomies[kind]
+		match(tq.Match, false, true, false)
+		match(tq.MatchAnd, false, false, false)
+		match(tq.Wildcard, true, true, false)
+		match(tq.WildcardAnd, true, false, false)
+		match(tq.ExcludedMatch, false, false, true)
+		match(tq.ExcludedWildcard, true, false, true)
 	}
 
-	if len(opts.ExcludedArtistsWildcard) > 0 {
-		for _, artist := range opts.ExcludedArtistsWildcard {
-			rawQueries = append(rawQueries, rawSqlExcludeArtistsWildcard)
-			rawArgs = append(rawArgs, artist)
-		}
-	}
-
-	if len(opts.CirclesMatch) > 0 {
-		var q []string
-		for _, circle := range opts.CirclesMatch {
-			q = append(q, rawSqlCirclesMatch)
-			rawArgs = append(rawArgs, circle)
-		}
-		rawQueries = append(rawQueries, JoinOR(q...))
-	}
-
-	if len(opts.CirclesMatchAnd) > 0 {
-		for _, circle := range opts.CirclesMatchAnd {
-			rawQueries = append(rawQueries, rawSqlCirclesMatch)
-			rawArgs = append(rawArgs, circle)
-		}
-	}
-
-	if len(opts.CirclesWildcard) > 0 {
-		var q []string
-		for _, circle := range opts.CirclesWildcard {
-			q = append(q, rawSqlCirclesWildcard)
-			rawArgs = append(rawArgs, circle)
+	if opts.PagesEq > 0 {
+		selectMods = append(selectMods, Where("archive.pages = ?", opts.PagesEq))
+	} else {
+		if opts.PagesGt > 0 {
+			selectMods = append(selectMods, Where("archive.pages > ?", opts.PagesGt))
 		}
-		rawQueries = append(rawQueries, JoinOR(q...))
-	}
-
-	if len(opts.CirclesWildcardAnd) > 0 {
-		for _, circle := range opts.CirclesWildcardAnd {
-			rawQueries = append(rawQueries, rawSqlCirclesWildcard)
-			rawArgs = append(rawArgs, circle)
+		if opts.PagesGte > 0 {
+			selectMods = append(selectMods, Where("archive.pages >= ?", opts.PagesGte))
 		}
-	}
-
-	if len(opts.ExcludedCirclesMatch) > 0 {
-		for _, circle := range opts.ExcludedCirclesMatch {
-			rawQueries = append(rawQueries, rawSqlExcludeCirclesMatch)
-			rawArgs = append(rawArgs, circle)
+		if opts.PagesLt > 0 {
+			selectMods = append(selectMods, Where("archive.pages < ?", opts.PagesLt))
 		}
-	}
-
-	if len(opts.ExcludedCirclesWildcard) > 0 {
-		for _, circle := range opts.ExcludedCirclesWildcard {
-			rawQueries = append(rawQueries, rawSqlExcludeCirclesWildcard)
-			rawArgs = append(rawArgs, circle)
+		if opts.PagesLte > 0 {
+			selectMods = append(selectMods, Where("archive.pages <= ?", opts.PagesLte))
 		}
 	}
 
-	if len(opts.MagazinesMatch) > 0 {
-		var q []string
-		for _, magazine := range opts.MagazinesMatch {
-			q = append(q, rawSqlMagazinesMatch)
-			rawArgs = append(rawArgs, magazine)
-		}
-		rawQueries = append(rawQueries, JoinOR(q...))
+	if opts.SizeGt > 0 {
+		selectMods = append(selectMods, Where("archive.size > ?", opts.SizeGt))
 	}
-
-	if len(opts.MagazinesMatchAnd) > 0 {
-		for _, magazine := range opts.MagazinesMatchAnd {
-			rawQueries = append(rawQueries, rawSqlMagazinesMatch)
-			rawArgs = append(rawArgs, magazine)
-		}
+	if opts.SizeGte > 0 {
+		selectMods = append(selectMods, Where("archive.size >= ?", opts.SizeGte))
 	}
-
-	if len(opts.MagazinesWildcard) > 0 {
-		var q []string
-		for _, magazine := range opts.MagazinesWildcard {
-			q = append(q, rawSqlMagazinesWildcard)
-			rawArgs = append(rawArgs, magazine)
-		}
-		rawQueries = append(rawQueries, JoinOR(q...))
+	if opts.SizeLt > 0 {
+		selectMods = append(selectMods, Where("archive.size < ?", opts.SizeLt))
 	}
-
-	if len(opts.MagazinesWildcardAnd) > 0 {
-		for _, magazine := range opts.MagazinesWildcardAnd {
-			rawQueries = append(rawQueries, rawSqlMagazinesWildcard)
-			rawArgs = append(rawArgs, magazine)
-		}
+	if opts.SizeLte > 0 {
+		selectMods = append(selectMods, Where("archive.size <= ?", opts.SizeLte))
 	}
 
-	if len(opts.ExcludedMagazinesMatch) > 0 {
-		for _, magazine := range opts.ExcludedMagazinesMatch {
-			rawQueries = append(rawQueries, rawSqlExcludeMagazinesMatch)
-			rawArgs = append(rawArgs, magazine)
-		}
+	if opts.CreatedFrom != nil {
+		selectMods = append(selectMods, Where("archive.created_at >= ?", *opts.CreatedFrom))
 	}
-
-	if len(opts.ExcludedMagazinesWildcard) > 0 {
-		for _, magazine := range opts.ExcludedMagazinesWildcard {
-			rawQueries = append(rawQueries, rawSqlExcludeMagazinesWildcard)
-			rawArgs = append(rawArgs, magazine)
-		}
+	if opts.CreatedTo != nil {
+		selectMods = append(selectMods, Where("archive.created_at < ?", *opts.CreatedTo))
 	}
-
-	if len(opts.ParodiesMatch) > 0 {
-		var q []string
-		for _, parody := range opts.ParodiesMatch {
-			q = append(q, rawSqlParodiesMatch)
-			rawArgs = append(rawArgs, parody)
-		}
-		rawQueries = append(rawQueries, JoinOR(q...))
+	if opts.PublishedFrom != nil {
+		selectMods = append(selectMods, Where("archive.published_at >= ?", *opts.PublishedFrom))
 	}
-
-	if len(opts.ParodiesMatchAnd) > 0 {
-		for _, parody := range opts.ParodiesMatchAnd {
-			rawQueries = append(rawQueries, rawSqlParodiesMatch)
-			rawArgs = append(rawArgs, parody)
-		}
+	if opts.PublishedTo != nil {
+		selectMods = append(selectMods, Where("archive.published_at < ?", *opts.PublishedTo))
 	}
 
-	if len(opts.ParodiesWildcard) > 0 {
-		var q []string
-		for _, parody := range opts.ParodiesWildcard {
-			q = append(q, rawSqlParodiesWildcard)
-			rawArgs = append(rawArgs, parody)
-		}
-		rawQueries = append(rawQueries, JoinOR(q...))
+	if opts.HasSource {
+		selectMods = append(selectMods, Where("archive.source IS NOT NULL AND archive.source != ''"))
 	}
-
-	if len(opts.ParodiesWildcardAnd) > 0 {
-		for _, parody := range opts.ParodiesWildcardAnd {
-			rawQueries = append(rawQueries, rawSqlParodiesWildcard)
-			rawArgs = append(rawArgs, parody)
-		}
+	if opts.HasSubmission {
+		selectMods = append(selectMods, Where("archive.submission_id IS NOT NULL"))
 	}
-
-	if len(opts.ExcludedParodiesMatch) > 0 {
-		for _, parody := range opts.ExcludedParodiesMatch {
-			rawQueries = append(rawQueries, rawSqlExcludeParodiesMatch)
-			rawArgs = append(rawArgs, parody)
-		}
+	if len(opts.SourceWildcard) > 0 {
+		selectMods = append(selectMods, Where("archive.source ILIKE '%' || ? || '%'", opts.SourceWildcard))
 	}
-
-	if len(opts.ExcludedParodiesWildcard) > 0 {
-		for _, parody := range opts.ExcludedParodiesWildcard {
-			rawQueries = append(rawQueries, rawSqlExcludeParodiesWildcard)
-			rawArgs = append(rawArgs, parody)
-		}
+	if opts.SubmissionID > 0 {
+		selectMods = append(selectMods, Where("archive.submission_id = ?", opts.SubmissionID))
 	}
-
-	if len(opts.TagsMatch) > 0 {
-		var q []string
-		for _, tag := range opts.TagsMatch {
-			q = append(q, rawSqlTagsMatch)
-			rawArgs = append(rawArgs, tag)
-		}
-		rawQueries = append(rawQueries, JoinOR(q...))
+	if opts.Trending {
+		selectMods = append(selectMods, Where(rawSqlTrendingMatch))
 	}
 
-	if len(opts.TagsMatchAnd) > 0 {
-		for _, tag := range opts.TagsMatchAnd {
-			rawQueries = append(rawQueries, rawSqlTagsMatch)
-			rawArgs = append(rawArgs, tag)
+	if opts.Admin {
+		if opts.Expunged != nil {
+			selectMods = append(selectMods, Where("archive.expunged = ?", *opts.Expunged))
 		}
-	}
-
-	if len(opts.TagsWildcard) > 0 {
-		var q []string
-		for _, tag := range opts.TagsWildcard {
-			q = append(q, rawSqlTagsWildcard)
-			rawArgs = append(rawArgs, tag)
+		if opts.Redirected != nil {
+			if *opts.Redirected {
+				selectMods = append(selectMods, Where("archive.redirect_id IS NOT NULL"))
+			} else {
+				selectMods = append(selectMods, Where("archive.redirect_id IS NULL"))
+			}
 		}
-		rawQueries = append(rawQueries, JoinOR(q...))
 	}
 
-	if len(opts.TagsWildcardAnd) > 0 {
-		for _, tag := range opts.TagsWildcardAnd {
-			rawQueries = append(rawQueries, rawSqlTagsWildcard)
-			rawArgs = append(rawArgs, tag)
-		}
+	if len(rawQueries) > 0 {
+		selectMods = append(selectMods, Where(strings.Join(rawQueries, " AND "), rawArgs...))
 	}
 
-	if len(opts.ExcludedTagsMatch) > 0 {
-		for _, tag := range opts.ExcludedTagsMatch {
-			rawQueries = append(rawQueries, rawSqlExcludeTagsMatch)
-			rawArgs = append(rawArgs, tag)
-		}
+	// The expunged archives are hidden unless an administrator asks for them.
+	if opts.Admin && (opts.Expunged != nil || opts.Query.HasField("expunged")) {
+		selectMods = append(selectMods, Where("archive.published_at IS NOT NULL"), Where(rawSqlNotModerated))
+	} else {
+		selectMods = append(selectMods, Where("archive.published_at IS NOT NULL AND archive.expunged IS FALSE"), Where(rawSqlNotModerated))
 	}
+	countMods = append(countMods, selectMods...)
 
-	if len(opts.ExcludedTagsWildcard) > 0 {
-		for _, tag := range opts.ExcludedTagsWildcard {
-			rawQueries = append(rawQueries, rawSqlExcludeTagsWildcard)
-			rawArgs = append(rawArgs, tag)
+	offset := opts.Offset
+	if opts.cursor != nil {
+		var where string
+		var args []any
+		if where, args, offset = opts.cursor.sql(); len(where) > 0 {
+			selectMods = append(selectMods, Where(where, args...))
 		}
 	}
 
-	if opts.PagesEq > 0 {
-		selectMods = append(selectMods, Where("archive.pages = ?", opts.PagesEq))
+	// The id breaks the ties, which the cursors rely on.
+	if opts.Sort == SortRelevance {
+		selectMods = append(selectMods, OrderBy(rawSqlSearchRank, opts.Text, opts.Text))
+	} else if opts.Sort == SortPopular {
+		selectMods = append(selectMods, OrderBy(fmt.Sprintf("%s %s, archive.id %s", rawSqlPopularity, opts.Order, opts.Order)))
+	} else if opts.Sort == SortTrending {
+		selectMods = append(selectMods, OrderBy(fmt.Sprintf("%s %s, archive.id %s", rawSqlTrending, opts.Order, opts.Order)))
+	} else if opts.Sort == ArchiveCols.ID {
+		selectMods = append(selectMods, OrderBy(fmt.Sprintf("archive.id %s", opts.Order)))
 	} else {
-		if opts.PagesGt > 0 {
-			selectMods = append(selectMods, Where("archive.pages > ?", opts.PagesGt))
-		}
-		if opts.PagesGte > 0 {
-			selectMods = append(selectMods, Where("archive.pages >= ?", opts.PagesGte))
-		}
-		if opts.PagesLt > 0 {
-			selectMods = append(selectMods, Where("archive.pages < ?", opts.PagesLt))
-		}
-		if opts.PagesLte > 0 {
-			selectMods = append(selectMods, Where("archive.pages <= ?", opts.PagesLte))
-		}
-	}
-
-	if len(rawQueries) > 0 {
-		selectMods = append(selectMods, Where(strings.Join(rawQueries, " AND "), rawArgs...))
+		selectMods = append(selectMods, OrderBy(fmt.Sprintf("archive.%s %s, archive.id %s", opts.Sort, opts.Order, opts.Order)))
 	}
 
-	selectMods = append(selectMods, Where("archive.published_at IS NOT NULL AND archive.expunged IS FALSE"))
-	countMods = append(countMods, selectMods...)
-
-	selectMods = append(selectMods, OrderBy(fmt.Sprintf("%s %s", opts.Sort, opts.Order)))
-
 	if opts.Limit > 0 {
 		selectMods = append(selectMods, Limit(opts.Limit))
 	}
 
-	if opts.Offset > 0 {
-		selectMods = append(selectMods, Offset(opts.Offset))
+	if offset > 0 {
+		selectMods = append(selectMods, Offset(offset))
 	}
 
 	for _, v := range opts.Preloads {
-		selectMods = append(selectMods, Load(v))
+		if v != TaxonomiesRel {
+			selectMods = append(selectMods, Load(v))
+		}
 	}
 	return
 }
@@ -609,25 +572,40 @@ type GetArchivesResult struct {
 	Archives []*modext.Archive `json:"data"`
 	Total    int               `json:"total"`
 	Err      error             `json:"error,omitempty"`
+
+	// Next is the cursor of the next page, if the page is full.
+	Next string `json:"next,omitempty"`
+
+	Facets []*Facet `json:"facets,omitempty"`
 }
 
+const archivesCachePrefix = "archives"
+
 func GetArchives(opts *GetArchivesOptions) (result *GetArchivesResult) {
 	opts.Validate()
 
-	const prefix = "archives"
 	cacheKey := makeCacheKey(opts)
-	if c, err := cache.Archives.GetWithPrefix(prefix, cacheKey); err == nil {
+	if c, err := cache.Archives.GetWithPrefix(archivesCachePrefix, cacheKey); err == nil {
 		return c.(*GetArchivesResult)
 	}
 
 	result = &GetArchivesResult{Archives: []*modext.Archive{}}
 	defer func() {
 		if len(result.Archives) > 0 || result.Total > 0 || result.Err != nil {
-			cache.Archives.RemoveWithPrefix(prefix, cacheKey)
-			cache.Archives.SetWithPrefix(prefix, cacheKey, result, 0)
+			cache.Archives.RemoveWithPrefix(archivesCachePrefix, cacheKey)
+			cache.Archives.SetWithPrefix(archivesCachePrefix, cacheKey, result, 0)
 		}
 	}()
 
+	if len(opts.Cursor) > 0 {
+		cursor, err := decodeArchiveCursor(opts.Cursor, opts.Sort, opts.Order)
+		if err != nil {
+			result.Err = err
+			return
+		}
+		opts.cursor = cursor
+	}
+
 	selectMods, countMods := opts.ToQueries()
 	archives, err := models.Archives(selectMods...).AllG()
 	if err != nil {
@@ -636,7 +614,7 @@ func GetArchives(opts *GetArchivesOptions) (result *GetArchivesResult) {
 		return
 	}
 
-	count, err := models.Archives(countMods...).AllG()
+	count, err := models.Archives(countMods...).CountG()
 	if err != nil {
 		log.Println(err)
 		result.Err = errs.Unknown
@@ -644,21 +622,51 @@ func GetArchives(opts *GetArchivesOptions) (result *GetArchivesResult) {
 	}
 
 	result.Archives = make([]*modext.Archive, len(archives))
-	result.Total = len(count)
+	result.Total = int(count)
 
 	for i, archive := range archives {
 		result.Archives[i] = modext.NewArchive(archive).LoadRels(archive)
 	}
+
+	if opts.Limit > 0 && len(archives) == opts.Limit {
+		result.Next = newArchiveCursor(opts, archives[len(archives)-1], len(archives)).encode()
+	}
+
+	if opts.Facets > 0 && count > 0 {
+		if result.Facets, err = getArchivesFacets(countMods, opts.Facets); err != nil {
+			log.Println(err)
+			result.Archives = []*modext.Archive{}
+			result.Err = errs.Unknown
+			return
+		}
+	}
+
+	if hasPreload(opts.Preloads, TaxonomiesRel) {
+		if err := loadArchiveTaxonomies(result.Archives...); err != nil {
+			log.Println(err)
+			result.Archives = []*modext.Archive{}
+			result.Err = errs.Unknown
+		}
+	}
 	return
 }
 
+// PurgeArchivesResults removes the cached results of GetArchives,
+// GetRelatedArchives and GetFavorites, without touching the cached
+// archives themselves.
+func PurgeArchivesResults() {
+	cache.Archives.PurgeWithPrefix(archivesCachePrefix)
+	cache.Archives.PurgeWithPrefix(relatedCachePrefix)
+	cache.Favorites.Purge()
+}
+
 func GetArchiveCount() (int64, error) {
 	const archiveCountCacheKey = "archiveCount"
 	if c, err := cache.Archives.Get(archiveCountCacheKey); err == nil {
 		return c.(int64), nil
 	}
 
-	count, err := models.Archives(Where("published_at IS NOT NULL AND expunged IS FALSE")).CountG()
+	count, err := models.Archives(Where("published_at IS NOT NULL AND expunged IS FALSE"), Where(rawSqlNotModerated)).CountG()
 	if err != nil {
 		log.Println(err)
 		return 0, errs.Unknown
@@ -686,7 +694,7 @@ func GetArchiveStats() (size, pages int64, err error) {
 		return
 	}
 
-	archives, err := models.Archives(Where("published_at IS NOT NULL AND expunged IS FALSE")).AllG()
+	archives, err := models.Archives(Where("published_at IS NOT NULL AND expunged IS FALSE"), Where(rawSqlNotModerated)).AllG()
 	if err != nil {
 		log.Println(err)
 		err = errs.Unknown
@@ -720,17 +728,56 @@ func PublishArchive(id int64) (*modext.Archive, error) {
 	}
 
 	// TODO: Purge cache
-	return modext.NewArchive(archive), nil
+	result := modext.NewArchive(archive)
+	emitEvent(EventArchivePublished, result)
+	return result, nil
 }
 
 func PublishArchives() error {
-	err := models.Archives(Where("published_at IS NULL")).
-		UpdateAllG(models.M{"published_at": null.TimeFrom(time.Now().UTC())})
+	publishedAt := null.TimeFrom(time.Now().UTC())
+	return updateArchives(models.M{"published_at": publishedAt}, EventArchivePublished,
+		func(archive *models.Archive) {
+			archive.PublishedAt = publishedAt
+		}, Where("published_at IS NULL"), Where(rawSqlNotModerated))
+}
+
+// updateArchives updates the archives matching the query mods and queues
+// the event about each of them in the same transaction, once set has made
+// their loaded copies match the update.
+func updateArchives(cols models.M, event string, set func(archive *models.Archive), mods ...QueryMod) error {
+	tx, err := database.Conn.Begin()
 	if err != nil {
 		log.Println(err)
 		return errs.Unknown
 	}
+
+	archives, err := models.Archives(append(mods, For("UPDATE"))...).All(tx)
+	if err == nil {
+		err = models.Archives(mods...).UpdateAll(tx, cols)
+	}
+
+	if err == nil {
+		data := make([]any, len(archives))
+		for i, archive := range archives {
+			set(archive)
+			data[i] = modext.NewArchive(archive)
+		}
+		err = queueEvents(tx, event, data...)
+	}
+
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
 	// TODO: Purge cache
+	wakeWebhookDeliverer()
 	return nil
 }
 
@@ -751,75 +798,106 @@ func UnpublishArchive(id int64) (*modext.Archive, error) {
 	}
 
 	// TODO: Purge cache
-	return modext.NewArchive(archive), nil
+	result := modext.NewArchive(archive)
+	emitEvent(EventArchiveUnpublished, result)
+	return result, nil
 }
 
 func UnpublishArchives() error {
-	err := models.Archives(Where("published_at IS NOT NULL")).
-		UpdateAllG(models.M{"published_at": null.NewTime(time.Now(), false)})
-	if err != nil {
-		log.Println(err)
-		return errs.Unknown
-	}
-	// TODO: Purge cache
-	return nil
+	return updateArchives(models.M{"published_at": null.NewTime(time.Now(), false)}, EventArchiveUnpublished,
+		func(archive *models.Archive) {
+			archive.PublishedAt.Valid = false
+		}, Where("published_at IS NOT NULL"))
+}
+
+// ExpungeArchive expunges the archive, or restores it if it is expunged.
+func ExpungeArchive(id int64) (*modext.Archive, error) {
+	return setArchiveExpunged(id, func(expunged bool) bool {
+		return !expunged
+	})
+}
+
+// SetArchiveExpunged expunges or restores the archive. An archive already
+// in that state is left as is and no event is emitted, so that a request
+// can be retried without effect.
+func SetArchiveExpunged(id int64, expunged bool) (*modext.Archive, error) {
+	return setArchiveExpunged(id, func(bool) bool {
+		return expunged
+	})
 }
 
-func ExpungeArchive(id int64) error {
+func setArchiveExpunged(id int64, fn func(expunged bool) bool) (*modext.Archive, error) {
 	archive, err := models.FindArchiveG(id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return errs.ArchiveNotFound
+			return nil, errs.ArchiveNotFound
 		}
 		log.Println(err)
-		return errs.Unknown
+		return nil, errs.Unknown
 	}
 
-	archive.Expunged = !archive.Expunged
+	expunged := fn(archive.Expunged)
+	if archive.Expunged == expunged {
+		return modext.NewArchive(archive), nil
+	}
+
+	archive.Expunged = expunged
 	if err := archive.UpdateG(boil.Whitelist(ArchiveCols.Expunged)); err != nil {
 		log.Println(err)
-		return errs.Unknown
+		return nil, errs.Unknown
 	}
 
-	return nil
+	result := modext.NewArchive(archive)
+	if archive.Expunged {
+		emitEvent(EventArchiveExpunged, result)
+	} else {
+		emitEvent(EventArchiveRestored, result)
+	}
+	return result, nil
 }
 
-func RedirectArchive(from, to int64) error {
+func RedirectArchive(from, to int64) (*modext.Archive, error) {
+	if to <= 0 || to == from {
+		return nil, errs.ArchiveRedirectInvalid
+	}
+
 	archive, err := models.FindArchiveG(from)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return errs.ArchiveNotFound
+			return nil, errs.ArchiveNotFound
 		}
 		log.Println(err)
-		return errs.Unknown
+		return nil, errs.Unknown
 	}
 
 	archive.RedirectID = null.Int64From(to)
 	if err := archive.UpdateG(boil.Whitelist(ArchiveCols.RedirectID)); err != nil {
 		log.Println(err)
-		return errs.Unknown
+		return nil, errs.Unknown
 	}
 
-	return nil
+	result := modext.NewArchive(archive)
+	emitEvent(EventArchiveRedirected, result)
+	return result, nil
 }
 
-func SetArchiveSource(id int64, source string) error {
+func SetArchiveSource(id int64, source string) (*modext.Archive, error) {
 	archive, err := models.FindArchiveG(id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return errs.ArchiveNotFound
+			return nil, errs.ArchiveNotFound
 		}
 		log.Println(err)
-		return errs.Unknown
+		return nil, errs.Unknown
 	}
 
 	archive.Source = null.NewString(source, len(source) > 0)
 	if err := archive.UpdateG(boil.Whitelist(ArchiveCols.Source)); err != nil {
 		log.Println(err)
-		return errs.Unknown
+		return nil, errs.Unknown
 	}
 
-	return nil
+	return modext.NewArchive(archive), nil
 }
 
 func DeleteArchive(id int64) error {
@@ -839,15 +917,43 @@ func DeleteArchive(id int64) error {
 
 	// TODO: Purge cache
 	os.Remove(filepath.Join(Config.Directories.Symlinks, strconv.Itoa(int(id))))
+	emitEvent(EventArchiveDeleted, modext.NewArchive(archive))
 	return nil
 }
 
 func DeleteArchives() error {
-	if err := models.Archives().DeleteAllG(); err != nil {
+	tx, err := database.Conn.Begin()
+	if err != nil {
 		log.Println(err)
 		return errs.Unknown
 	}
+
+	archives, err := models.Archives(For("UPDATE")).All(tx)
+	if err == nil {
+		err = models.Archives().DeleteAll(tx)
+	}
+
+	if err == nil {
+		data := make([]any, len(archives))
+		for i, archive := range archives {
+			data[i] = modext.NewArchive(archive)
+		}
+		err = queueEvents(tx, EventArchiveDeleted, data...)
+	}
+
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
 	// TODO: Purge cache
 	// TODO: Remove symlinks
+	wakeWebhookDeliverer()
 	return nil
 }
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "koushoku/config"
+	"koushoku/database"
+	"koushoku/models"
+
+	. "github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// legacySqlTagsMatch and legacySqlExcludeTagsMatch are the former tag filters,
+// which count the rows of a join for every archive, kept to be benchmarked.
+const legacySqlTagsMatch = `(
+	SELECT COUNT(*) FROM archive_tags
+	LEFT JOIN tag ON tag.id = archive_tags.tag_id
+		AND archive_tags.archive_id = archive.id
+	WHERE tag.slug = ?
+) > 0`
+
+const legacySqlExcludeTagsMatch = `(
+	SELECT COUNT(*) FROM archive_tags
+	LEFT JOIN tag ON tag.id = archive_tags.tag_id
+		AND archive_tags.archive_id = archive.id
+	WHERE tag.slug = ?
+) = 0`
+
+const (
+	benchmarkArchives = 10000
+	benchmarkTags     = 50
+	benchmarkTagRate  = 0.1
+)
+
+// benchmarkWords make up the titles of the seeded archives, three
+// of them per title, so that the searches match varied subsets.
+var benchmarkWords = []string{
+	"summer", "winter", "night", "festival", "school", "days", "secret", "garden",
+	"ocean", "city", "lights", "dream", "story", "love", "letter", "home",
+	"rain", "forest", "station", "cafe", "memory", "star", "river", "morning",
+	"island", "holiday", "train", "journey", "promise", "friend", "sky", "moon",
+	"sister", "teacher", "office", "library", "shrine", "beach", "snow", "spring",
+}
+
+// The seeded tags and archives are recognizable by their benchmark- slugs
+// and paths, every archive having each tag with a probability of benchmarkTagRate.
+var rawSqlSeedBenchmarkTags = fmt.Sprintf(`INSERT INTO tag (slug, name)
+SELECT 'benchmark-tag-' || i, 'Benchmark Tag ' || i FROM generate_series(1, %d) i
+ON CONFLICT DO NOTHING`, benchmarkTags)
+
+// The path contains the title, as the former search scanned the paths.
+var rawSqlSeedBenchmarkArchives = fmt.Sprintf(`INSERT INTO archive (path, title, slug, pages, size, published_at, created_at)
+SELECT '/benchmark/' || i || ' ' || t.title || '.zip', t.title, 'benchmark-' || i,
+	20 + i %% 200, (1 + i %% 100) * 1048576, NOW(), NOW() - i * INTERVAL '1 minute'
+FROM generate_series(1, $1) i, LATERAL (
+	SELECT initcap(w[1 + i %% %[1]d] || ' ' || w[1 + (i / %[1]d) %% %[1]d] || ' ' || w[1 + (i * 7 + 3) %% %[1]d]) AS title
+	FROM (SELECT ARRAY['%[2]s'] AS w) words
+) t
+ON CONFLICT DO NOTHING`, len(benchmarkWords), strings.Join(benchmarkWords, "', '"))
+
+var rawSqlSeedBenchmarkArchiveTags = fmt.Sprintf(`INSERT INTO archive_tags (archive_id, tag_id)
+SELECT archive.id, tag.id FROM archive, tag
+WHERE archive.path LIKE '/benchmark/%%' AND tag.slug LIKE 'benchmark-tag-%%' AND random() < %g
+ON CONFLICT DO NOTHING`, benchmarkTagRate)
+
+func seedBenchmark(tx *sql.Tx, seed int) error {
+	if _, err := tx.Exec(rawSqlSeedBenchmarkTags); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(rawSqlSeedBenchmarkArchives, seed); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(rawSqlSeedBenchmarkArchiveTags); err != nil {
+		return err
+	}
+	if err := refreshArchiveSearch(tx, "a.path LIKE '/benchmark/%'"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("ANALYZE archive, archive_tags, archive_search, tag")
+	return err
+}
+
+// beginBenchmark seeds the database of the config with benchmarkArchives
+// archives in a transaction, which is rolled back once the benchmark is
+// done, so the database is left untouched. The benchmark is skipped when
+// the database cannot be reached.
+func beginBenchmark(b *testing.B) *sql.Tx {
+	cfg := Config.Database
+	dsn := fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s",
+		cfg.Host, cfg.Port, cfg.Name, cfg.User, cfg.Passwd, cfg.SSLMode)
+
+	conn, err := sql.Open("pgx", dsn)
+	if err == nil {
+		err = conn.Ping()
+		conn.Close()
+	}
+	if err != nil {
+		b.Skipf("Database unavailable: %s", err)
+	}
+	database.Init()
+
+	tx, err := database.Conn.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		tx.Rollback()
+	})
+
+	if err := seedBenchmark(tx, benchmarkArchives); err != nil {
+		b.Fatal(err)
+	}
+	return tx
+}
+
+// runLegacyFilters runs the listing the way it was done before the filters
+// were rewritten, loading every matching row to count them.
+func runLegacyFilters(tx *sql.Tx, tags, excluded []string) (int, error) {
+	var q []string
+	var args []any
+	for _, tag := range tags {
+		q = append(q, legacySqlTagsMatch)
+		args = append(args, tag)
+	}
+	for _, tag := range excluded {
+		q = append(q, legacySqlExcludeTagsMatch)
+		args = append(args, tag)
+	}
+
+	where := []QueryMod{
+		Where(strings.Join(q, " AND "), args...),
+		Where("archive.published_at IS NOT NULL AND archive.expunged IS FALSE"),
+		Where(rawSqlNotModerated),
+	}
+
+	selectMods := append(append([]QueryMod{}, where...), OrderBy("created_at desc"), Limit(25))
+	if _, err := models.Archives(selectMods...).All(tx); err != nil {
+		return 0, err
+	}
+
+	countMods := append([]QueryMod{Select("archive.id")}, where...)
+	count, err := models.Archives(countMods...).All(tx)
+	if err != nil {
+		return 0, err
+	}
+	return len(count), nil
+}
+
+// runFilters runs the listing and its count with the current filters.
+func runFilters(tx *sql.Tx, selectMods, countMods []QueryMod) (int64, error) {
+	if _, err := models.Archives(selectMods...).All(tx); err != nil {
+		return 0, err
+	}
+	return models.Archives(countMods...).Count(tx)
+}
+
+// BenchmarkFilters times the multi-tag AND and exclude listings with
+// the former filters and with the current ones, on seeded archives.
+func BenchmarkFilters(b *testing.B) {
+	tx := beginBenchmark(b)
+
+	tag := func(i int) string {
+		return fmt.Sprintf("benchmark-tag-%d", i)
+	}
+
+	cases := []struct {
+		name     string
+		tags     []string
+		excluded []string
+	}{
+		{"2 tags", []string{tag(1), tag(2)}, nil},
+		{"3 tags", []string{tag(1), tag(2), tag(3)}, nil},
+		{"2 tags, 1 excluded", []string{tag(1), tag(2)}, []string{tag(3)}},
+		{"1 tag, 3 excluded", []string{tag(1)}, []string{tag(2), tag(3), tag(4)}},
+	}
+
+	for _, c := range cases {
+		opts := &GetArchivesOptions{Limit: 25}
+		opts.Taxonomy("tag").MatchAnd = append([]string{}, c.tags...)
+		opts.Taxonomy("tag").ExcludedMatch = append([]string{}, c.excluded...)
+		opts.Validate()
+		selectMods, countMods := opts.ToQueries()
+
+		legacyTotal, err := runLegacyFilters(tx, c.tags, c.excluded)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total, err := runFilters(tx, selectMods, countMods)
+		if err != nil {
+			b.Fatal(err)
+		} else if int64(legacyTotal) != total {
+			b.Errorf("%s: the former filters matched %d archives instead of %d", c.name, legacyTotal, total)
+		}
+
+		b.Run(c.name+"/before", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := runLegacyFilters(tx, c.tags, c.excluded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(c.name+"/after", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := runFilters(tx, selectMods, countMods); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
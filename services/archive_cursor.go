@@ -0,0 +1,124 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"koushoku/errs"
+	"koushoku/models"
+)
+
+// archiveCursor is the position of a listing after its last archive, made
+// of the value of the sort column and of the id breaking the ties. The
+// relevance, popularity and trend are not columns, so their cursor is the
+// offset of the next page.
+type archiveCursor struct {
+	Sort   string `json:"s"`
+	Order  string `json:"o"`
+	Value  string `json:"v,omitempty"`
+	ID     int64  `json:"i,omitempty"`
+	Offset int    `json:"n,omitempty"`
+}
+
+// isOffsetSort returns whether the cursors of the sort are offsets.
+func isOffsetSort(sort string) bool {
+	return sort == SortRelevance || sort == SortPopular || sort == SortTrending
+}
+
+func (c *archiveCursor) encode() string {
+	buf, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// value returns the value of the sort column as its database type.
+func (c *archiveCursor) value() (any, error) {
+	switch c.Sort {
+	case ArchiveCols.CreatedAt, ArchiveCols.UpdatedAt, ArchiveCols.PublishedAt:
+		return time.Parse(time.RFC3339Nano, c.Value)
+	case ArchiveCols.Pages:
+		return strconv.Atoi(c.Value)
+	case ArchiveCols.Size:
+		return strconv.ParseInt(c.Value, 10, 64)
+	case ArchiveCols.Title:
+		return c.Value, nil
+	}
+	return nil, fmt.Errorf("cursor of sort %q has no value", c.Sort)
+}
+
+// decodeArchiveCursor decodes the cursor, which must have been
+// built for the given sort and order.
+func decodeArchiveCursor(str, sort, order string) (*archiveCursor, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, errs.CursorInvalid
+	}
+
+	c := &archiveCursor{}
+	if err := json.Unmarshal(buf, c); err != nil || c.Sort != sort || c.Order != order {
+		return nil, errs.CursorInvalid
+	}
+
+	if isOffsetSort(sort) {
+		if c.Offset <= 0 {
+			return nil, errs.CursorInvalid
+		}
+	} else if c.ID <= 0 {
+		return nil, errs.CursorInvalid
+	} else if sort != ArchiveCols.ID {
+		if _, err := c.value(); err != nil {
+			return nil, errs.CursorInvalid
+		}
+	}
+	return c, nil
+}
+
+// newArchiveCursor returns the cursor of the listing after the given archive.
+func newArchiveCursor(opts *GetArchivesOptions, last *models.Archive, count int) *archiveCursor {
+	c := &archiveCursor{Sort: opts.Sort, Order: opts.Order}
+	switch opts.Sort {
+	case SortRelevance, SortPopular, SortTrending:
+		c.Offset = opts.Offset + count
+		if opts.cursor != nil {
+			c.Offset = opts.cursor.Offset + count
+		}
+		return c
+	case ArchiveCols.CreatedAt:
+		c.Value = last.CreatedAt.Format(time.RFC3339Nano)
+	case ArchiveCols.UpdatedAt:
+		c.Value = last.UpdatedAt.Format(time.RFC3339Nano)
+	case ArchiveCols.PublishedAt:
+		c.Value = last.PublishedAt.Time.Format(time.RFC3339Nano)
+	case ArchiveCols.Title:
+		c.Value = last.Title
+	case ArchiveCols.Pages:
+		c.Value = strconv.Itoa(int(last.Pages))
+	case ArchiveCols.Size:
+		c.Value = strconv.FormatInt(last.Size, 10)
+	}
+	c.ID = last.ID
+	return c
+}
+
+// sql returns the condition on the archives after the cursor, comparing
+// the sort column and the id as a row to make use of the (column, id)
+// indexes, and the offset of the sorts which are not columns.
+func (c *archiveCursor) sql() (where string, args []any, offset int) {
+	if isOffsetSort(c.Sort) {
+		return "", nil, c.Offset
+	}
+
+	op := "<"
+	if c.Order == orderAsc {
+		op = ">"
+	}
+
+	if c.Sort == ArchiveCols.ID {
+		return fmt.Sprintf("archive.id %s ?", op), []any{c.ID}, 0
+	}
+
+	v, _ := c.value()
+	return fmt.Sprintf("(archive.%s, archive.id) %s (?, ?)", c.Sort, op), []any{v, c.ID}, 0
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"koushoku/database"
+	"koushoku/models"
+
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	. "github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// maxFacets limits the number of values returned per taxonomy kind.
+const maxFacets = 50
+
+// Facet is the most common taxonomies of a kind among the matching archives.
+type Facet struct {
+	Kind   string        `json:"kind"`
+	Name   string        `json:"name"`
+	Values []*FacetValue `json:"values"`
+}
+
+type FacetValue struct {
+	Name  string `json:"name"`
+	Slug  string `json:"slug"`
+	Count int64  `json:"count"`
+}
+
+// rawSqlFacet counts the taxonomies of a kind among the matches, the
+// kind being told apart by its index in the union of the kinds.
+const rawSqlFacet = `(
+	SELECT %[1]d, %[2]s, %[3]s.slug, COUNT(*) AS count FROM %[4]s
+	INNER JOIN matches ON matches.id = %[4]s.archive_id
+	INNER JOIN %[3]s ON %[3]s.id = %[4]s.%[5]s
+	WHERE TRUE%[6]s
+	GROUP BY %[3]s.id ORDER BY count DESC, %[3]s.slug LIMIT %[7]d
+)`
+
+// getArchivesFacets returns the top n taxonomies of every kind among the
+// archives matching the conditions, in a single query over their ids.
+func getArchivesFacets(countMods []QueryMod, n int) ([]*Facet, error) {
+	matches, args := queries.BuildQuery(models.Archives(append([]QueryMod{Select("archive.id")}, countMods...)...).Query)
+	matches = strings.TrimSuffix(matches, ";")
+
+	kinds := GetTaxonomyKinds()
+	facets := make([]*Facet, 0, len(kinds.Kinds))
+
+	var parts []string
+	for _, kind := range kinds.Kinds {
+		t, ok := getTaxonomyTable(kind.Kind)
+		if !ok {
+			continue
+		}
+
+		where, whereArgs := t.where()
+		parts = append(parts, bindVarsAfter(fmt.Sprintf(rawSqlFacet,
+			len(facets), t.name(), t.Table, t.JoinTable, t.Column, where, n), len(args)))
+		args = append(args, whereArgs...)
+		facets = append(facets, &Facet{Kind: kind.Kind, Name: kind.Plural, Values: []*FacetValue{}})
+	}
+
+	if len(parts) == 0 {
+		return facets, nil
+	}
+
+	q := fmt.Sprintf("WITH matches AS (%s) %s", matches, strings.Join(parts, " UNION ALL "))
+	rows, err := database.Conn.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i int
+		v := &FacetValue{}
+		if err := rows.Scan(&i, &v.Name, &v.Slug, &v.Count); err != nil {
+			return nil, err
+		}
+		if i >= 0 && i < len(facets) {
+			facets[i].Values = append(facets[i].Values, v)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := facets[:0]
+	for _, facet := range facets {
+		if len(facet.Values) > 0 {
+			result = append(result, facet)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/models"
+
+	"github.com/volatiletech/sqlboiler/v4/queries"
+	. "github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+// GetRandomArchive returns a random published archive among those matching
+// the options. Rather than sorting every match by random() or skipping a
+// random number of them, a random id is drawn between the lowest and the
+// highest id of the matches, and the first match from that id on is picked,
+// which only reads the primary key index. The archives following the larger
+// gaps of ids are therefore slightly more likely to be picked.
+func GetRandomArchive(opts *GetArchivesOptions, archiveOpts GetArchiveOptions) *GetArchiveResult {
+	opts.Sort = ArchiveCols.ID
+	opts.Order = orderAsc
+	opts.Limit = 1
+	opts.Offset = 0
+	opts.Cursor = ""
+	opts.Facets = 0
+	opts.Preloads = nil
+	opts.Validate()
+
+	selectMods, countMods := opts.ToQueries()
+	q, args := queries.BuildQuery(models.Archives(append([]QueryMod{Select("MIN(archive.id), MAX(archive.id), random()")}, countMods...)...).Query)
+
+	var min, max sql.NullInt64
+	var r float64
+	if err := database.Conn.QueryRow(q, args...).Scan(&min, &max, &r); err != nil {
+		log.Println(err)
+		return &GetArchiveResult{Err: errs.Unknown}
+	} else if !min.Valid {
+		return &GetArchiveResult{Err: errs.ArchiveNotFound}
+	}
+
+	id := min.Int64 + int64(r*float64(max.Int64-min.Int64+1))
+	archive, err := models.Archives(append(selectMods, Select("archive.id"), Where("archive.id >= ?", id))...).OneG()
+	if err == sql.ErrNoRows {
+		// The archives were changed since the ids were drawn.
+		archive, err = models.Archives(append(selectMods, Select("archive.id"))...).OneG()
+	}
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &GetArchiveResult{Err: errs.ArchiveNotFound}
+		}
+		log.Println(err)
+		return &GetArchiveResult{Err: errs.Unknown}
+	}
+	return GetArchive(archive.ID, archiveOpts)
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"koushoku/cache"
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/models"
+	"koushoku/modext"
+
+	. "github.com/volatiletech/sqlboiler/v4/queries/qm"
+)
+
+const (
+	relatedCachePrefix = "related"
+
+	// maxRelatedCandidates limits the archives sharing the most with
+	// the archive whose full weight is computed to score them.
+	maxRelatedCandidates = 200
+	maxRelatedArchives   = 24
+)
+
+// getRawSqlRelated returns the query scoring the archives sharing taxonomies
+// with the archive $1 by the weighted Jaccard index of their taxonomies, that
+// is the weight of the shared taxonomies over the weight of all of them. The
+// weight of a taxonomy is the weight of its kind times ln(1 + N / df), N being
+// the number of archives ($2) and df the number of archives having it, so
+// that very common tags count little. The kinds are told apart by their index.
+func getRawSqlRelated() (string, []any) {
+	var tables []taxonomyTable
+	for _, t := range getTaxonomyTables() {
+		if t.Related > 0 {
+			tables = append(tables, t)
+		}
+	}
+
+	var args []any
+	filters := make([]string, len(tables))
+	for i, t := range tables {
+		if t.Custom {
+			args = append(args, t.Kind)
+			filters[i] = fmt.Sprintf(" AND %s IN (SELECT id FROM %s WHERE kind = $%d)", t.Column, t.Table, len(args)+3)
+		}
+	}
+
+	union := func(format string) string {
+		parts := make([]string, len(tables))
+		for i, t := range tables {
+			parts[i] = strings.NewReplacer(
+				"{kind}", strconv.Itoa(i), "{table}", t.JoinTable, "{column}", t.Column,
+				"{weight}", fmt.Sprintf("%g", t.Related), "{filter}", filters[i],
+			).Replace(format)
+		}
+		return strings.Join(parts, "\n\tUNION ALL\n\t")
+	}
+
+	return fmt.Sprintf(`WITH target AS (
+	%s
+), target_weight AS (
+	%s
+), candidate AS (
+	SELECT x.archive_id, SUM(target_weight.w) AS shared FROM (
+		%s
+	) x
+	INNER JOIN target_weight ON target_weight.kind = x.kind AND target_weight.id = x.id
+	INNER JOIN archive ON archive.id = x.archive_id
+	WHERE archive.published_at IS NOT NULL AND archive.expunged IS FALSE
+		AND archive.redirect_id IS NULL AND %s
+	GROUP BY x.archive_id ORDER BY shared DESC LIMIT %d
+), candidate_relation AS (
+	%s
+), candidate_weight AS (
+	SELECT candidate_relation.archive_id, SUM(w.w) AS total FROM candidate_relation
+	INNER JOIN (
+		%s
+	) w ON w.kind = candidate_relation.kind AND w.id = candidate_relation.id
+	GROUP BY candidate_relation.archive_id
+)
+SELECT candidate.archive_id FROM candidate
+INNER JOIN candidate_weight ON candidate_weight.archive_id = candidate.archive_id
+ORDER BY candidate.shared / NULLIF((SELECT SUM(w) FROM target_weight) + candidate_weight.total - candidate.shared, 0) DESC NULLS LAST,
+	candidate.archive_id DESC
+LIMIT $3`,
+		union(`SELECT {kind} AS kind, {column} AS id FROM {table} WHERE archive_id = $1{filter}`),
+		union(`SELECT {kind} AS kind, {column} AS id, {weight} * LN(1 + $2::FLOAT / COUNT(*)) AS w FROM {table}
+	WHERE {column} IN (SELECT id FROM target WHERE kind = {kind}) GROUP BY {column}`),
+		union(`SELECT archive_id, {kind} AS kind, {column} AS id FROM {table}
+		WHERE {column} IN (SELECT id FROM target WHERE kind = {kind}) AND archive_id <> $1`),
+		rawSqlNotModerated,
+		maxRelatedCandidates,
+		union(`SELECT archive_id, {kind} AS kind, {column} AS id FROM {table}
+	WHERE archive_id IN (SELECT archive_id FROM candidate){filter}`),
+		union(`SELECT {kind} AS kind, {column} AS id, {weight} * LN(1 + $2::FLOAT / COUNT(*)) AS w FROM {table}
+		WHERE {column} IN (SELECT id FROM candidate_relation WHERE kind = {kind}) GROUP BY {column}`),
+	), args
+}
+
+// GetRelatedArchives returns the n archives sharing the most taxonomies
+// with the given archive, weighted by how uncommon they are. The results
+// are cached until the archive results are purged.
+func GetRelatedArchives(id int64, n int) ([]*modext.Archive, error) {
+	n = Min(Max(n, 1), maxRelatedArchives)
+
+	cacheKey := fmt.Sprintf("%d:%d", id, n)
+	if c, err := cache.Archives.GetWithPrefix(relatedCachePrefix, cacheKey); err == nil {
+		return c.([]*modext.Archive), nil
+	}
+
+	total, err := GetArchiveCount()
+	if err != nil {
+		return nil, err
+	}
+
+	q, args := getRawSqlRelated()
+	rows, err := database.Conn.Query(q, append([]any{id, Max(int(total), 1), n}, args...)...)
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	defer rows.Close()
+
+	var ids []any
+	order := make(map[int64]int)
+	for rows.Next() {
+		var archiveID int64
+		if err := rows.Scan(&archiveID); err != nil {
+			log.Println(err)
+			return nil, errs.Unknown
+		}
+		order[archiveID] = len(ids)
+		ids = append(ids, archiveID)
+	}
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+
+	result := []*modext.Archive{}
+	if len(ids) > 0 {
+		archives, err := models.Archives(
+			WhereIn("archive.id IN ?", ids...),
+			Load(ArchiveRels.Artists, OrderBy("name ASC")),
+			Load(ArchiveRels.Circles, OrderBy("name ASC")),
+			Load(ArchiveRels.Magazines),
+			Load(ArchiveRels.Tags, OrderBy("name ASC")),
+		).AllG()
+		if err != nil {
+			log.Println(err)
+			return nil, errs.Unknown
+		}
+
+		sort.Slice(archives, func(i, j int) bool {
+			return order[archives[i].ID] < order[archives[j].ID]
+		})
+		for _, archive := range archives {
+			result = append(result, modext.NewArchive(archive).LoadRels(archive))
+		}
+	}
+
+	cache.Archives.SetWithPrefix(relatedCachePrefix, cacheKey, result, 0)
+	return result, nil
+}
@@ -1,22 +1,22 @@
 package services
 
 import (
+	"fmt"
 	"sort"
 	"strings"
 	"sync"
 
+	"koushoku/database"
 	"koushoku/models"
 	"koushoku/modext"
 
 	"github.com/volatiletech/sqlboiler/v4/boil"
 )
 
+// relsCache holds the taxonomies created while populating
+// the archives, keyed by kind:name.
 var relsCache struct {
-	Artists   map[string]*models.Artist
-	Circles   map[string]*models.Circle
-	Magazines map[string]*models.Magazine
-	Parodies  map[string]*models.Parody
-	Tags      map[string]*models.Tag
+	Taxonomies map[string]*modext.Taxonomy
 
 	sync.RWMutex
 	sync.Once
@@ -26,165 +26,115 @@ func PopulateArchiveRels(e boil.Executor, model *models.Archive, archive *modext
 	relsCache.Do(func() {
 		relsCache.Lock()
 		defer relsCache.Unlock()
-
-		relsCache.Artists = make(map[string]*models.Artist)
-		relsCache.Circles = make(map[string]*models.Circle)
-		relsCache.Magazines = make(map[string]*models.Magazine)
-		relsCache.Parodies = make(map[string]*models.Parody)
-		relsCache.Tags = make(map[string]*models.Tag)
+		relsCache.Taxonomies = make(map[string]*modext.Taxonomy)
 	})
 
-	var err error
-	if len(archive.Artists) > 0 {
-		var artists []*models.Artist
-		for _, artist := range archive.Artists {
-			relsCache.RLock()
-			artistModel, ok := relsCache.Artists[artist.Name]
-			relsCache.RUnlock()
-
-			if ok {
-				artists = append(artists, artistModel)
+	for _, t := range getTaxonomyTables() {
+		values := archive.TaxonomiesOf(t.Kind)
+		if t.Custom {
+			if _, ok := archive.Taxonomies[t.Kind]; !ok {
 				continue
 			}
-
-			relsCache.Lock()
-			artist, err = CreateArtist(artist.Name)
-			if err != nil {
-				relsCache.Unlock()
-				return err
-			}
-
-			artistModel = &models.Artist{ID: artist.ID, Slug: artist.Slug, Name: artist.Name}
-			relsCache.Artists[artist.Name] = artistModel
-			relsCache.Unlock()
-
-			artists = append(artists, artistModel)
+		} else if len(values) == 0 {
+			continue
 		}
-		if err := model.SetArtists(e, false, artists...); err != nil {
+
+		if err := populateArchiveTaxonomies(e, model.ID, t, values); err != nil {
 			return err
 		}
 	}
+	return refreshArchiveSearch(e, "a.id = $1", model.ID)
+}
 
-	if len(archive.Circles) > 0 {
-		var circles []*models.Circle
-		for _, circle := range archive.Circles {
-			relsCache.RLock()
-			circleModel, ok := relsCache.Circles[circle.Name]
-			relsCache.RUnlock()
-
-			if ok {
-				circles = append(circles, circleModel)
-				continue
-			}
-
-			relsCache.Lock()
-			circle, err := CreateCircle(circle.Name)
-			if err != nil {
-				relsCache.Unlock()
-				return err
-			}
-
-			circleModel = &models.Circle{ID: circle.ID, Slug: circle.Slug, Name: circle.Name}
-			relsCache.Circles[circle.Name] = circleModel
-			relsCache.Unlock()
-
-			circles = append(circles, circleModel)
-		}
-		if err := model.SetCircles(e, false, circles...); err != nil {
-			return err
-		}
+// populateArchiveTaxonomies replaces the taxonomies of the kind of the
+// archive, creating those that do not exist yet. The built-in kinds are
+// replaced when the archive has some, the user-defined kinds whenever
+// the archive lists the kind, so that they can be cleared.
+func populateArchiveTaxonomies(e boil.Executor, id int64, t taxonomyTable, values []*modext.Taxonomy) error {
+	where, args := t.where()
+	_, err := e.Exec(bindVars(fmt.Sprintf(`DELETE FROM %[1]s USING %[2]s
+		WHERE %[2]s.id = %[1]s.%[3]s AND %[1]s.archive_id = ?%[4]s`, t.JoinTable, t.Table, t.Column, where)),
+		append([]any{id}, args...)...)
+	if err != nil {
+		return err
 	}
 
-	if len(archive.Magazines) > 0 {
-		var magazines []*models.Magazine
-		for _, magazine := range archive.Magazines {
-			relsCache.RLock()
-			magazineModel, ok := relsCache.Magazines[magazine.Name]
-			relsCache.RUnlock()
+	for _, v := range values {
+		name := v.QualifiedName()
+		cacheKey := t.Kind + ":" + name
 
-			if ok {
-				magazines = append(magazines, magazineModel)
-				continue
-			}
+		relsCache.RLock()
+		taxonomy, ok := relsCache.Taxonomies[cacheKey]
+		relsCache.RUnlock()
 
+		if !ok {
 			relsCache.Lock()
-			magazine, err := CreateMagazine(magazine.Name)
+			taxonomy, err = CreateTaxonomy(t.Kind, name)
 			if err != nil {
 				relsCache.Unlock()
 				return err
 			}
-
-			magazineModel = &models.Magazine{ID: magazine.ID, Slug: magazine.Slug, Name: magazine.Name}
-			relsCache.Magazines[magazine.Name] = magazineModel
+			relsCache.Taxonomies[cacheKey] = taxonomy
 			relsCache.Unlock()
-
-			magazines = append(magazines, magazineModel)
 		}
-		if err := model.SetMagazines(e, false, magazines...); err != nil {
+
+		_, err = e.Exec(fmt.Sprintf(`INSERT INTO %s (archive_id, %s) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			t.JoinTable, t.Column), id, taxonomy.ID)
+		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	if len(archive.Parodies) > 0 {
-		var parodies []*models.Parody
-		for _, parody := range archive.Parodies {
-			relsCache.RLock()
-			parodyModel, ok := relsCache.Parodies[parody.Name]
-			relsCache.RUnlock()
+// TaxonomiesRel is the preload of the taxonomies of the user-defined kinds,
+// which are not sqlboiler relationships and are loaded separately.
+const TaxonomiesRel = "Taxonomies"
 
-			if ok {
-				parodies = append(parodies, parodyModel)
-				continue
-			}
+// loadArchiveTaxonomies loads the taxonomies of the user-defined kinds of the archives.
+func loadArchiveTaxonomies(archives ...*modext.Archive) error {
+	if len(archives) == 0 {
+		return nil
+	}
 
-			relsCache.Lock()
-			parody, err := CreateParody(parody.Name)
-			if err != nil {
-				relsCache.Unlock()
-				return err
-			}
+	indexes := make(map[int64]*modext.Archive, len(archives))
+	placeholders := make([]string, len(archives))
+	args := make([]any, len(archives))
+	for i, archive := range archives {
+		indexes[archive.ID] = archive
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = archive.ID
+	}
 
-			parodyModel = &models.Parody{ID: parody.ID, Slug: parody.Slug, Name: parody.Name}
-			relsCache.Parodies[parody.Name] = parodyModel
-			relsCache.Unlock()
+	rows, err := database.Conn.Query(fmt.Sprintf(`SELECT archive_taxonomies.archive_id,
+		taxonomy.id, taxonomy.kind, taxonomy.slug, taxonomy.name
+		FROM archive_taxonomies INNER JOIN taxonomy ON taxonomy.id = archive_taxonomies.taxonomy_id
+		WHERE archive_taxonomies.archive_id IN (%s) ORDER BY taxonomy.name ASC`,
+		strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-			parodies = append(parodies, parodyModel)
-		}
-		if err := model.SetParodies(e, false, parodies...); err != nil {
+	kinds := GetTaxonomyKinds()
+	for rows.Next() {
+		var id int64
+		taxonomy := &modext.Taxonomy{}
+		if err := rows.Scan(&id, &taxonomy.ID, &taxonomy.Kind, &taxonomy.Slug, &taxonomy.Name); err != nil {
 			return err
 		}
-	}
 
-	if len(archive.Tags) > 0 {
-		var tags []*models.Tag
-		for _, tag := range archive.Tags {
-			relsCache.RLock()
-			tagModel, ok := relsCache.Tags[tag.Name]
-			relsCache.RUnlock()
-
-			if ok {
-				tags = append(tags, tagModel)
-				continue
-			}
-
-			relsCache.Lock()
-			tag, err := CreateTag(tag.Name)
-			if err != nil {
-				relsCache.Unlock()
-				return err
-			}
-
-			tagModel = &models.Tag{ID: tag.ID, Slug: tag.Slug, Name: tag.Name}
-			relsCache.Tags[tag.Name] = tagModel
-			relsCache.Unlock()
-
-			tags = append(tags, tagModel)
+		if !kinds.IsCustom(taxonomy.Kind) {
+			continue
 		}
-		if err := model.SetTags(e, false, tags...); err != nil {
-			return err
+
+		archive := indexes[id]
+		if archive.Taxonomies == nil {
+			archive.Taxonomies = make(map[string][]*modext.Taxonomy)
 		}
+		archive.Taxonomies[taxonomy.Kind] = append(archive.Taxonomies[taxonomy.Kind], taxonomy)
 	}
-	return nil
+	return rows.Err()
 }
 
 func validateArchiveRels(rels []string) (result []string) {
@@ -201,6 +151,8 @@ func validateArchiveRels(rels []string) (result []string) {
 			result = append(result, ArchiveRels.Tags)
 		} else if strings.EqualFold(v, ArchiveRels.Submission) {
 			result = append(result, ArchiveRels.Submission)
+		} else if strings.EqualFold(v, TaxonomiesRel) {
+			result = append(result, TaxonomiesRel)
 		}
 	}
 	sort.Strings(result)
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"koushoku/database"
+	"koushoku/errs"
+
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// SortRelevance sorts the archives by how well they match the free-text
+// search, it falls back to the creation date when there is no search.
+const SortRelevance = "relevance"
+
+// rawSqlSearchNames aggregates the names of every taxonomy of the archive a.
+const rawSqlSearchNames = `concat_ws(' ',
+	(SELECT string_agg(artist.name, ' ') FROM archive_artists
+		JOIN artist ON artist.id = archive_artists.artist_id WHERE archive_artists.archive_id = a.id),
+	(SELECT string_agg(circle.name, ' ') FROM archive_circles
+		JOIN circle ON circle.id = archive_circles.circle_id WHERE archive_circles.archive_id = a.id),
+	(SELECT string_agg(magazine.name, ' ') FROM archive_magazines
+		JOIN magazine ON magazine.id = archive_magazines.magazine_id WHERE archive_magazines.archive_id = a.id),
+	(SELECT string_agg(parody.name, ' ') FROM archive_parodies
+		JOIN parody ON parody.id = archive_parodies.parody_id WHERE archive_parodies.archive_id = a.id),
+	(SELECT string_agg(tag.name, ' ') FROM archive_tags
+		JOIN tag ON tag.id = archive_tags.tag_id WHERE archive_tags.archive_id = a.id),
+	(SELECT string_agg(taxonomy.name, ' ') FROM archive_taxonomies
+		JOIN taxonomy ON taxonomy.id = archive_taxonomies.taxonomy_id WHERE archive_taxonomies.archive_id = a.id)
+)`
+
+// rawSqlRefreshSearch rebuilds the search document of the archives matching
+// the condition. The title is weighted above the slug and the taxonomy names.
+const rawSqlRefreshSearch = `INSERT INTO archive_search (archive_id, document, vector)
+SELECT a.id,
+	lower(concat_ws(' ', a.title, replace(a.slug, '-', ' '), d.names)),
+	setweight(to_tsvector('simple', a.title), 'A') ||
+	setweight(to_tsvector('simple', replace(a.slug, '-', ' ')), 'B') ||
+	setweight(to_tsvector('simple', d.names), 'C')
+FROM archive a, LATERAL (SELECT ` + rawSqlSearchNames + ` AS names) d
+WHERE %s
+ON CONFLICT (archive_id) DO UPDATE SET document = EXCLUDED.document, vector = EXCLUDED.vector`
+
+// rawSqlSearch matches the archives either by their full-text vector,
+// or by trigram word similarity to tolerate typos and partial words.
+const rawSqlSearch = `EXISTS (
+	SELECT 1 FROM archive_search
+	WHERE archive_search.archive_id = archive.id
+		AND (archive_search.vector @@ plainto_tsquery('simple', ?) OR ? <% archive_search.document)
+)`
+
+const rawSqlSearchRank = `(
+	SELECT ts_rank(archive_search.vector, plainto_tsquery('simple', ?)) +
+		word_similarity(?, archive_search.document)
+	FROM archive_search WHERE archive_search.archive_id = archive.id
+) DESC NULLS LAST, archive.created_at DESC`
+
+// normalizeSearchText lowercases the free-text search and collapses its spaces.
+func normalizeSearchText(str string) string {
+	return strings.Join(strings.Fields(strings.ToLower(str)), " ")
+}
+
+// refreshArchiveSearch rebuilds the search documents of the archives matching the condition.
+func refreshArchiveSearch(e boil.Executor, where string, args ...any) error {
+	_, err := e.Exec(fmt.Sprintf(rawSqlRefreshSearch, where), args...)
+	return err
+}
+
+// RefreshArchivesSearch rebuilds the search documents of every archive,
+// which is needed once for the archives indexed before they existed.
+func RefreshArchivesSearch() error {
+	if err := refreshArchiveSearch(database.Conn, "TRUE"); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+	return nil
+}
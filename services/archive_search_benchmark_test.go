@@ -0,0 +1,46 @@
+package services
+
+import (
+	"testing"
+)
+
+// BenchmarkSearch times free-text searches through the full-text and
+// trigram indexes and through the former path scan, on seeded archives.
+// The number of archives each one matches is reported along with it.
+func BenchmarkSearch(b *testing.B) {
+	tx := beginBenchmark(b)
+
+	cases := []struct {
+		name string
+		text string
+	}{
+		{"one word", "festival"},
+		{"two words", "summer festival"},
+		{"partial word", "festiv"},
+		{"typo", "sumer festval"},
+		{"no match", "nonexistent"},
+	}
+
+	for _, c := range cases {
+		text := normalizeSearchText(c.text)
+		run := func(name string, opts *GetArchivesOptions) {
+			opts.Limit = 25
+			opts.Validate()
+			selectMods, countMods := opts.ToQueries()
+
+			b.Run(c.name+"/"+name, func(b *testing.B) {
+				var total int64
+				for i := 0; i < b.N; i++ {
+					var err error
+					if total, err = runFilters(tx, selectMods, countMods); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(total), "matches")
+			})
+		}
+
+		run("scan", &GetArchivesOptions{Path: text})
+		run("search", &GetArchivesOptions{Text: text, Sort: SortRelevance})
+	}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"koushoku/cache"
+	"koushoku/database"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SortPopular sorts the archives by their views and downloads of all time.
+	SortPopular = "popular"
+	// SortTrending sorts the archives by their views and downloads of the
+	// last trendingDays days, the older ones counting less.
+	SortTrending = "trending"
+
+	// downloadWeight is how many views a download is worth.
+	downloadWeight = 5
+
+	trendingDays     = 7
+	trendingHalfLife = 2
+
+	// dailyStatsDays is how long the daily stats are kept.
+	dailyStatsDays = 30
+)
+
+// The views and downloads are counted in a Redis hash whose fields are
+// day:id:v or day:id:d, which is periodically renamed and flushed.
+const (
+	archiveStatsKey         = "archive-stats"
+	archiveStatsFlushingKey = "archive-stats:flushing"
+	archiveStatsLockKey     = "archive-stats:lock"
+)
+
+var rawSqlPopularity = fmt.Sprintf(`COALESCE((
+	SELECT archive_stats.views + %d * archive_stats.downloads FROM archive_stats
+	WHERE archive_stats.archive_id = archive.id
+), 0)`, downloadWeight)
+
+// rawSqlTrending halves the views and downloads of a day every trendingHalfLife days.
+var rawSqlTrending = fmt.Sprintf(`COALESCE((
+	SELECT SUM((archive_daily_stats.views + %d * archive_daily_stats.downloads)
+		* POWER(0.5, (CURRENT_DATE - archive_daily_stats.day) / %d.0))
+	FROM archive_daily_stats
+	WHERE archive_daily_stats.archive_id = archive.id
+		AND archive_daily_stats.day > CURRENT_DATE - %d
+), 0)`, downloadWeight, trendingHalfLife, trendingDays)
+
+var rawSqlTrendingMatch = fmt.Sprintf(`EXISTS (
+	SELECT 1 FROM archive_daily_stats
+	WHERE archive_daily_stats.archive_id = archive.id
+		AND archive_daily_stats.day > CURRENT_DATE - %d
+)`, trendingDays)
+
+const rawSqlFlushArchiveStats = `INSERT INTO archive_stats (archive_id, views, downloads)
+SELECT id, $2::BIGINT, $3::BIGINT FROM archive WHERE id = $1
+ON CONFLICT (archive_id) DO UPDATE SET
+	views = archive_stats.views + EXCLUDED.views,
+	downloads = archive_stats.downloads + EXCLUDED.downloads`
+
+const rawSqlFlushArchiveDailyStats = `INSERT INTO archive_daily_stats (archive_id, day, views, downloads)
+SELECT id, $2::DATE, $3::BIGINT, $4::BIGINT FROM archive WHERE id = $1
+ON CONFLICT (archive_id, day) DO UPDATE SET
+	views = archive_daily_stats.views + EXCLUDED.views,
+	downloads = archive_daily_stats.downloads + EXCLUDED.downloads`
+
+// RecordArchiveView counts a view of the archive, to be flushed later.
+func RecordArchiveView(id int64) {
+	recordArchiveStat(id, "v")
+}
+
+// RecordArchiveDownload counts a download of the archive, to be flushed later.
+func RecordArchiveDownload(id int64) {
+	recordArchiveStat(id, "d")
+}
+
+func recordArchiveStat(id int64, kind string) {
+	field := fmt.Sprintf("%s:%d:%s", time.Now().UTC().Format("2006-01-02"), id, kind)
+	if err := cache.Redis.HIncrBy(context.Background(), archiveStatsKey, field, 1).Err(); err != nil {
+		log.Println(err)
+	}
+}
+
+type archiveDayStats struct {
+	Views     int64
+	Downloads int64
+}
+
+type archiveDay struct {
+	ID  int64
+	Day string
+}
+
+// FlushArchiveStats adds the views and downloads counted in Redis since
+// the last flush to the database. The counters are renamed first, so the
+// ones counted in the meantime are left for the next flush, and those of a
+// flush that failed are kept to be flushed again.
+func FlushArchiveStats() error {
+	ctx := context.Background()
+
+	ok, err := cache.Redis.SetNX(ctx, archiveStatsLockKey, 1, 5*time.Minute).Result()
+	if err != nil || !ok {
+		return err
+	}
+	defer cache.Redis.Del(ctx, archiveStatsLockKey)
+
+	if err := cache.Redis.RenameNX(ctx, archiveStatsKey, archiveStatsFlushingKey).Err(); err != nil &&
+		!strings.Contains(err.Error(), "no such key") {
+		return err
+	}
+
+	fields, err := cache.Redis.HGetAll(ctx, archiveStatsFlushingKey).Result()
+	if err != nil {
+		return err
+	} else if len(fields) == 0 {
+		return nil
+	}
+
+	stats := make(map[archiveDay]*archiveDayStats)
+	for field, value := range fields {
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 {
+			continue
+		}
+
+		id, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		key := archiveDay{ID: id, Day: parts[0]}
+		s, ok := stats[key]
+		if !ok {
+			s = &archiveDayStats{}
+			stats[key] = s
+		}
+
+		if parts[2] == "d" {
+			s.Downloads += n
+		} else {
+			s.Views += n
+		}
+	}
+
+	tx, err := database.Conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		for key, s := range stats {
+			if _, err := tx.Exec(rawSqlFlushArchiveStats, key.ID, s.Views, s.Downloads); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(rawSqlFlushArchiveDailyStats, key.ID, key.Day, s.Views, s.Downloads); err != nil {
+				return err
+			}
+		}
+
+		_, err := tx.Exec(`DELETE FROM archive_daily_stats WHERE day < CURRENT_DATE - $1::INT`, dailyStatsDays)
+		return err
+	}()
+
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return cache.Redis.Del(ctx, archiveStatsFlushingKey).Err()
+}
+
+// StartArchiveStatsFlusher flushes the archive stats at the given interval.
+func StartArchiveStatsFlusher(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			if err := FlushArchiveStats(); err != nil {
+				log.Println("Failed to flush archive stats", err)
+			}
+		}
+	}()
+}
@@ -6,75 +6,241 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 
-	. "koushoku/config"
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/modext"
 )
 
-var Blacklists struct {
-	ArchiveMatches   map[string]bool
-	ArchiveWildcards []string
-	ArtistMatches    map[string]bool
-	CircleMatches    map[string]bool
-	MagazineMatches  map[string]bool
-	TagMatches       map[string]bool
+var BlacklistKinds = []string{"title", "title*", "path", "artist", "circle", "magazine", "parody", "tag", "rule"}
 
-	once sync.Once
+type BlacklistSet struct {
+	Rules []*blacklistRule
+}
+
+func newBlacklistSet() *BlacklistSet {
+	return &BlacklistSet{}
+}
+
+var blacklists struct {
+	value atomic.Value
+	once  sync.Once
+}
+
+// GetBlacklists returns the currently loaded blacklists.
+// The returned set must be treated as read-only, it is
+// replaced as a whole whenever the blacklists are reloaded.
+func GetBlacklists() *BlacklistSet {
+	if v, ok := blacklists.value.Load().(*BlacklistSet); ok {
+		return v
+	}
+	return newBlacklistSet()
 }
 
 func InitBlacklists() {
-	Blacklists.once.Do(func() {
-		Blacklists.ArchiveMatches = make(map[string]bool)
-		Blacklists.ArtistMatches = make(map[string]bool)
-		Blacklists.CircleMatches = make(map[string]bool)
-		Blacklists.MagazineMatches = make(map[string]bool)
-		Blacklists.TagMatches = make(map[string]bool)
-
-		stat, err := os.Stat(Config.Paths.Blacklist)
-		if os.IsNotExist(err) || stat.IsDir() {
-			return
+	blacklists.once.Do(func() {
+		if err := ReloadBlacklists(); err != nil {
+			log.Println(err)
+			blacklists.value.Store(newBlacklistSet())
 		}
+	})
+}
+
+// ReloadBlacklists reads the blacklist rules from the database
+// and atomically swaps them with the currently loaded blacklists.
+func ReloadBlacklists() error {
+	set, err := loadBlacklists()
+	if err != nil {
+		return err
+	}
+	blacklists.value.Store(set)
+	return nil
+}
+
+func loadBlacklists() (*BlacklistSet, error) {
+	rows, err := database.Conn.Query(`SELECT id, kind, value FROM blacklist_rule
+		WHERE deleted_at IS NULL ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		f, err := os.Open(Config.Paths.Blacklist)
+	set := newBlacklistSet()
+	for rows.Next() {
+		var id int64
+		var kind, value string
+		if err := rows.Scan(&id, &kind, &value); err != nil {
+			return nil, err
+		}
+
+		rule, err := compileBlacklistRule(id, kind, value)
 		if err != nil {
-			log.Println(err)
-			return
+			log.Printf("Skipping blacklist rule %d: %s\n", id, err)
+			continue
 		}
-		defer f.Close()
+		set.Rules = append(set.Rules, rule)
+	}
 
-		scanner := bufio.NewScanner(f)
-		scanner.Split(bufio.ScanLines)
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
 
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if len(line) == 0 {
-				continue
-			}
+func isBlacklistKindValid(kind string) bool {
+	for _, v := range BlacklistKinds {
+		if v == kind {
+			return true
+		}
+	}
+	return false
+}
 
-			strs := strings.Split(strings.ToLower(line), ":")
-			if len(strs) < 2 {
-				continue
-			}
+const blacklistRuleCols = `id, EXTRACT(EPOCH FROM created_at)::BIGINT,
+	COALESCE(EXTRACT(EPOCH FROM deleted_at)::BIGINT, 0), kind, value`
 
-			v := Slugify(strings.Join(strs[1:], ":"))
-
-			switch strings.TrimSpace(strs[0]) {
-			case "title":
-				Blacklists.ArchiveMatches[v] = true
-			case "title*":
-				Blacklists.ArchiveWildcards = append(Blacklists.ArchiveWildcards, v)
-			case "artist":
-				Blacklists.ArtistMatches[v] = true
-			case "circle":
-				Blacklists.CircleMatches[v] = true
-			case "magazine":
-				Blacklists.MagazineMatches[v] = true
-			case "tag":
-				Blacklists.TagMatches[v] = true
-			}
+func scanBlacklistRule(row interface{ Scan(...any) error }) (*modext.BlacklistRule, error) {
+	rule := &modext.BlacklistRule{}
+	err := row.Scan(&rule.ID, &rule.CreatedAt, &rule.DeletedAt, &rule.Kind, &rule.Value)
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// CreateBlacklistRule creates a blacklist rule, or returns
+// the existing one if the same rule is already active.
+func CreateBlacklistRule(kind, value string) (*modext.BlacklistRule, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if !isBlacklistKindValid(kind) {
+		return nil, errs.BlacklistKindInvalid
+	}
+
+	value = normalizeBlacklistValue(kind, value)
+	if len(value) == 0 {
+		return nil, errs.BlacklistValueRequired
+	} else if len(value) > 1024 {
+		return nil, errs.BlacklistValueTooLong
+	}
+
+	if _, err := compileBlacklistRule(0, kind, value); err != nil {
+		return nil, err
+	}
+
+	rule, err := scanBlacklistRule(database.Conn.QueryRow(`INSERT INTO blacklist_rule (kind, value)
+		VALUES ($1, $2) ON CONFLICT (kind, value) WHERE deleted_at IS NULL DO UPDATE SET kind = EXCLUDED.kind
+		RETURNING `+blacklistRuleCols, kind, value))
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return rule, nil
+}
+
+// ListBlacklistRules returns the active blacklist rules, optionally filtered by kind.
+func ListBlacklistRules(kind string) ([]*modext.BlacklistRule, error) {
+	q := `SELECT ` + blacklistRuleCols + ` FROM blacklist_rule WHERE deleted_at IS NULL`
+	var args []any
+
+	if kind = strings.ToLower(strings.TrimSpace(kind)); len(kind) > 0 {
+		if !isBlacklistKindValid(kind) {
+			return nil, errs.BlacklistKindInvalid
 		}
+		q += ` AND kind = $1`
+		args = append(args, kind)
+	}
+
+	rows, err := database.Conn.Query(q+` ORDER BY kind ASC, value ASC`, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	defer rows.Close()
 
-		if err := scanner.Err(); err != nil {
+	result := []*modext.BlacklistRule{}
+	for rows.Next() {
+		rule, err := scanBlacklistRule(rows)
+		if err != nil {
 			log.Println(err)
+			return nil, errs.Unknown
 		}
-	})
+		result = append(result, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return result, nil
+}
+
+// DeleteBlacklistRule soft deletes a blacklist rule by id.
+func DeleteBlacklistRule(id int64) error {
+	res, err := database.Conn.Exec(`UPDATE blacklist_rule SET deleted_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	} else if n == 0 {
+		return errs.BlacklistNotFound
+	}
+	return nil
+}
+
+// ParseBlacklistRule parses a blacklist rule in the format of kind:value,
+// where the value of the kind "rule" is a rule expression.
+func ParseBlacklistRule(line string) (kind, value string, ok bool) {
+	strs := strings.Split(strings.TrimSpace(line), ":")
+	if len(strs) < 2 {
+		return
+	}
+
+	kind = strings.ToLower(strings.TrimSpace(strs[0]))
+	value = strings.TrimSpace(strings.Join(strs[1:], ":"))
+	ok = len(kind) > 0 && len(value) > 0
+	return
+}
+
+// ImportBlacklistRules imports the blacklist rules from a text file with
+// one kind:value per line, and returns how many were imported.
+func ImportBlacklistRules(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Split(bufio.ScanLines)
+
+	var n int
+	for scanner.Scan() {
+		kind, value, ok := ParseBlacklistRule(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		if _, err := CreateBlacklistRule(kind, value); err != nil {
+			if err == errs.Unknown {
+				return n, err
+			}
+			log.Printf("Skipping blacklist rule %q: %s\n", scanner.Text(), err)
+			continue
+		}
+		n++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	return n, nil
 }
@@ -0,0 +1,350 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"koushoku/errs"
+	"koushoku/modext"
+)
+
+// Blacklist rules of the kind "rule" are written as one or more
+// conditions joined by AND, optionally followed by UNLESS clauses:
+//
+//	tag:x AND artist:y
+//	title:/^foo.*bar$/ UNLESS circle:z
+//	tag:x UNLESS tag:y UNLESS tag:z AND artist:w
+//
+// A condition is kind:value, where kind is one of title, path, artist,
+// circle, magazine, parody or tag. The value is matched against the slug
+// (or the lowercased path), can contain * wildcards, or can be a
+// case-insensitive regular expression enclosed in slashes, which is
+// matched against the title, path or taxonomy name as it is. AND and
+// UNLESS are part of a regular expression up to its closing slash, the
+// first one followed by a space or the end of the rule.
+// The rule matches when all of its conditions match, and none of
+// the UNLESS clauses match in their entirety.
+
+var blacklistCondKinds = []string{"title", "path", "artist", "circle", "magazine", "parody", "tag"}
+
+var blacklistOpRgx = regexp.MustCompile(`(?i)\s+(and|unless)(\s+|$)`)
+
+// blacklistRulePart is a condition of a rule, along with the
+// operator before it and where it starts in the rule.
+type blacklistRulePart struct {
+	Op    string
+	Cond  string
+	Start int
+}
+
+type blacklistCond struct {
+	Kind  string
+	Value string
+	Rgx   *regexp.Regexp
+	IsRaw bool
+}
+
+type blacklistRule struct {
+	ID     int64
+	Rule   string
+	Conds  []*blacklistCond
+	Unless [][]*blacklistCond
+}
+
+type BlacklistMatch struct {
+	ID      int64
+	Rule    string
+	Reasons []string
+}
+
+func isBlacklistRegex(value string) bool {
+	return len(value) > 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/")
+}
+
+// normalizeBlacklistValue slugifies the value of a simple blacklist
+// rule while keeping its wildcards and regular expressions intact.
+func normalizeBlacklistValue(kind, value string) string {
+	value = strings.TrimSpace(value)
+	if kind == "rule" || isBlacklistRegex(value) {
+		return value
+	} else if kind == "path" {
+		return strings.ToLower(value)
+	}
+
+	strs := strings.Split(value, "*")
+	for i, str := range strs {
+		if kind == "tag" {
+			parts := strings.Split(str, ":")
+			for j, part := range parts {
+				parts[j] = Slugify(part)
+			}
+			strs[i] = strings.Join(parts, ":")
+		} else {
+			strs[i] = Slugify(str)
+		}
+	}
+	return strings.Join(strs, "*")
+}
+
+func compileBlacklistCond(kind, value string) (*blacklistCond, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	value = strings.TrimSpace(value)
+
+	if kind == "title*" {
+		kind, value = "title", "*"+value+"*"
+	}
+
+	isValid := false
+	for _, v := range blacklistCondKinds {
+		if v == kind {
+			isValid = true
+			break
+		}
+	}
+
+	if !isValid {
+		return nil, fmt.Errorf("%w: unknown kind %q", errs.BlacklistRuleInvalid, kind)
+	} else if len(value) == 0 {
+		return nil, fmt.Errorf("%w: %s has no value", errs.BlacklistRuleInvalid, kind)
+	}
+
+	cond := &blacklistCond{Kind: kind}
+	if isBlacklistRegex(value) {
+		rgx, err := regexp.Compile("(?i)" + value[1:len(value)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errs.BlacklistRuleInvalid, err)
+		}
+		cond.Value, cond.Rgx, cond.IsRaw = value, rgx, true
+		return cond, nil
+	}
+
+	cond.Value = normalizeBlacklistValue(kind, value)
+	if len(strings.Trim(cond.Value, "*")) == 0 {
+		return nil, fmt.Errorf("%w: %s has no value", errs.BlacklistRuleInvalid, kind)
+	}
+
+	if strings.Contains(cond.Value, "*") {
+		strs := strings.Split(cond.Value, "*")
+		for i, str := range strs {
+			strs[i] = regexp.QuoteMeta(str)
+		}
+		cond.Rgx = regexp.MustCompile("^" + strings.Join(strs, ".*") + "$")
+	}
+	return cond, nil
+}
+
+// splitBlacklistRule splits the rule into its conditions, looking for the
+// AND and UNLESS operators past the regular expression of each condition.
+func splitBlacklistRule(value string) []blacklistRulePart {
+	var parts []blacklistRulePart
+	var op string
+
+	start := 0
+	for {
+		from := start
+		if i := strings.Index(value[start:], ":"); i >= 0 {
+			from = skipBlacklistRegex(value, start+i+1)
+		}
+
+		loc := blacklistOpRgx.FindStringSubmatchIndex(value[from:])
+		if loc == nil {
+			return append(parts, blacklistRulePart{Op: op, Cond: value[start:], Start: start})
+		}
+
+		parts = append(parts, blacklistRulePart{Op: op, Cond: value[start : from+loc[0]], Start: start})
+		op = strings.ToLower(value[from+loc[2] : from+loc[3]])
+		start = from + loc[1]
+	}
+}
+
+// skipBlacklistRegex returns the index past the regular expression
+// that the value starting at i is, or i if it is not one.
+func skipBlacklistRegex(value string, i int) int {
+	j := i
+	for j < len(value) && unicode.IsSpace(rune(value[j])) {
+		j++
+	}
+	if j == len(value) || value[j] != '/' {
+		return i
+	}
+
+	for k := j + 1; k < len(value); k++ {
+		if value[k] == '\\' {
+			k++
+		} else if value[k] == '/' && (k+1 == len(value) || unicode.IsSpace(rune(value[k+1]))) {
+			return k + 1
+		}
+	}
+	return i
+}
+
+// compileBlacklistRule compiles a stored blacklist rule. Rules of the
+// kind "rule" are parsed as expressions, every other kind is a single condition.
+func compileBlacklistRule(id int64, kind, value string) (*blacklistRule, error) {
+	if kind != "rule" {
+		cond, err := compileBlacklistCond(kind, value)
+		if err != nil {
+			return nil, err
+		}
+		return &blacklistRule{
+			ID:    id,
+			Rule:  fmt.Sprintf("%s:%s", kind, value),
+			Conds: []*blacklistCond{cond},
+		}, nil
+	}
+
+	rule := &blacklistRule{ID: id, Rule: value}
+	conds := &rule.Conds
+
+	for _, part := range splitBlacklistRule(value) {
+		if part.Op == "unless" {
+			rule.Unless = append(rule.Unless, nil)
+			conds = &rule.Unless[len(rule.Unless)-1]
+		}
+
+		strs := strings.SplitN(part.Cond, ":", 2)
+		if len(strs) < 2 {
+			return nil, fmt.Errorf("%w: expected kind:value, got %q",
+				errs.BlacklistRuleInvalid, strings.TrimSpace(part.Cond))
+		}
+
+		cond, err := compileBlacklistCond(strs[0], strs[1])
+		if err != nil {
+			return nil, err
+		}
+		*conds = append(*conds, cond)
+	}
+
+	if len(rule.Conds) == 0 {
+		return nil, errs.BlacklistValueRequired
+	}
+	return rule, nil
+}
+
+// renameBlacklistRule returns the value of the rule with the conditions
+// on the taxonomy of the kind whose slug is from made to match into,
+// wildcards and regular expressions being left as they are.
+func renameBlacklistRule(ruleKind, value, kind, from, into string) (string, bool) {
+	if ruleKind != "rule" {
+		if ruleKind == kind && normalizeBlacklistValue(kind, value) == from {
+			return into, true
+		}
+		return value, false
+	}
+
+	renamed := false
+	parts := splitBlacklistRule(value)
+	for i := len(parts) - 1; i >= 0; i-- {
+		strs := strings.SplitN(parts[i].Cond, ":", 2)
+		if len(strs) < 2 || strings.ToLower(strings.TrimSpace(strs[0])) != kind ||
+			normalizeBlacklistValue(kind, strs[1]) != from {
+			continue
+		}
+
+		end := parts[i].Start + len(parts[i].Cond)
+		value = value[:parts[i].Start] + kind + ":" + into + value[end:]
+		renamed = true
+	}
+	return value, renamed
+}
+
+func (cond *blacklistCond) matchValue(value, slug string) bool {
+	if cond.IsRaw {
+		return cond.Rgx.MatchString(value)
+	} else if cond.Rgx != nil {
+		return cond.Rgx.MatchString(slug)
+	}
+	return cond.Value == slug
+}
+
+// match returns a description of what the condition matched in the archive.
+func (cond *blacklistCond) match(archive *modext.Archive) (string, bool) {
+	var names, slugs []string
+	switch cond.Kind {
+	case "title":
+		names, slugs = []string{archive.Title}, []string{Slugify(archive.Title)}
+	case "path":
+		names, slugs = []string{archive.Path}, []string{strings.ToLower(archive.Path)}
+	case "artist":
+		for _, v := range archive.Artists {
+			names, slugs = append(names, v.Name), append(slugs, v.Slug)
+		}
+	case "circle":
+		for _, v := range archive.Circles {
+			names, slugs = append(names, v.Name), append(slugs, v.Slug)
+		}
+	case "magazine":
+		for _, v := range archive.Magazines {
+			names, slugs = append(names, v.Name), append(slugs, v.Slug)
+		}
+	case "parody":
+		for _, v := range archive.Parodies {
+			names, slugs = append(names, v.Name), append(slugs, v.Slug)
+		}
+	case "tag":
+		for _, v := range archive.Tags {
+			names, slugs = append(names, v.QualifiedName()), append(slugs, v.Slug)
+		}
+	}
+
+	for i := range names {
+		slug := slugs[i]
+		if len(slug) == 0 && cond.Kind == "tag" {
+			slug = TagSlug(names[i])
+		} else if len(slug) == 0 && cond.Kind != "path" {
+			slug = Slugify(names[i])
+		}
+		if cond.matchValue(names[i], slug) {
+			return fmt.Sprintf("%s %q matched %s:%s", cond.Kind, names[i], cond.Kind, cond.Value), true
+		}
+	}
+	return "", false
+}
+
+func matchBlacklistConds(conds []*blacklistCond, archive *modext.Archive) ([]string, bool) {
+	reasons := make([]string, 0, len(conds))
+	for _, cond := range conds {
+		reason, ok := cond.match(archive)
+		if !ok {
+			return nil, false
+		}
+		reasons = append(reasons, reason)
+	}
+	return reasons, true
+}
+
+func (rule *blacklistRule) match(archive *modext.Archive) (*BlacklistMatch, bool) {
+	reasons, ok := matchBlacklistConds(rule.Conds, archive)
+	if !ok {
+		return nil, false
+	}
+
+	for _, conds := range rule.Unless {
+		if _, ok := matchBlacklistConds(conds, archive); ok {
+			return nil, false
+		}
+	}
+	return &BlacklistMatch{ID: rule.ID, Rule: rule.Rule, Reasons: reasons}, true
+}
+
+// Match returns the first blacklist rule that matches the archive, or nil.
+func (set *BlacklistSet) Match(archive *modext.Archive) *BlacklistMatch {
+	for _, rule := range set.Rules {
+		if match, ok := rule.match(archive); ok {
+			return match
+		}
+	}
+	return nil
+}
+
+// MatchAll returns every blacklist rule that matches the archive.
+func (set *BlacklistSet) MatchAll(archive *modext.Archive) (matches []*BlacklistMatch) {
+	for _, rule := range set.Rules {
+		if match, ok := rule.match(archive); ok {
+			matches = append(matches, match)
+		}
+	}
+	return
+}
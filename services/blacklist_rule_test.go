@@ -0,0 +1,174 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"koushoku/errs"
+	"koushoku/modext"
+)
+
+// formatBlacklistConds renders the conditions as kind:value, to compare the compiled rules.
+func formatBlacklistConds(conds []*blacklistCond) []string {
+	strs := make([]string, len(conds))
+	for i, cond := range conds {
+		strs[i] = cond.Kind + ":" + cond.Value
+	}
+	return strs
+}
+
+func TestSplitBlacklistRule(t *testing.T) {
+	tests := []struct {
+		rule  string
+		parts []blacklistRulePart
+	}{
+		{"tag:x", []blacklistRulePart{{"", "tag:x", 0}}},
+		{"tag:x AND artist:y", []blacklistRulePart{{"", "tag:x", 0}, {"and", "artist:y", 10}}},
+		{"tag:x unless tag:y", []blacklistRulePart{{"", "tag:x", 0}, {"unless", "tag:y", 13}}},
+		{"title:/foo and bar/", []blacklistRulePart{{"", "title:/foo and bar/", 0}}},
+		{"title:/a unless b/ AND tag:x", []blacklistRulePart{{"", "title:/a unless b/", 0}, {"and", "tag:x", 23}}},
+		{`title:/a\/ and b/ and tag:x`, []blacklistRulePart{{"", `title:/a\/ and b/`, 0}, {"and", "tag:x", 22}}},
+		{"title:/a/b and tag:x", []blacklistRulePart{{"", "title:/a/b", 0}, {"and", "tag:x", 15}}},
+		{"tag:/x and y", []blacklistRulePart{{"", "tag:/x", 0}, {"and", "y", 11}}},
+		{"tag:sand and tag:band", []blacklistRulePart{{"", "tag:sand", 0}, {"and", "tag:band", 13}}},
+	}
+
+	for _, test := range tests {
+		if parts := splitBlacklistRule(test.rule); !reflect.DeepEqual(parts, test.parts) {
+			t.Errorf("%q: got %v, want %v", test.rule, parts, test.parts)
+		}
+	}
+}
+
+func TestCompileBlacklistRule(t *testing.T) {
+	tests := []struct {
+		kind   string
+		value  string
+		conds  []string
+		unless [][]string
+	}{
+		{"tag", "Foo Bar", []string{"tag:foo-bar"}, nil},
+		{"tag", "Female:Foo", []string{"tag:female:foo"}, nil},
+		{"artist", "Foo*", []string{"artist:foo*"}, nil},
+		{"path", "/Foo/Bar.zip", []string{"path:/foo/bar.zip"}, nil},
+		{"rule", "tag:x AND artist:Y Z", []string{"tag:x", "artist:y-z"}, nil},
+		{"rule", "tag:x unless tag:y", []string{"tag:x"}, [][]string{{"tag:y"}}},
+		{"rule", "tag:x UNLESS tag:y UNLESS tag:z AND artist:w",
+			[]string{"tag:x"}, [][]string{{"tag:y"}, {"tag:z", "artist:w"}}},
+		{"rule", "title:/foo and bar/", []string{"title:/foo and bar/"}, nil},
+		{"rule", "title:/^foo.*bar$/ UNLESS circle:z", []string{"title:/^foo.*bar$/"}, [][]string{{"circle:z"}}},
+		{"rule", "title*:foo and tag:*bar*", []string{"title:*foo*", "tag:*bar*"}, nil},
+	}
+
+	for _, test := range tests {
+		rule, err := compileBlacklistRule(1, test.kind, test.value)
+		if err != nil {
+			t.Errorf("%s:%q: unexpected error %v", test.kind, test.value, err)
+			continue
+		}
+
+		if conds := formatBlacklistConds(rule.Conds); !reflect.DeepEqual(conds, test.conds) {
+			t.Errorf("%s:%q: got conditions %v, want %v", test.kind, test.value, conds, test.conds)
+		}
+
+		var unless [][]string
+		for _, conds := range rule.Unless {
+			unless = append(unless, formatBlacklistConds(conds))
+		}
+		if !reflect.DeepEqual(unless, test.unless) {
+			t.Errorf("%s:%q: got unless clauses %v, want %v", test.kind, test.value, unless, test.unless)
+		}
+	}
+}
+
+func TestCompileBlacklistRuleErrors(t *testing.T) {
+	tests := []struct {
+		kind  string
+		value string
+	}{
+		{"rule", "tag:x AND"},
+		{"rule", "tag:x AND foo"},
+		{"rule", "unknown:x"},
+		{"rule", "tag:x UNLESS tag:"},
+		{"rule", "title:/(/"},
+		{"tag", "*"},
+	}
+
+	for _, test := range tests {
+		if _, err := compileBlacklistRule(1, test.kind, test.value); !errors.Is(err, errs.BlacklistRuleInvalid) {
+			t.Errorf("%s:%q: got error %v, want %v", test.kind, test.value, err, errs.BlacklistRuleInvalid)
+		}
+	}
+}
+
+func TestMatchBlacklistRule(t *testing.T) {
+	archive := &modext.Archive{
+		Title: "Foo and Bar",
+		Path:  "/Library/Foo.zip",
+		Artists: []*modext.Taxonomy{
+			{Slug: "some-artist", Name: "Some Artist"},
+		},
+		Tags: []*modext.Taxonomy{
+			{Slug: "female:foo", Name: "Foo", Namespace: "female"},
+			{Slug: "big-bar", Name: "Big Bar"},
+		},
+	}
+
+	tests := []struct {
+		kind  string
+		value string
+		match bool
+	}{
+		{"tag", "female:foo", true},
+		{"tag", "foo", false},
+		{"tag", "big*", true},
+		{"tag", "*bar", true},
+		{"tag", "bar*", false},
+		{"path", "/library/*", true},
+		{"rule", "title:/foo and bar/", true},
+		{"rule", "title:/foo and baz/", false},
+		{"rule", "title:/^FOO/ AND artist:some-artist", true},
+		{"rule", "tag:big-bar AND artist:other", false},
+		{"rule", "tag:big-bar UNLESS artist:some-artist", false},
+		{"rule", "tag:big-bar UNLESS artist:some-artist AND tag:other", true},
+		{"rule", "tag:big-bar UNLESS tag:other UNLESS artist:some*", false},
+		{"rule", "title*:and and tag:/^female:/", true},
+	}
+
+	for _, test := range tests {
+		rule, err := compileBlacklistRule(1, test.kind, test.value)
+		if err != nil {
+			t.Errorf("%s:%q: unexpected error %v", test.kind, test.value, err)
+			continue
+		}
+
+		if _, ok := rule.match(archive); ok != test.match {
+			t.Errorf("%s:%q: got match %v, want %v", test.kind, test.value, ok, test.match)
+		}
+	}
+}
+
+func TestRenameBlacklistRule(t *testing.T) {
+	tests := []struct {
+		kind    string
+		value   string
+		renamed string
+		ok      bool
+	}{
+		{"tag", "foo", "bar", true},
+		{"tag", "foo*", "foo*", false},
+		{"artist", "foo", "foo", false},
+		{"rule", "tag:foo", "tag:bar", true},
+		{"rule", "tag:Foo AND artist:foo", "tag:bar AND artist:foo", true},
+		{"rule", "artist:x UNLESS tag:foo and TAG: foo", "artist:x UNLESS tag:bar and tag:bar", true},
+		{"rule", "title:/tag:foo and x/ and tag:food", "title:/tag:foo and x/ and tag:food", false},
+	}
+
+	for _, test := range tests {
+		renamed, ok := renameBlacklistRule(test.kind, test.value, "tag", "foo", "bar")
+		if renamed != test.renamed || ok != test.ok {
+			t.Errorf("%s:%q: got %q, %v, want %q, %v", test.kind, test.value, renamed, ok, test.renamed, test.ok)
+		}
+	}
+}
@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"log"
 	"os"
-	"path/filepath"
 	"sync"
+	"sync/atomic"
 
 	. "koushoku/config"
 )
@@ -17,31 +17,108 @@ type Metadata struct {
 	Magazines []string
 	Parodies  []string
 	Tags      []string
-}
 
-var Metadatas struct {
-	Map  map[string]*Metadata
-	once sync.Once
+	// Taxonomies are the taxonomies of the user-defined kinds, by kind.
+	Taxonomies map[string][]string
 }
 
-func InitMetadatas() {
-	Metadatas.once.Do(func() {
-		Metadatas.Map = make(map[string]*Metadata)
-		path := filepath.Join(Config.Paths.Metadata)
+// Clone returns a deep copy of the metadata,
+// or an empty metadata if it is nil.
+func (metadata *Metadata) Clone() *Metadata {
+	if metadata == nil {
+		return &Metadata{}
+	}
 
-		stat, err := os.Stat(path)
-		if os.IsNotExist(err) || stat.IsDir() {
-			return
-		}
+	clone := &Metadata{
+		Title:     metadata.Title,
+		Artists:   append([]string(nil), metadata.Artists...),
+		Circles:   append([]string(nil), metadata.Circles...),
+		Magazines: append([]string(nil), metadata.Magazines...),
+		Parodies:  append([]string(nil), metadata.Parodies...),
+		Tags:      append([]string(nil), metadata.Tags...),
+	}
 
-		buf, err := os.ReadFile(path)
-		if err != nil {
-			log.Println(err)
-			return
+	if metadata.Taxonomies != nil {
+		clone.Taxonomies = make(map[string][]string, len(metadata.Taxonomies))
+		for kind, values := range metadata.Taxonomies {
+			clone.Taxonomies[kind] = append([]string(nil), values...)
 		}
+	}
+	return clone
+}
+
+type MetadataSet struct {
+	Map map[string]*Metadata
+}
+
+// Clone returns a copy of the set which can be added to without
+// affecting the readers of the set. The metadatas themselves are
+// shared and must be cloned before being modified.
+func (set *MetadataSet) Clone() *MetadataSet {
+	clone := &MetadataSet{Map: make(map[string]*Metadata, len(set.Map))}
+	for k, v := range set.Map {
+		clone.Map[k] = v
+	}
+	return clone
+}
 
-		if err := json.Unmarshal(buf, &Metadatas.Map); err != nil {
+var metadatas struct {
+	value atomic.Value
+	once  sync.Once
+}
+
+// GetMetadatas returns the currently loaded metadatas.
+// The returned set must be treated as read-only, it is
+// replaced as a whole whenever the metadatas are reloaded.
+func GetMetadatas() *MetadataSet {
+	if v, ok := metadatas.value.Load().(*MetadataSet); ok {
+		return v
+	}
+	return &MetadataSet{Map: make(map[string]*Metadata)}
+}
+
+func InitMetadatas() {
+	metadatas.once.Do(func() {
+		if err := ReloadMetadatas(); err != nil {
 			log.Println(err)
+			metadatas.value.Store(&MetadataSet{Map: make(map[string]*Metadata)})
 		}
 	})
 }
+
+// ReloadMetadatas parses the metadata file and atomically
+// swaps it with the currently loaded metadatas.
+func ReloadMetadatas() error {
+	set, err := loadMetadatas()
+	if err != nil {
+		return err
+	}
+	metadatas.value.Store(set)
+	return nil
+}
+
+// StoreMetadatas atomically swaps the currently loaded metadatas with the set.
+func StoreMetadatas(set *MetadataSet) {
+	metadatas.value.Store(set)
+}
+
+func loadMetadatas() (*MetadataSet, error) {
+	set := &MetadataSet{Map: make(map[string]*Metadata)}
+
+	stat, err := os.Stat(Config.Paths.Metadata)
+	if os.IsNotExist(err) || (err == nil && stat.IsDir()) {
+		return set, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	buf, err := os.ReadFile(Config.Paths.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(buf, &set.Map); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
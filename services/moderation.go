@@ -0,0 +1,140 @@
+package services
+
+import (
+	"log"
+	"strings"
+
+	"koushoku/cache"
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/models"
+	"koushoku/modext"
+)
+
+const (
+	// ModerationQuarantined archives match a blacklist rule and await
+	// review. They stay visible until the moderation is applied, and
+	// are released if they no longer match.
+	ModerationQuarantined = "quarantined"
+	// ModerationHidden archives have been reviewed and are left out of
+	// the listings, searches and archive pages until they are released.
+	ModerationHidden = "hidden"
+)
+
+// ManualModerationRule is the rule of the archives quarantined by hand,
+// which are not released when they match no blacklist rule.
+const ManualModerationRule = "manual"
+
+// QuarantineArchive quarantines an archive with the blacklist rule that
+// matched it. Archives that have already been hidden are left as they are.
+func QuarantineArchive(id int64, match *BlacklistMatch) error {
+	if exists, err := models.ArchiveExistsG(id); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	} else if !exists {
+		return errs.ArchiveNotFound
+	}
+
+	_, err := database.Conn.Exec(`INSERT INTO archive_moderation (archive_id, state, rule_id, rule, reasons)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+		ON CONFLICT (archive_id) DO UPDATE SET
+			rule_id = EXCLUDED.rule_id, rule = EXCLUDED.rule,
+			reasons = EXCLUDED.reasons, updated_at = NOW()
+		WHERE archive_moderation.state = $2`,
+		id, ModerationQuarantined, match.ID, match.Rule, strings.Join(match.Reasons, "\n"))
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
+	cache.Archives.PurgeWithPrefix(id)
+	return nil
+}
+
+// ReleaseArchive lifts the moderation of an archive.
+func ReleaseArchive(id int64) error {
+	res, err := database.Conn.Exec(`DELETE FROM archive_moderation WHERE archive_id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	} else if n == 0 {
+		return errs.ModerationNotFound
+	}
+
+	cache.Archives.PurgeWithPrefix(id)
+	PurgeArchivesResults()
+	return nil
+}
+
+// ApplyModeration hides every quarantined archive from the public
+// and returns how many archives were hidden.
+func ApplyModeration() (int64, error) {
+	res, err := database.Conn.Exec(`UPDATE archive_moderation
+		SET state = $1, updated_at = NOW() WHERE state = $2`,
+		ModerationHidden, ModerationQuarantined)
+	if err != nil {
+		log.Println(err)
+		return 0, errs.Unknown
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return 0, errs.Unknown
+	}
+
+	if n > 0 {
+		cache.Archives.Purge()
+		cache.Favorites.Purge()
+	}
+	return n, nil
+}
+
+// ListModerations returns the moderated archives, optionally filtered by state.
+func ListModerations(state string) ([]*modext.ArchiveModeration, error) {
+	q := `SELECT m.archive_id, EXTRACT(EPOCH FROM m.created_at)::BIGINT,
+		EXTRACT(EPOCH FROM m.updated_at)::BIGINT, archive.title, archive.path,
+		m.state, COALESCE(m.rule_id, 0), m.rule, m.reasons
+		FROM archive_moderation m
+		INNER JOIN archive ON archive.id = m.archive_id`
+
+	var args []any
+	if len(state) > 0 {
+		q += ` WHERE m.state = $1`
+		args = append(args, state)
+	}
+
+	rows, err := database.Conn.Query(q+` ORDER BY m.archive_id ASC`, args...)
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	defer rows.Close()
+
+	result := []*modext.ArchiveModeration{}
+	for rows.Next() {
+		var reasons string
+		m := &modext.ArchiveModeration{}
+		if err := rows.Scan(&m.ArchiveID, &m.CreatedAt, &m.UpdatedAt, &m.Title, &m.Path,
+			&m.State, &m.RuleID, &m.Rule, &reasons); err != nil {
+			log.Println(err)
+			return nil, errs.Unknown
+		}
+
+		if len(reasons) > 0 {
+			m.Reasons = strings.Split(reasons, "\n")
+		}
+		result = append(result, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return result, nil
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxCorrectionDistance is the largest edit distance of a correction,
+// which is lower for the short slugs to avoid suggesting unrelated names.
+const maxCorrectionDistance = 3
+
+type searchCorrection struct {
+	Node *SearchNode
+	Name string
+}
+
+// CorrectSearchQuery returns the query with its taxonomy terms that match
+// nothing replaced with the closest name of the same kind, going by the
+// edit distance of their slugs and of the aliases, or false if there is
+// nothing to correct. It is meant for the searches without results.
+func CorrectSearchQuery(query string) (string, bool) {
+	// The admin-only fields are allowed as the query has already been
+	// parsed, and they are left as is.
+	query = strings.TrimSpace(query)
+	node, err := ParseAdminSearchQuery(query)
+	if err != nil || node == nil {
+		return "", false
+	}
+
+	index, err := getSuggestIndex()
+	if err != nil {
+		log.Println(err)
+		return "", false
+	}
+
+	var corrections []*searchCorrection
+	var walk func(n *SearchNode)
+	walk = func(n *SearchNode) {
+		switch n.Op {
+		case SearchAnd, SearchOr:
+			for _, child := range n.Children {
+				walk(child)
+			}
+		case SearchTerm:
+			if name, ok := index.correct(n); ok {
+				corrections = append(corrections, &searchCorrection{Node: n, Name: name})
+			}
+		}
+		// The excluded terms are not corrected, as excluding
+		// a name that matches nothing excludes nothing.
+	}
+	walk(node)
+
+	if len(corrections) == 0 {
+		return "", false
+	}
+
+	var sb strings.Builder
+	pos := 0
+	for _, c := range corrections {
+		sb.WriteString(query[pos:c.Node.Pos])
+		sb.WriteString(quoteSearchValue(c.Name))
+		pos = c.Node.End
+	}
+	sb.WriteString(query[pos:])
+	return sb.String(), true
+}
+
+// correct returns the name closest to the value of a taxonomy term
+// if the term has no match, false if it has or if nothing is close.
+func (index *suggestIndex) correct(n *SearchNode) (string, bool) {
+	kinds := GetTaxonomyKinds()
+	if n.Wildcard {
+		return "", false
+	} else if _, ok := kinds.Get(n.Field); !ok {
+		return "", false
+	}
+
+	slugify := Slugify
+	if n.Field == "tag" {
+		slugify = TagSlug
+	}
+
+	slug := slugify(n.Value)
+	aliases := GetAliases().matches(n.Field)
+	if target, ok := aliases[slug]; ok {
+		slug = slugify(target)
+	}
+
+	slugs := index.Slugs[n.Field]
+	if _, ok := slugs[slug]; ok || len(slug) == 0 {
+		return "", false
+	}
+
+	max := Min(maxCorrectionDistance, Max(1, utf8.RuneCountInString(slug)/3))
+	best, bestDistance := int32(-1), max+1
+
+	consider := func(i int32, candidate string) {
+		d := editDistance(slug, candidate, bestDistance+1)
+		if d < bestDistance || (d == bestDistance && best >= 0 && index.Entries[i].Count > index.Entries[best].Count) {
+			best, bestDistance = i, d
+		}
+	}
+
+	for candidate, i := range slugs {
+		consider(i, candidate)
+
+		// The tags given without their namespace are compared
+		// with the names of the namespaced tags as well.
+		if j := strings.IndexByte(candidate, ':'); j >= 0 && !strings.Contains(slug, ":") {
+			consider(i, candidate[j+1:])
+		}
+	}
+
+	for alias, target := range aliases {
+		if i, ok := slugs[slugify(target)]; ok {
+			consider(i, alias)
+		}
+	}
+
+	if best < 0 {
+		return "", false
+	}
+	return index.Entries[best].Name, true
+}
+
+// editDistance returns the Levenshtein distance between a and b,
+// or max as soon as it is known to be at least max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d >= max || -d >= max {
+		return max
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		lowest := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = Min(Min(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+			lowest = Min(lowest, curr[j])
+		}
+		if lowest >= max {
+			return max
+		}
+		prev, curr = curr, prev
+	}
+	return Min(prev[len(rb)], max)
+}
+
+// quoteSearchValue quotes a value if it could not be written as is in a term.
+func quoteSearchValue(v string) string {
+	if !strings.ContainsAny(v, " \t,\"()|*") {
+		return v
+	}
+	return `"` + strings.ReplaceAll(v, `"`, `\"`) + `"`
+}
@@ -0,0 +1,525 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Operators of the search query nodes.
+const (
+	SearchAnd  = "and"
+	SearchOr   = "or"
+	SearchNot  = "not"
+	SearchTerm = "term"
+	SearchText = "text"
+)
+
+// maxSearchTerms limits how many terms a single query can have.
+const maxSearchTerms = 64
+
+// SearchNode is a node of a parsed search query. A term filters by a field,
+// e.g. tag:foo, a text matches the titles and taxonomy names, and the
+// other operators combine their children.
+type SearchNode struct {
+	Op       string        `json:"o"`
+	Children []*SearchNode `json:"c,omitempty"`
+
+	Field    string `json:"f,omitempty"`
+	Cmp      string `json:"p,omitempty"`
+	Value    string `json:"v,omitempty"`
+	Wildcard bool   `json:"w,omitempty"`
+	Phrase   bool   `json:"q,omitempty"`
+
+	// Pos is the offset of the node in the query, for the error messages,
+	// and End the offset after the value of a term, to replace it.
+	Pos int `json:"-"`
+	End int `json:"-"`
+}
+
+// IsText returns whether the whole query is a plain text, without any field.
+func (n *SearchNode) IsText() bool {
+	return n != nil && n.Op == SearchText
+}
+
+// SearchSyntaxError is returned for queries that cannot be parsed,
+// Pos being the offset of the offending part of the query.
+type SearchSyntaxError struct {
+	Pos     int    `json:"position"`
+	Message string `json:"message"`
+}
+
+func (e *SearchSyntaxError) Error() string {
+	return fmt.Sprintf("%s (at character %d)", e.Message, e.Pos+1)
+}
+
+func syntaxError(pos int, format string, args ...any) *SearchSyntaxError {
+	return &SearchSyntaxError{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+type searchTokenKind int
+
+const (
+	tokenEOF searchTokenKind = iota
+	tokenLParen
+	tokenRParen
+	tokenOr
+	tokenAnd
+	tokenNot
+	tokenTerm
+	tokenText
+)
+
+type searchValue struct {
+	Value  string
+	Quoted bool
+	Pos    int
+	End    int
+}
+
+type searchToken struct {
+	Kind searchTokenKind
+	Pos  int
+
+	// Set for the terms, Mod is either & or | and
+	// Star is set by the legacy field* syntax.
+	Field  string
+	Mod    byte
+	Star   bool
+	Cmp    string
+	Values []searchValue
+
+	// Set for the texts.
+	Text   string
+	Quoted bool
+}
+
+type searchLexer struct {
+	src    string
+	pos    int
+	tokens []searchToken
+}
+
+func isSearchDelim(c byte) bool {
+	return c == '(' || c == ')' || c == '|' || unicode.IsSpace(rune(c))
+}
+
+func isSearchFieldChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (l *searchLexer) skipSpaces() {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+}
+
+// readQuoted reads a double quoted string, the opening quote being at l.pos.
+// A quote can be escaped with a backslash.
+func (l *searchLexer) readQuoted() (string, error) {
+	start := l.pos
+	l.pos++
+
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == '\\' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '"' {
+			sb.WriteByte('"')
+			l.pos += 2
+			continue
+		}
+		if c == '"' {
+			l.pos++
+			return sb.String(), nil
+		}
+		sb.WriteByte(c)
+		l.pos++
+	}
+	return "", syntaxError(start, "Missing closing quote")
+}
+
+// readBare reads an unquoted value, which ends at a space, a parenthesis,
+// a pipe, or a comma when reading the values of a term.
+func (l *searchLexer) readBare(inTerm bool) string {
+	start := l.pos
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if isSearchDelim(c) || (inTerm && c == ',') {
+			break
+		}
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+// readField reads a field prefix such as tag:, tag&:, tag*: or tag|*:,
+// and returns false without moving if there is none at l.pos.
+func (l *searchLexer) readField(tok *searchToken) bool {
+	i := l.pos
+	for i < len(l.src) && isSearchFieldChar(l.src[i]) {
+		i++
+	}
+	if i == l.pos {
+		return false
+	}
+
+	field := l.src[l.pos:i]
+	var mod byte
+	var star bool
+
+	if i < len(l.src) && (l.src[i] == '&' || l.src[i] == '|') {
+		mod = l.src[i]
+		i++
+	}
+	if i < len(l.src) && l.src[i] == '*' {
+		star = true
+		i++
+	}
+	if i >= len(l.src) || l.src[i] != ':' {
+		return false
+	}
+
+	tok.Field, tok.Mod, tok.Star = strings.ToLower(field), mod, star
+	l.pos = i + 1
+	return true
+}
+
+func (l *searchLexer) readValues(tok *searchToken) error {
+	for _, op := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			tok.Cmp = op
+			l.pos += len(op)
+			break
+		}
+	}
+
+	for {
+		pos := l.pos
+		if l.pos < len(l.src) && l.src[l.pos] == '"' {
+			v, err := l.readQuoted()
+			if err != nil {
+				return err
+			}
+			tok.Values = append(tok.Values, searchValue{Value: v, Quoted: true, Pos: pos, End: l.pos})
+		} else {
+			v := l.readBare(true)
+			if len(v) == 0 {
+				return syntaxError(pos, "Missing value after %q", tok.Field+":")
+			}
+			tok.Values = append(tok.Values, searchValue{Value: v, Pos: pos, End: l.pos})
+		}
+
+		if l.pos < len(l.src) && l.src[l.pos] == ',' {
+			l.pos++
+			continue
+		}
+		return nil
+	}
+}
+
+func (l *searchLexer) next() error {
+	l.skipSpaces()
+	if l.pos >= len(l.src) {
+		l.tokens = append(l.tokens, searchToken{Kind: tokenEOF, Pos: l.pos})
+		return nil
+	}
+
+	start := l.pos
+	switch c := l.src[l.pos]; {
+	case c == '(':
+		l.pos++
+		l.tokens = append(l.tokens, searchToken{Kind: tokenLParen, Pos: start})
+		return nil
+	case c == ')':
+		l.pos++
+		l.tokens = append(l.tokens, searchToken{Kind: tokenRParen, Pos: start})
+		return nil
+	case c == '|':
+		l.pos++
+		l.tokens = append(l.tokens, searchToken{Kind: tokenOr, Pos: start})
+		return nil
+	case c == '-' && l.pos+1 < len(l.src) && (l.src[l.pos+1] == '(' || !isSearchDelim(l.src[l.pos+1])):
+		l.pos++
+		l.tokens = append(l.tokens, searchToken{Kind: tokenNot, Pos: start})
+		return nil
+	case c == '"':
+		v, err := l.readQuoted()
+		if err != nil {
+			return err
+		}
+		l.tokens = append(l.tokens, searchToken{Kind: tokenText, Pos: start, Text: v, Quoted: true})
+		return nil
+	}
+
+	tok := searchToken{Kind: tokenTerm, Pos: start}
+	if l.readField(&tok) {
+		if err := l.readValues(&tok); err != nil {
+			return err
+		}
+		l.tokens = append(l.tokens, tok)
+		return nil
+	}
+
+	word := l.readBare(false)
+	switch word {
+	case "OR":
+		l.tokens = append(l.tokens, searchToken{Kind: tokenOr, Pos: start})
+	case "AND":
+		l.tokens = append(l.tokens, searchToken{Kind: tokenAnd, Pos: start})
+	case "NOT":
+		l.tokens = append(l.tokens, searchToken{Kind: tokenNot, Pos: start})
+	default:
+		l.tokens = append(l.tokens, searchToken{Kind: tokenText, Pos: start, Text: word})
+	}
+	return nil
+}
+
+func lexSearchQuery(src string) ([]searchToken, error) {
+	l := &searchLexer{src: src}
+	for {
+		if err := l.next(); err != nil {
+			return nil, err
+		}
+		if l.tokens[len(l.tokens)-1].Kind == tokenEOF {
+			return l.tokens, nil
+		}
+	}
+}
+
+type searchParser struct {
+	tokens []searchToken
+	pos    int
+	terms  int
+	admin  bool
+}
+
+func (p *searchParser) peek() *searchToken {
+	return &p.tokens[p.pos]
+}
+
+func (p *searchParser) advance() *searchToken {
+	tok := &p.tokens[p.pos]
+	if tok.Kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func startsSearchOperand(kind searchTokenKind) bool {
+	return kind == tokenLParen || kind == tokenNot || kind == tokenTerm || kind == tokenText
+}
+
+func (p *searchParser) parseOr() (*SearchNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	node := left
+	for p.peek().Kind == tokenOr {
+		op := p.advance()
+		if !startsSearchOperand(p.peek().Kind) {
+			return nil, syntaxError(op.Pos, "Missing search term after OR")
+		}
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		if node.Op != SearchOr || node == left && left.Op == SearchOr {
+			node = &SearchNode{Op: SearchOr, Children: []*SearchNode{node}, Pos: left.Pos}
+		}
+		node.Children = append(node.Children, right)
+	}
+	return node, nil
+}
+
+func (p *searchParser) parseAnd() (*SearchNode, error) {
+	tok := p.peek()
+	if tok.Kind == tokenOr {
+		return nil, syntaxError(tok.Pos, "Missing search term before OR")
+	} else if tok.Kind == tokenAnd {
+		return nil, syntaxError(tok.Pos, "Missing search term before AND")
+	} else if !startsSearchOperand(tok.Kind) {
+		if tok.Kind == tokenRParen {
+			return nil, syntaxError(tok.Pos, "Unexpected closing parenthesis")
+		}
+		return nil, syntaxError(tok.Pos, "Missing search term")
+	}
+
+	node := &SearchNode{Op: SearchAnd, Pos: tok.Pos}
+	for {
+		tok := p.peek()
+		if tok.Kind == tokenAnd {
+			p.advance()
+			if !startsSearchOperand(p.peek().Kind) {
+				return nil, syntaxError(tok.Pos, "Missing search term after AND")
+			}
+			continue
+		}
+		if !startsSearchOperand(tok.Kind) {
+			break
+		}
+
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		// Consecutive words are searched for as a single text.
+		if last := len(node.Children) - 1; last >= 0 && child.Op == SearchText && !child.Phrase &&
+			node.Children[last].Op == SearchText && !node.Children[last].Phrase {
+			node.Children[last].Value += " " + child.Value
+			continue
+		}
+		node.Children = append(node.Children, child)
+	}
+
+	if len(node.Children) == 1 {
+		return node.Children[0], nil
+	}
+	return node, nil
+}
+
+func (p *searchParser) parseUnary() (*SearchNode, error) {
+	if tok := p.peek(); tok.Kind == tokenNot {
+		p.advance()
+		if !startsSearchOperand(p.peek().Kind) || p.peek().Kind == tokenNot {
+			return nil, syntaxError(tok.Pos, "Missing search term to exclude")
+		}
+
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &SearchNode{Op: SearchNot, Children: []*SearchNode{child}, Pos: tok.Pos}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *searchParser) parsePrimary() (*SearchNode, error) {
+	tok := p.advance()
+	switch tok.Kind {
+	case tokenLParen:
+		if p.peek().Kind == tokenRParen {
+			return nil, syntaxError(tok.Pos, "Empty parentheses")
+		}
+
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek().Kind != tokenRParen {
+			return nil, syntaxError(tok.Pos, "Missing closing parenthesis")
+		}
+		p.advance()
+		return node, nil
+	case tokenText:
+		if p.terms++; p.terms > maxSearchTerms {
+			return nil, syntaxError(tok.Pos, "Too many search terms, at most %d are allowed", maxSearchTerms)
+		}
+		value := normalizeSearchText(tok.Text)
+		if len(value) == 0 {
+			return nil, syntaxError(tok.Pos, "Empty quotes")
+		}
+		// Quoted texts are searched for as phrases and never merged with the other words.
+		return &SearchNode{Op: SearchText, Value: value, Phrase: tok.Quoted, Pos: tok.Pos}, nil
+	case tokenTerm:
+		return p.parseTerm(tok)
+	}
+	return nil, syntaxError(tok.Pos, "Missing search term")
+}
+
+// parseTerm turns a term into a node, or a group of nodes when it has
+// several values, which are matched by any of them unless joined with &.
+func (p *searchParser) parseTerm(tok *searchToken) (*SearchNode, error) {
+	field, ok := getSearchField(tok.Field)
+	if !ok {
+		return nil, syntaxError(tok.Pos, "Unknown field %q, put the text in quotes to search for it as is", tok.Field)
+	} else if field.Admin && !p.admin {
+		return nil, syntaxError(tok.Pos, "Field %q is only available to administrators", tok.Field)
+	}
+
+	if len(tok.Cmp) > 0 && !field.Compare {
+		return nil, syntaxError(tok.Pos, "Field %q does not support comparisons", tok.Field)
+	} else if len(tok.Values) > 1 && !field.Multiple {
+		return nil, syntaxError(tok.Values[1].Pos, "Field %q takes a single value", tok.Field)
+	}
+
+	group := &SearchNode{Op: SearchOr, Pos: tok.Pos}
+	if tok.Mod == '&' {
+		group.Op = SearchAnd
+	}
+
+	for _, v := range tok.Values {
+		if p.terms++; p.terms > maxSearchTerms {
+			return nil, syntaxError(v.Pos, "Too many search terms, at most %d are allowed", maxSearchTerms)
+		}
+
+		value, wildcard := v.Value, tok.Star
+		if !v.Quoted && strings.Contains(value, "*") {
+			value, wildcard = strings.ReplaceAll(value, "*", ""), true
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) == 0 {
+			return nil, syntaxError(v.Pos, "Missing value after %q", tok.Field+":")
+		}
+
+		node := &SearchNode{Op: SearchTerm, Field: tok.Field, Cmp: tok.Cmp, Value: value, Wildcard: wildcard, Pos: v.Pos, End: v.End}
+		if _, _, err := field.compile(node); err != nil {
+			return nil, err
+		}
+		group.Children = append(group.Children, node)
+	}
+
+	if len(group.Children) == 1 {
+		return group.Children[0], nil
+	}
+	return group, nil
+}
+
+// ParseSearchQuery parses a search query, e.g.
+// (tag:a | artist:b) -tag:c title:"foo, bar".
+// Terms are joined with AND unless separated with | or OR, and can be
+// excluded with - or NOT. A term with several comma separated values
+// matches any of them, or all of them when written as field&:a,b.
+// A value containing * matches partially, and the numeric and date fields
+// can be compared with <, <=, > and >= or given a range as a..b. The words
+// outside of a term are searched for in the titles and the taxonomy names.
+func ParseSearchQuery(query string) (*SearchNode, error) {
+	return parseSearchQuery(query, false)
+}
+
+// ParseAdminSearchQuery parses a search query like ParseSearchQuery,
+// also allowing the fields reserved to the administrators.
+func ParseAdminSearchQuery(query string) (*SearchNode, error) {
+	return parseSearchQuery(query, true)
+}
+
+func parseSearchQuery(query string, admin bool) (*SearchNode, error) {
+	query = strings.TrimSpace(query)
+	if len(query) == 0 {
+		return nil, nil
+	}
+
+	tokens, err := lexSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &searchParser{tokens: tokens, admin: admin}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if tok := p.peek(); tok.Kind == tokenRParen {
+		return nil, syntaxError(tok.Pos, "Unexpected closing parenthesis")
+	} else if tok.Kind != tokenEOF {
+		return nil, syntaxError(tok.Pos, "Unexpected search term")
+	}
+	return node, nil
+}
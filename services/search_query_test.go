@@ -0,0 +1,313 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// formatSearchNode renders a node as an s-expression, e.g.
+// (or (and tag:a tag:b) (not "foo")), to compare the parsed trees.
+func formatSearchNode(n *SearchNode) string {
+	switch n.Op {
+	case SearchTerm:
+		s := fmt.Sprintf("%s:%s%s", n.Field, n.Cmp, n.Value)
+		if n.Wildcard {
+			s += "*"
+		}
+		return s
+	case SearchText:
+		if n.Phrase {
+			return fmt.Sprintf("%q", n.Value)
+		}
+		return fmt.Sprintf("'%s'", n.Value)
+	}
+
+	children := make([]string, len(n.Children))
+	for i, child := range n.Children {
+		children[i] = formatSearchNode(child)
+	}
+	return fmt.Sprintf("(%s %s)", n.Op, strings.Join(children, " "))
+}
+
+func TestLexSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		kinds []searchTokenKind
+	}{
+		{"foo", []searchTokenKind{tokenText, tokenEOF}},
+		{"tag:a", []searchTokenKind{tokenTerm, tokenEOF}},
+		{"(tag:a|tag:b)", []searchTokenKind{tokenLParen, tokenTerm, tokenOr, tokenTerm, tokenRParen, tokenEOF}},
+		{"tag:a OR tag:b AND tag:c", []searchTokenKind{tokenTerm, tokenOr, tokenTerm, tokenAnd, tokenTerm, tokenEOF}},
+		{"-tag:a NOT b", []searchTokenKind{tokenNot, tokenTerm, tokenNot, tokenText, tokenEOF}},
+		{"-(a)", []searchTokenKind{tokenNot, tokenLParen, tokenText, tokenRParen, tokenEOF}},
+		{"a - b", []searchTokenKind{tokenText, tokenText, tokenText, tokenEOF}},
+		{"or and not", []searchTokenKind{tokenText, tokenText, tokenText, tokenEOF}},
+		{`"foo bar" title:"a, b"`, []searchTokenKind{tokenText, tokenTerm, tokenEOF}},
+		{"foo:bar:baz", []searchTokenKind{tokenTerm, tokenEOF}},
+	}
+
+	for _, test := range tests {
+		tokens, err := lexSearchQuery(test.query)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.query, err)
+			continue
+		}
+
+		kinds := make([]searchTokenKind, len(tokens))
+		for i, tok := range tokens {
+			kinds[i] = tok.Kind
+		}
+		if !reflect.DeepEqual(kinds, test.kinds) {
+			t.Errorf("%q: got tokens %v, want %v", test.query, kinds, test.kinds)
+		}
+	}
+}
+
+func TestLexSearchQueryValues(t *testing.T) {
+	tests := []struct {
+		query  string
+		field  string
+		mod    byte
+		star   bool
+		cmp    string
+		values []string
+	}{
+		{"tag:a", "tag", 0, false, "", []string{"a"}},
+		{"TAG:a", "tag", 0, false, "", []string{"a"}},
+		{"tag:a,b,c", "tag", 0, false, "", []string{"a", "b", "c"}},
+		{"tag&:a,b", "tag", '&', false, "", []string{"a", "b"}},
+		{"tag|*:a", "tag", '|', true, "", []string{"a"}},
+		{"pages:>=20", "pages", 0, false, ">=", []string{"20"}},
+		{`title:"foo, bar",baz`, "title", 0, false, "", []string{"foo, bar", "baz"}},
+		{`title:"say \"hi\""`, "title", 0, false, "", []string{`say "hi"`}},
+	}
+
+	for _, test := range tests {
+		tokens, err := lexSearchQuery(test.query)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.query, err)
+			continue
+		}
+
+		tok := tokens[0]
+		var values []string
+		for _, v := range tok.Values {
+			values = append(values, v.Value)
+		}
+		if tok.Field != test.field || tok.Mod != test.mod || tok.Star != test.star || tok.Cmp != test.cmp ||
+			!reflect.DeepEqual(values, test.values) {
+			t.Errorf("%q: got %s %q %v %q %q, want %s %q %v %q %q", test.query,
+				tok.Field, tok.Mod, tok.Star, tok.Cmp, values,
+				test.field, test.mod, test.star, test.cmp, test.values)
+		}
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		// Precedence: AND binds tighter than OR, parentheses override both.
+		{"tag:a tag:b", "(and tag:a tag:b)"},
+		{"tag:a tag:b | tag:c", "(or (and tag:a tag:b) tag:c)"},
+		{"tag:a | tag:b tag:c", "(or tag:a (and tag:b tag:c))"},
+		{"tag:a OR tag:b OR tag:c", "(or tag:a tag:b tag:c)"},
+		{"tag:a AND tag:b", "(and tag:a tag:b)"},
+		{"(tag:a | tag:b) tag:c", "(and (or tag:a tag:b) tag:c)"},
+		{"(tag:a | tag:b) | tag:c", "(or (or tag:a tag:b) tag:c)"},
+		{"((tag:a))", "tag:a"},
+
+		// Negation.
+		{"-tag:a", "(not tag:a)"},
+		{"NOT tag:a", "(not tag:a)"},
+		{"tag:a -tag:b", "(and tag:a (not tag:b))"},
+		{"-(tag:a | tag:b)", "(not (or tag:a tag:b))"},
+		{"-tag:a,b", "(not (or tag:a tag:b))"},
+		{"-foo", "(not 'foo')"},
+
+		// Texts and quoting.
+		{"foo", "'foo'"},
+		{"foo bar", "'foo bar'"},
+		{"  Foo   BAR ", "'foo bar'"},
+		{`"foo bar"`, `"foo bar"`},
+		{`foo "bar baz" qux`, `(and 'foo' "bar baz" 'qux')`},
+		{"foo tag:a bar", "(and 'foo' tag:a 'bar')"},
+		{`title:"foo, bar"`, "title:foo, bar"},
+		{`tag:"big breasts"`, "tag:big breasts"},
+
+		// Comma lists match any of the values, or all of them with &.
+		{"tag:a,b", "(or tag:a tag:b)"},
+		{"tag&:a,b", "(and tag:a tag:b)"},
+		{"artist:a,b tag:c", "(and (or artist:a artist:b) tag:c)"},
+
+		// Wildcards.
+		{"tag:foo*", "tag:foo*"},
+		{"tag:*foo*", "tag:foo*"},
+		{"tag*:foo", "tag:foo*"},
+		{"tag|*:a,b", "(or tag:a* tag:b*)"},
+
+		// Comparisons.
+		{"pages:>20", "pages:>20"},
+		{"pages:<=20", "pages:<=20"},
+		{"size:10MB..20MB", "size:10MB..20MB"},
+		{"created:2022-01", "created:2022-01"},
+	}
+
+	for _, test := range tests {
+		node, err := ParseSearchQuery(test.query)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.query, err)
+			continue
+		}
+		if got := formatSearchNode(node); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseEmptySearchQuery(t *testing.T) {
+	for _, query := range []string{"", "   "} {
+		if node, err := ParseSearchQuery(query); node != nil || err != nil {
+			t.Errorf("%q: got %v, %v, want nil, nil", query, node, err)
+		}
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		pos     int
+		message string
+	}{
+		{"(tag:a", 0, "Missing closing parenthesis"},
+		{"tag:a)", 5, "Unexpected closing parenthesis"},
+		{")", 0, "Unexpected closing parenthesis"},
+		{"()", 0, "Empty parentheses"},
+		{"tag:a |", 6, "Missing search term after OR"},
+		{"| tag:a", 0, "Missing search term before OR"},
+		{"tag:a AND", 6, "Missing search term after AND"},
+		{"AND tag:a", 0, "Missing search term before AND"},
+		{"tag:a --tag:b", 6, "Missing search term to exclude"},
+		{"NOT", 0, "Missing search term"},
+		{`"foo`, 0, "Missing closing quote"},
+		{`tag:a title:"foo`, 12, "Missing closing quote"},
+		{`""`, 0, "Empty quotes"},
+		{"tag:", 4, `Missing value after "tag:"`},
+		{"tag:a,", 6, `Missing value after "tag:"`},
+		{"nope:a", 0, `Unknown field "nope"`},
+		{"pages:1,2", 8, `Field "pages" takes a single value`},
+		{"has:>1", 0, `Field "has" does not support comparisons`},
+		{"pages:x", 6, `Invalid number of pages "x"`},
+		{"size:huge", 5, `Invalid size "huge"`},
+		{"created:2022-13", 8, `Invalid date "2022-13"`},
+		{"created:>2022..2023", 9, "A range cannot be compared"},
+		{"expunged:true", 0, `Field "expunged" is only available to administrators`},
+		{"has:nothing", 4, `Invalid value "nothing"`},
+	}
+
+	for _, test := range tests {
+		_, err := ParseSearchQuery(test.query)
+
+		var syntaxErr *SearchSyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: got %v, want a syntax error", test.query, err)
+			continue
+		}
+		if syntaxErr.Pos != test.pos || !strings.HasPrefix(syntaxErr.Message, test.message) {
+			t.Errorf("%q: got %q at %d, want %q at %d", test.query, syntaxErr.Message, syntaxErr.Pos, test.message, test.pos)
+		}
+	}
+}
+
+func TestParseSearchQueryTooManyTerms(t *testing.T) {
+	terms := make([]string, maxSearchTerms+1)
+	for i := range terms {
+		terms[i] = fmt.Sprintf("tag:t%d", i)
+	}
+
+	query := strings.Join(terms, " ")
+	if _, err := ParseSearchQuery(query); err == nil {
+		t.Errorf("%d terms: got no error", len(terms))
+	}
+	if _, err := ParseSearchQuery(strings.Join(terms[1:], " ")); err != nil {
+		t.Errorf("%d terms: unexpected error %v", maxSearchTerms, err)
+	}
+}
+
+func TestParseAdminSearchQuery(t *testing.T) {
+	node, err := ParseAdminSearchQuery("expunged:true redirected:false")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got, want := formatSearchNode(node), "(and expunged:true redirected:false)"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestSearchNodeToSql(t *testing.T) {
+	tagMatch, _ := taxonomyTables["tag"].match("a", false)
+	tagWildcard, _ := taxonomyTables["tag"].match("a", true)
+	artistMatch, _ := taxonomyTables["artist"].match("b", false)
+
+	tests := []struct {
+		query string
+		sql   string
+		args  []any
+	}{
+		{"tag:a", tagMatch, []any{"a"}},
+		{"tag:a*", tagWildcard, []any{"a"}},
+		{"TAG:A", tagMatch, []any{"a"}},
+		{"-tag:a", "NOT (" + tagMatch + ")", []any{"a"}},
+		{"tag:a artist:b", "(" + tagMatch + " AND " + artistMatch + ")", []any{"a", "b"}},
+		{"tag:a | artist:b", "(" + tagMatch + " OR " + artistMatch + ")", []any{"a", "b"}},
+		{"tag:a | -(artist:b pages:5)", "(" + tagMatch + " OR NOT ((" + artistMatch + " AND archive.pages = ?)))", []any{"a", "b", 5}},
+		{"pages:>20", "archive.pages > ?", []any{20}},
+		{"size:<1kb", "archive.size < ?", []any{int64(1024)}},
+		{"size:1k..2k", "(archive.size >= ? AND archive.size <= ?)", []any{int64(1024), int64(2048)}},
+		{"size:..2k", "(archive.size <= ?)", []any{int64(2048)}},
+		{"title:Foo Bar", "(archive.slug = ? AND " + rawSqlSearch + ")", []any{"foo", "bar", "bar"}},
+		{`title:"Foo Bar"`, "archive.slug = ?", []any{"foo-bar"}},
+		{"title:foo*", "archive.slug ILIKE '%' || ? || '%'", []any{"foo"}},
+		{`"foo bar"`, rawSqlSearchPhrase, []any{"foo bar"}},
+		{"foo bar", rawSqlSearch, []any{"foo bar", "foo bar"}},
+		{"has:source", "(archive.source IS NOT NULL AND archive.source != '')", nil},
+		{"submission:3", "archive.submission_id = ?", []any{int64(3)}},
+		{"published:<7d", "archive.published_at > NOW() - CAST(? AS INTERVAL)", []any{"7 days"}},
+		{
+			"created:2022-01",
+			"(archive.created_at >= ? AND archive.created_at < ?)",
+			[]any{time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			"created:2021..2022-06",
+			"(archive.created_at >= ? AND archive.created_at < ?)",
+			[]any{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{"created:>2022", "archive.created_at >= ?", []any{time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}},
+	}
+
+	for _, test := range tests {
+		node, err := ParseSearchQuery(test.query)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.query, err)
+			continue
+		}
+
+		sql, args, err := node.ToSql()
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.query, err)
+			continue
+		}
+		if sql != test.sql {
+			t.Errorf("%q: got sql\n%s\nwant\n%s", test.query, sql, test.sql)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%q: got args %#v, want %#v", test.query, args, test.args)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rawSqlSearchPhrase matches the archives containing the words in the same order.
+const rawSqlSearchPhrase = `EXISTS (
+	SELECT 1 FROM archive_search
+	WHERE archive_search.archive_id = archive.id
+		AND archive_search.vector @@ phraseto_tsquery('simple', ?)
+)`
+
+// searchField describes a field of the search queries, e.g. tag:, and
+// how a term of the field is compiled into an SQL condition.
+type searchField struct {
+	// Compare is set for the numeric fields, which accept <, <=, > and >=.
+	Compare bool
+	// Multiple is set for the fields accepting comma separated values.
+	Multiple bool
+	// Admin is set for the fields only available to the administrators.
+	Admin bool
+
+	compile func(n *SearchNode) (string, []any, error)
+}
+
+var searchFields = map[string]*searchField{
+	"title": {
+		Multiple: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			slug := Slugify(n.Value)
+			if len(slug) == 0 {
+				return "", nil, syntaxError(n.Pos, "Invalid title %q", n.Value)
+			}
+
+			if n.Wildcard {
+				return "archive.slug ILIKE '%' || ? || '%'", []any{slug}, nil
+			}
+			if v, ok := GetAliases().ArchiveMatches[slug]; ok {
+				slug = Slugify(v)
+			}
+			return "archive.slug = ?", []any{slug}, nil
+		},
+	},
+
+	"pages": {
+		Compare: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			pages, err := strconv.Atoi(n.Value)
+			if err != nil || pages < 0 {
+				return "", nil, syntaxError(n.Pos, "Invalid number of pages %q", n.Value)
+			}
+			return fmt.Sprintf("archive.pages %s ?", searchCmp(n.Cmp)), []any{pages}, nil
+		},
+	},
+
+	"size": {
+		Compare: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			if from, to, ok := strings.Cut(n.Value, ".."); ok {
+				return compileSearchRange(n, "archive.size", from, to, func(v string) (any, bool) {
+					return parseSize(v)
+				})
+			}
+
+			size, ok := parseSize(n.Value)
+			if !ok {
+				return "", nil, syntaxError(n.Pos, "Invalid size %q, e.g. 50MB", n.Value)
+			}
+			return fmt.Sprintf("archive.size %s ?", searchCmp(n.Cmp)), []any{size}, nil
+		},
+	},
+
+	"created":   {Compare: true, compile: compileSearchDate("archive.created_at")},
+	"published": {Compare: true, compile: compileSearchDate("archive.published_at")},
+
+	"has": {
+		Multiple: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			switch strings.ToLower(n.Value) {
+			case "source":
+				return "(archive.source IS NOT NULL AND archive.source != '')", nil, nil
+			case "submission":
+				return "archive.submission_id IS NOT NULL", nil, nil
+			}
+			return "", nil, syntaxError(n.Pos, "Invalid value %q, has: takes source or submission", n.Value)
+		},
+	},
+
+	"source": {
+		Multiple: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			if n.Wildcard {
+				return "archive.source ILIKE '%' || ? || '%'", []any{n.Value}, nil
+			}
+			return "archive.source = ?", []any{n.Value}, nil
+		},
+	},
+
+	"submission": {
+		Multiple: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			id, err := strconv.ParseInt(n.Value, 10, 64)
+			if err != nil || id <= 0 {
+				return "", nil, syntaxError(n.Pos, "Invalid submission id %q", n.Value)
+			}
+			return "archive.submission_id = ?", []any{id}, nil
+		},
+	},
+
+	"expunged": {
+		Admin: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			v, err := strconv.ParseBool(n.Value)
+			if err != nil {
+				return "", nil, syntaxError(n.Pos, "Invalid value %q, expunged: takes true or false", n.Value)
+			}
+			return "archive.expunged = ?", []any{v}, nil
+		},
+	},
+
+	"redirected": {
+		Admin: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			v, err := strconv.ParseBool(n.Value)
+			if err != nil {
+				return "", nil, syntaxError(n.Pos, "Invalid value %q, redirected: takes true or false", n.Value)
+			}
+			if v {
+				return "archive.redirect_id IS NOT NULL", nil, nil
+			}
+			return "archive.redirect_id IS NULL", nil, nil
+		},
+	},
+}
+
+// compileSearchRange compiles an inclusive range such as 10MB..50MB,
+// either bound of which can be left out.
+func compileSearchRange(n *SearchNode, col, from, to string, parse func(string) (any, bool)) (string, []any, error) {
+	if len(n.Cmp) > 0 {
+		return "", nil, syntaxError(n.Pos, "A range cannot be compared, use either %s:a..b or %s:%sa", n.Field, n.Field, n.Cmp)
+	} else if len(from) == 0 && len(to) == 0 {
+		return "", nil, syntaxError(n.Pos, "Missing bounds of the range %q", n.Value)
+	}
+
+	var q []string
+	var args []any
+	for i, bound := range []string{from, to} {
+		if len(bound) == 0 {
+			continue
+		}
+
+		v, ok := parse(bound)
+		if !ok {
+			return "", nil, syntaxError(n.Pos, "Invalid bound %q of the range %q", bound, n.Value)
+		}
+
+		if i == 0 {
+			q = append(q, col+" >= ?")
+		} else {
+			q = append(q, col+" <= ?")
+		}
+		args = append(args, v)
+	}
+	return fmt.Sprintf("(%s)", strings.Join(q, " AND ")), args, nil
+}
+
+var sizeRgx = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*(b|k|kb|m|mb|g|gb|t|tb)?$`)
+
+// parseSize parses a size such as 512, 50MB or 1.5GB into bytes,
+// the units being powers of 1024.
+func parseSize(str string) (int64, bool) {
+	m := sizeRgx.FindStringSubmatch(strings.TrimSpace(str))
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	switch strings.TrimSuffix(strings.ToLower(m[2]), "b") {
+	case "k":
+		n *= 1 << 10
+	case "m":
+		n *= 1 << 20
+	case "g":
+		n *= 1 << 30
+	case "t":
+		n *= 1 << 40
+	}
+	return int64(n), true
+}
+
+var relativeDateRgx = regexp.MustCompile(`(?i)^(\d+)(h|d|w|m|y)$`)
+
+var relativeDateUnits = map[string]string{
+	"h": "hours",
+	"d": "days",
+	"w": "weeks",
+	"m": "months",
+	"y": "years",
+}
+
+// searchDate is a bound of a date filter, either a period such as 2022-01,
+// which has a start and an end, or an age such as 7d, which is an interval.
+type searchDate struct {
+	Start, End any
+	Relative   bool
+}
+
+// sql returns the SQL expression of the instant the bound refers to.
+func (d *searchDate) sql() string {
+	if d.Relative {
+		return "NOW() - CAST(? AS INTERVAL)"
+	}
+	return "?"
+}
+
+// parseSearchDate parses either an absolute date as 2006, 2006-01
+// or 2006-01-02, or an age relative to now as 12h, 7d, 2w, 6m or 1y.
+func parseSearchDate(str string) (*searchDate, bool) {
+	if m := relativeDateRgx.FindStringSubmatch(str); m != nil {
+		interval := fmt.Sprintf("%s %s", m[1], relativeDateUnits[strings.ToLower(m[2])])
+		return &searchDate{Start: interval, End: interval, Relative: true}, true
+	}
+
+	for _, layout := range []struct {
+		format string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if t, err := time.Parse(layout.format, str); err == nil {
+			return &searchDate{Start: t, End: t.AddDate(layout.years, layout.months, layout.days)}, true
+		}
+	}
+	return nil, false
+}
+
+// compileSearchDate returns the compiler of a date field. A period matches
+// the archives within it, e.g. created:2022-01, or before or after it with
+// a comparison, and a range matches from the start of the first period to
+// the end of the last one, e.g. created:2022-01..2022-06. An age matches the
+// archives younger than it, e.g. published:<7d, or older with >.
+func compileSearchDate(col string) func(n *SearchNode) (string, []any, error) {
+	return func(n *SearchNode) (string, []any, error) {
+		invalid := func(v string) error {
+			return syntaxError(n.Pos, "Invalid date %q, e.g. 2022, 2022-01, 2022-01-31 or 7d", v)
+		}
+
+		if from, to, ok := strings.Cut(n.Value, ".."); ok {
+			if len(n.Cmp) > 0 {
+				return "", nil, syntaxError(n.Pos, "A range cannot be compared, use either %s:a..b or %s:%sa", n.Field, n.Field, n.Cmp)
+			} else if len(from) == 0 && len(to) == 0 {
+				return "", nil, syntaxError(n.Pos, "Missing bounds of the range %q", n.Value)
+			}
+
+			var q []string
+			var args []any
+			if len(from) > 0 {
+				d, ok := parseSearchDate(from)
+				if !ok {
+					return "", nil, invalid(from)
+				}
+				q = append(q, fmt.Sprintf("%s >= %s", col, d.sql()))
+				args = append(args, d.Start)
+			}
+			if len(to) > 0 {
+				d, ok := parseSearchDate(to)
+				if !ok {
+					return "", nil, invalid(to)
+				}
+				q = append(q, fmt.Sprintf("%s < %s", col, d.sql()))
+				args = append(args, d.End)
+			}
+			return fmt.Sprintf("(%s)", strings.Join(q, " AND ")), args, nil
+		}
+
+		d, ok := parseSearchDate(n.Value)
+		if !ok {
+			return "", nil, invalid(n.Value)
+		}
+
+		if d.Relative {
+			switch n.Cmp {
+			case ">":
+				return fmt.Sprintf("%s < %s", col, d.sql()), []any{d.Start}, nil
+			case ">=":
+				return fmt.Sprintf("%s <= %s", col, d.sql()), []any{d.Start}, nil
+			case "<":
+				return fmt.Sprintf("%s > %s", col, d.sql()), []any{d.Start}, nil
+			}
+			return fmt.Sprintf("%s >= %s", col, d.sql()), []any{d.Start}, nil
+		}
+
+		switch n.Cmp {
+		case "<":
+			return fmt.Sprintf("%s < ?", col), []any{d.Start}, nil
+		case "<=":
+			return fmt.Sprintf("%s < ?", col), []any{d.End}, nil
+		case ">":
+			return fmt.Sprintf("%s >= ?", col), []any{d.End}, nil
+		case ">=":
+			return fmt.Sprintf("%s >= ?", col), []any{d.Start}, nil
+		}
+		return fmt.Sprintf("(%s >= ? AND %s < ?)", col, col), []any{d.Start, d.End}, nil
+	}
+}
+
+// searchCmp returns the comparison operator of a term, = by default.
+func searchCmp(cmp string) string {
+	if len(cmp) == 0 {
+		return "="
+	}
+	return cmp
+}
+
+// getSearchField returns the field of the given name, which is either one
+// of the fields above or a taxonomy kind, the aliases of the kind being
+// applied to the exact matches.
+func getSearchField(name string) (*searchField, bool) {
+	if field, ok := searchFields[name]; ok {
+		return field, true
+	}
+
+	t, ok := getTaxonomyTable(name)
+	if !ok {
+		return nil, false
+	}
+
+	return &searchField{
+		Multiple: true,
+		compile: func(n *SearchNode) (string, []any, error) {
+			slug := t.slug(n.Value)
+			if len(slug) == 0 {
+				return "", nil, syntaxError(n.Pos, "Invalid value %q", n.Value)
+			}
+
+			if !n.Wildcard {
+				if v, ok := GetAliases().matches(t.Kind)[slug]; ok {
+					slug = t.slug(v)
+				}
+			}
+
+			sql, args := t.match(slug, n.Wildcard)
+			return sql, args, nil
+		},
+	}, true
+}
+
+// HasField returns whether the query has a term of the given field.
+func (n *SearchNode) HasField(field string) bool {
+	if n == nil {
+		return false
+	} else if n.Op == SearchTerm {
+		return n.Field == field
+	}
+
+	for _, child := range n.Children {
+		if child.HasField(field) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToSql compiles the query into an SQL condition on the archive table.
+func (n *SearchNode) ToSql() (string, []any, error) {
+	switch n.Op {
+	case SearchAnd, SearchOr:
+		var q []string
+		var args []any
+		for _, child := range n.Children {
+			sql, childArgs, err := child.ToSql()
+			if err != nil {
+				return "", nil, err
+			}
+			q = append(q, sql)
+			args = append(args, childArgs...)
+		}
+
+		if n.Op == SearchOr {
+			return JoinOR(q...), args, nil
+		}
+		return fmt.Sprintf("(%s)", strings.Join(q, " AND ")), args, nil
+	case SearchNot:
+		if len(n.Children) != 1 {
+			return "", nil, syntaxError(n.Pos, "Missing search term to exclude")
+		}
+
+		sql, args, err := n.Children[0].ToSql()
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("NOT (%s)", sql), args, nil
+	case SearchText:
+		if n.Phrase {
+			return rawSqlSearchPhrase, []any{n.Value}, nil
+		}
+		return rawSqlSearch, []any{n.Value, n.Value}, nil
+	case SearchTerm:
+		field, ok := getSearchField(n.Field)
+		if !ok {
+			return "", nil, syntaxError(n.Pos, "Unknown field %q", n.Field)
+		}
+		return field.compile(n)
+	}
+	return "", nil, syntaxError(n.Pos, "Invalid search query")
+}
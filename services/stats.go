@@ -52,26 +52,26 @@ func AnalyzeStats() (err error) {
 		return
 	}
 
-	stats.ArtistCount, err = GetArtistCount()
+	stats.ArtistCount, err = GetTaxonomyCount("artist")
 	if err != nil {
 		return
 	}
 
-	stats.CircleCount, err = GetCircleCount()
+	stats.CircleCount, err = GetTaxonomyCount("circle")
 	if err != nil {
 		return
 	}
-	stats.MagazineCount, err = GetMagazineCount()
+	stats.MagazineCount, err = GetTaxonomyCount("magazine")
 	if err != nil {
 		return
 	}
 
-	stats.ParodyCount, err = GetParodyCount()
+	stats.ParodyCount, err = GetTaxonomyCount("parody")
 	if err != nil {
 		return
 	}
 
-	stats.TagCount, err = GetTagCount()
+	stats.TagCount, err = GetTaxonomyCount("tag")
 	if err != nil {
 		return
 	}
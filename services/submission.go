@@ -1,6 +1,7 @@
 package services
 
 import (
+	"database/sql"
 	"log"
 	"strings"
 	"time"
@@ -50,7 +51,10 @@ func CreateSubmission(name, submitter, content string) (*modext.Submission, erro
 		log.Println(err)
 		return nil, errs.Unknown
 	}
-	return modext.NewSubmission(submission), nil
+
+	result := modext.NewSubmission(submission)
+	emitEvent(EventSubmissionCreated, newSubmissionEvent(result))
+	return result, nil
 }
 
 type GetSubmissionsOptions struct {
@@ -122,7 +126,11 @@ func GetSubmissions(opts GetSubmissionsOptions) (result *GetSubmissionsResult) {
 func AcceptSubmission(id int64, notes string) error {
 	submission, err := models.FindSubmissionG(id)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return errs.SubmissionNotFound
+		}
+		log.Println(err)
+		return errs.Unknown
 	}
 
 	submission.Accepted = true
@@ -132,13 +140,19 @@ func AcceptSubmission(id int64, notes string) error {
 	submission.Rejected = false
 	submission.RejectedAt.Valid = false
 
-	return submission.UpdateG(boil.Whitelist("accepted", "accepted_at", "rejected", "rejected_at", "notes"))
+	if err := submission.UpdateG(boil.Whitelist("accepted", "accepted_at", "rejected", "rejected_at", "notes")); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+	emitEvent(EventSubmissionAccepted, newSubmissionEvent(modext.NewSubmission(submission)))
+	return nil
 }
 
 func ListSubmissions() ([]*modext.Submission, error) {
 	submissions, err := models.Submissions(OrderBy("id ASC")).AllG()
 	if err != nil {
-		return nil, err
+		log.Println(err)
+		return nil, errs.Unknown
 	}
 
 	result := make([]*modext.Submission, len(submissions))
@@ -151,7 +165,11 @@ func ListSubmissions() ([]*modext.Submission, error) {
 func RejectSubmission(id int64, note string) error {
 	submission, err := models.FindSubmissionG(id)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return errs.SubmissionNotFound
+		}
+		log.Println(err)
+		return errs.Unknown
 	}
 
 	submission.Accepted = false
@@ -161,20 +179,37 @@ func RejectSubmission(id int64, note string) error {
 	submission.RejectedAt = null.TimeFrom(time.Now().UTC())
 	submission.Notes = null.StringFrom(note)
 
-	return submission.UpdateG(boil.Whitelist("accepted", "accepted_at", "rejected", "rejected_at", "notes"))
+	if err := submission.UpdateG(boil.Whitelist("accepted", "accepted_at", "rejected", "rejected_at", "notes")); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+	emitEvent(EventSubmissionRejected, newSubmissionEvent(modext.NewSubmission(submission)))
+	return nil
 }
 
 func LinkSubmission(archiveId int64, submissionId int64) error {
 	archive, err := models.FindArchiveG(archiveId)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return errs.ArchiveNotFound
+		}
+		log.Println(err)
+		return errs.Unknown
 	}
 
 	submission, err := models.FindSubmissionG(submissionId)
 	if err != nil {
-		return err
+		if err == sql.ErrNoRows {
+			return errs.SubmissionNotFound
+		}
+		log.Println(err)
+		return errs.Unknown
 	}
 
 	archive.SubmissionID = null.Int64From(submission.ID)
-	return archive.UpdateG(boil.Infer())
+	if err := archive.UpdateG(boil.Infer()); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+	return nil
 }
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"koushoku/database"
+	"koushoku/errs"
+)
+
+const (
+	defaultSuggestions = 10
+	maxSuggestions     = 25
+
+	// minSuggestSimilarity is the trigram similarity
+	// below which the names are not suggested.
+	minSuggestSimilarity = 0.3
+)
+
+// Suggestion is a taxonomy suggested for a partial or misspelt name.
+type Suggestion struct {
+	Kind  string  `json:"kind"`
+	Name  string  `json:"name"`
+	Slug  string  `json:"slug"`
+	Count int64   `json:"count"`
+	Score float64 `json:"score"`
+}
+
+type suggestPrefix struct {
+	Key   string
+	Entry int32
+	// Whole is set when the key is the whole slug rather than one of its words.
+	Whole bool
+}
+
+// suggestIndex is an immutable in-memory index of the names of every
+// taxonomy that has archives, searched by prefix and by trigrams.
+type suggestIndex struct {
+	Entries  []*Suggestion
+	Prefixes []suggestPrefix
+	Trigrams map[string][]int32
+	Sizes    []int
+
+	// Slugs maps the slugs of every kind to their entry.
+	Slugs map[string]map[string]int32
+}
+
+// rawSqlSuggestion lists the taxonomies of a kind that have archives along
+// with their count, the kind being told apart by its index in the union.
+const rawSqlSuggestion = `SELECT %[1]d, %[2]s, %[3]s.slug, COUNT(*) FROM %[3]s
+	INNER JOIN %[4]s ON %[4]s.%[5]s = %[3]s.id WHERE TRUE%[6]s GROUP BY %[3]s.id`
+
+var suggestions struct {
+	value atomic.Value
+	sync.Mutex
+}
+
+// slugWords splits a slug into its words, the namespace of the tags included.
+func slugWords(slug string) []string {
+	return strings.FieldsFunc(slug, func(r rune) bool {
+		return r == '-' || r == ':'
+	})
+}
+
+// slugTrigrams returns the distinct trigrams of the words of a slug,
+// padded like pg_trgm does so that short words have some as well.
+func slugTrigrams(slug string) []string {
+	seen := make(map[string]bool)
+	var trigrams []string
+	for _, word := range slugWords(slug) {
+		r := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			t := string(r[i : i+3])
+			if !seen[t] {
+				seen[t] = true
+				trigrams = append(trigrams, t)
+			}
+		}
+	}
+	return trigrams
+}
+
+func buildSuggestIndex() (*suggestIndex, error) {
+	tables := getTaxonomyTables()
+	parts := make([]string, len(tables))
+	var args []any
+	for i, t := range tables {
+		where, whereArgs := t.where()
+		parts[i] = fmt.Sprintf(rawSqlSuggestion, i, t.name(), t.Table, t.JoinTable, t.Column, where)
+		args = append(args, whereArgs...)
+	}
+
+	index := &suggestIndex{
+		Trigrams: make(map[string][]int32),
+		Slugs:    make(map[string]map[string]int32),
+	}
+	if len(parts) == 0 {
+		return index, nil
+	}
+
+	rows, err := database.Conn.Query(bindVars(strings.Join(parts, " UNION ALL ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind int
+		s := &Suggestion{}
+		if err := rows.Scan(&kind, &s.Name, &s.Slug, &s.Count); err != nil {
+			return nil, err
+		}
+		if kind < 0 || kind >= len(tables) {
+			continue
+		}
+		s.Kind = tables[kind].Kind
+
+		i := int32(len(index.Entries))
+		index.Entries = append(index.Entries, s)
+		if index.Slugs[s.Kind] == nil {
+			index.Slugs[s.Kind] = make(map[string]int32)
+		}
+		index.Slugs[s.Kind][s.Slug] = i
+		index.Prefixes = append(index.Prefixes, suggestPrefix{Key: s.Slug, Entry: i, Whole: true})
+
+		if words := slugWords(s.Slug); len(words) > 1 {
+			for _, word := range words[1:] {
+				index.Prefixes = append(index.Prefixes, suggestPrefix{Key: word, Entry: i})
+			}
+		}
+
+		trigrams := slugTrigrams(s.Slug)
+		for _, t := range trigrams {
+			index.Trigrams[t] = append(index.Trigrams[t], i)
+		}
+		index.Sizes = append(index.Sizes, len(trigrams))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(index.Prefixes, func(i, j int) bool {
+		return index.Prefixes[i].Key < index.Prefixes[j].Key
+	})
+	return index, nil
+}
+
+// getSuggestIndex returns the index, which is built on first use
+// after having been invalidated by a change of the taxonomies.
+func getSuggestIndex() (*suggestIndex, error) {
+	if v, ok := suggestions.value.Load().(*suggestIndex); ok && v != nil {
+		return v, nil
+	}
+
+	suggestions.Lock()
+	defer suggestions.Unlock()
+
+	if v, ok := suggestions.value.Load().(*suggestIndex); ok && v != nil {
+		return v, nil
+	}
+
+	index, err := buildSuggestIndex()
+	if err != nil {
+		return nil, err
+	}
+	suggestions.value.Store(index)
+	return index, nil
+}
+
+// invalidateSuggestions drops the index, to be rebuilt on next use.
+func invalidateSuggestions() {
+	suggestions.value.Store((*suggestIndex)(nil))
+}
+
+// search scores the entries of the kind, every kind if empty, matching
+// the slug: exactly, by a prefix of the whole slug or of one of its
+// words, or by trigram similarity, which tolerates typos.
+func (index *suggestIndex) search(kind, slug string, minSimilarity float64) map[int32]float64 {
+	scores := make(map[int32]float64)
+	add := func(i int32, score float64) {
+		if len(kind) > 0 && index.Entries[i].Kind != kind {
+			return
+		}
+		if score > scores[i] {
+			scores[i] = score
+		}
+	}
+
+	start := sort.Search(len(index.Prefixes), func(i int) bool {
+		return index.Prefixes[i].Key >= slug
+	})
+	for i := start; i < len(index.Prefixes) && strings.HasPrefix(index.Prefixes[i].Key, slug); i++ {
+		p := index.Prefixes[i]
+		switch {
+		case p.Whole && p.Key == slug:
+			add(p.Entry, 3)
+		case p.Whole:
+			add(p.Entry, 2)
+		default:
+			add(p.Entry, 1.5)
+		}
+	}
+
+	trigrams := slugTrigrams(slug)
+	hits := make(map[int32]int)
+	for _, t := range trigrams {
+		for _, i := range index.Trigrams[t] {
+			hits[i]++
+		}
+	}
+
+	for i, n := range hits {
+		similarity := float64(n) / float64(len(trigrams)+index.Sizes[i]-n)
+		if similarity >= minSimilarity {
+			add(i, similarity)
+		}
+	}
+	return scores
+}
+
+// top returns the n best scored entries, the most used first on ties.
+func (index *suggestIndex) top(scores map[int32]float64, n int) []*Suggestion {
+	ids := make([]int32, 0, len(scores))
+	for i := range scores {
+		ids = append(ids, i)
+	}
+
+	sort.Slice(ids, func(a, b int) bool {
+		x, y := index.Entries[ids[a]], index.Entries[ids[b]]
+		if scores[ids[a]] != scores[ids[b]] {
+			return scores[ids[a]] > scores[ids[b]]
+		} else if x.Count != y.Count {
+			return x.Count > y.Count
+		}
+		return x.Slug < y.Slug
+	})
+
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+
+	result := make([]*Suggestion, len(ids))
+	for j, i := range ids {
+		s := *index.Entries[i]
+		s.Score = scores[i]
+		result[j] = &s
+	}
+	return result
+}
+
+// GetSuggestions returns the taxonomies whose name starts with or is
+// similar to the query, restricted to a kind unless empty, ranked by
+// how well they match and then by their number of archives.
+func GetSuggestions(query, kind string, limit int) ([]*Suggestion, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if len(kind) > 0 {
+		if _, ok := GetTaxonomyKinds().Get(kind); !ok {
+			return nil, errs.TaxonomyKindInvalid
+		}
+	}
+
+	if limit <= 0 {
+		limit = defaultSuggestions
+	}
+	limit = Min(limit, maxSuggestions)
+
+	slug := TagSlug(query)
+	if len(slug) == 0 {
+		return []*Suggestion{}, nil
+	}
+
+	index, err := getSuggestIndex()
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return index.top(index.search(kind, slug, minSuggestSimilarity), limit), nil
+}
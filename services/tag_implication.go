@@ -0,0 +1,179 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/modext"
+)
+
+type ImplicationSet struct {
+	// Map maps the slug of a tag to the names of the tags it implies.
+	Map map[string][]string
+}
+
+var implications struct {
+	value atomic.Value
+	once  sync.Once
+}
+
+// GetImplications returns the currently loaded tag implications.
+// The returned set must be treated as read-only, it is
+// replaced as a whole whenever the implications are reloaded.
+func GetImplications() *ImplicationSet {
+	if v, ok := implications.value.Load().(*ImplicationSet); ok {
+		return v
+	}
+	return &ImplicationSet{Map: make(map[string][]string)}
+}
+
+func InitImplications() {
+	implications.once.Do(func() {
+		if err := ReloadImplications(); err != nil {
+			log.Println(err)
+			implications.value.Store(&ImplicationSet{Map: make(map[string][]string)})
+		}
+	})
+}
+
+// ReloadImplications reads the tag implications from the database
+// and atomically swaps them with the currently loaded implications.
+func ReloadImplications() error {
+	rows, err := database.Conn.Query(`SELECT slug, implied FROM tag_implication ORDER BY id ASC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	set := &ImplicationSet{Map: make(map[string][]string)}
+	for rows.Next() {
+		var slug, implied string
+		if err := rows.Scan(&slug, &implied); err != nil {
+			return err
+		}
+		set.Map[slug] = append(set.Map[slug], implied)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	implications.value.Store(set)
+	return nil
+}
+
+// Apply returns the tags along with every tag they imply, directly
+// or transitively. Tags are compared by slug and never duplicated.
+func (set *ImplicationSet) Apply(tags []string) []string {
+	if len(set.Map) == 0 {
+		return tags
+	}
+
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		seen[TagSlug(tag)] = true
+	}
+
+	result := append([]string{}, tags...)
+	for i := 0; i < len(result); i++ {
+		for _, implied := range set.Map[TagSlug(result[i])] {
+			if slug := TagSlug(implied); !seen[slug] {
+				seen[slug] = true
+				result = append(result, implied)
+			}
+		}
+	}
+	return result
+}
+
+const tagImplicationCols = `id, EXTRACT(EPOCH FROM created_at)::BIGINT, slug, name, implied_slug, implied`
+
+func scanTagImplication(row interface{ Scan(...any) error }) (*modext.TagImplication, error) {
+	v := &modext.TagImplication{}
+	if err := row.Scan(&v.ID, &v.CreatedAt, &v.Slug, &v.Name, &v.ImpliedSlug, &v.Implied); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// CreateTagImplication makes the tag imply the implied tag,
+// both of which can be in the format of namespace:name.
+func CreateTagImplication(tag, implied string) (*modext.TagImplication, error) {
+	tag, implied = strings.TrimSpace(tag), strings.TrimSpace(implied)
+	slug, impliedSlug := TagSlug(tag), TagSlug(implied)
+
+	if len(slug) == 0 || len(impliedSlug) == 0 {
+		return nil, errs.ImplicationTagRequired
+	} else if len(tag) > 128 || len(implied) > 128 {
+		return nil, errs.ImplicationTagTooLong
+	} else if slug == impliedSlug {
+		return nil, errs.ImplicationSelf
+	}
+
+	v, err := scanTagImplication(database.Conn.QueryRow(`INSERT INTO tag_implication
+		(slug, name, implied_slug, implied) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (slug, implied_slug) DO UPDATE SET name = EXCLUDED.name, implied = EXCLUDED.implied
+		RETURNING `+tagImplicationCols, slug, tag, impliedSlug, implied))
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return v, nil
+}
+
+func ListTagImplications() ([]*modext.TagImplication, error) {
+	rows, err := database.Conn.Query(`SELECT ` + tagImplicationCols + ` FROM tag_implication ORDER BY slug ASC, implied_slug ASC`)
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	defer rows.Close()
+
+	result := []*modext.TagImplication{}
+	for rows.Next() {
+		v, err := scanTagImplication(rows)
+		if err != nil {
+			log.Println(err)
+			return nil, errs.Unknown
+		}
+		result = append(result, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return result, nil
+}
+
+func DeleteTagImplication(id int64) error {
+	res, err := database.Conn.Exec(`DELETE FROM tag_implication WHERE id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	} else if n == 0 {
+		return errs.ImplicationNotFound
+	}
+	return nil
+}
+
+// ParseTagImplication parses a tag implication in the format of tag => implied.
+func ParseTagImplication(line string) (tag, implied string, ok bool) {
+	strs := strings.SplitN(line, "=>", 2)
+	if len(strs) < 2 {
+		return
+	}
+
+	tag, implied = strings.TrimSpace(strs[0]), strings.TrimSpace(strs[1])
+	ok = len(tag) > 0 && len(implied) > 0
+	return
+}
@@ -0,0 +1,727 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"koushoku/cache"
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/modext"
+
+	"github.com/pkg/errors"
+)
+
+// taxonomyTable describes where the taxonomies of a kind are stored. The
+// built-in kinds have their own tables, the user-defined kinds share the
+// taxonomy table, where they are told apart by kind.
+type taxonomyTable struct {
+	Kind      string
+	Table     string
+	JoinTable string
+	Column    string
+	Title     bool
+	Indexes   *IndexMap
+
+	NotFound     error
+	NameRequired error
+	NameTooLong  error
+
+	// Related is the weight of the kind when
+	// looking for related archives, if any.
+	Related float64
+
+	// Custom is set for the user-defined kinds.
+	Custom bool
+}
+
+var taxonomyTables = map[string]taxonomyTable{
+	"artist": {
+		Kind: "artist", Table: "artist", JoinTable: "archive_artists", Column: "artist_id",
+		Title: true, Indexes: &IndexMap{Cache: make(map[string]bool)},
+		NotFound: errs.ArtistNotFound, NameRequired: errs.ArtistNameRequired, NameTooLong: errs.ArtistNameTooLong,
+		Related: 4,
+	},
+	"circle": {
+		Kind: "circle", Table: "circle", JoinTable: "archive_circles", Column: "circle_id",
+		Title: true, Indexes: &IndexMap{Cache: make(map[string]bool)},
+		NotFound: errs.CircleNotFound, NameRequired: errs.CircleNameRequired, NameTooLong: errs.CircleNameTooLong,
+		Related: 3,
+	},
+	"magazine": {
+		Kind: "magazine", Table: "magazine", JoinTable: "archive_magazines", Column: "magazine_id",
+		Indexes:  &IndexMap{Cache: make(map[string]bool)},
+		NotFound: errs.MagazineNotFound, NameRequired: errs.MagazineNameRequired, NameTooLong: errs.MagazineNameTooLong,
+	},
+	"parody": {
+		Kind: "parody", Table: "parody", JoinTable: "archive_parodies", Column: "parody_id",
+		Title: true, Indexes: &IndexMap{Cache: make(map[string]bool)},
+		NotFound: errs.ParodyNotFound, NameRequired: errs.ParodyNameRequired, NameTooLong: errs.ParodyNameTooLong,
+		Related: 2,
+	},
+	"tag": {
+		Kind: "tag", Table: "tag", JoinTable: "archive_tags", Column: "tag_id",
+		Title: true, Indexes: &IndexMap{Cache: make(map[string]bool)},
+		NotFound: errs.TagNotFound, NameRequired: errs.TagNameRequired, NameTooLong: errs.TagNameTooLong,
+		Related: 1,
+	},
+}
+
+var customTaxonomyIndexes struct {
+	Map map[string]*IndexMap
+	sync.Mutex
+}
+
+func getCustomTaxonomyIndexes(kind string) *IndexMap {
+	customTaxonomyIndexes.Lock()
+	defer customTaxonomyIndexes.Unlock()
+
+	if customTaxonomyIndexes.Map == nil {
+		customTaxonomyIndexes.Map = make(map[string]*IndexMap)
+	}
+
+	indexes, ok := customTaxonomyIndexes.Map[kind]
+	if !ok {
+		indexes = &IndexMap{Cache: make(map[string]bool)}
+		customTaxonomyIndexes.Map[kind] = indexes
+	}
+	return indexes
+}
+
+// getTaxonomyTable returns the table of a kind, either built in or
+// user-defined, the latter being looked up in the currently loaded kinds.
+func getTaxonomyTable(kind string) (taxonomyTable, bool) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if t, ok := taxonomyTables[kind]; ok {
+		return t, true
+	}
+
+	if !GetTaxonomyKinds().IsCustom(kind) {
+		return taxonomyTable{}, false
+	}
+
+	return taxonomyTable{
+		Kind:         kind,
+		Table:        "taxonomy",
+		JoinTable:    "archive_taxonomies",
+		Column:       "taxonomy_id",
+		Title:        true,
+		Indexes:      getCustomTaxonomyIndexes(kind),
+		NotFound:     errs.TaxonomyNotFound,
+		NameRequired: errs.TaxonomyNameRequired,
+		NameTooLong:  errs.TaxonomyNameTooLong,
+		Related:      1,
+		Custom:       true,
+	}, true
+}
+
+// getTaxonomyTables returns the tables of every currently loaded kind.
+func getTaxonomyTables() []taxonomyTable {
+	kinds := GetTaxonomyKinds().Kinds
+	tables := make([]taxonomyTable, 0, len(kinds))
+	for _, kind := range kinds {
+		if t, ok := getTaxonomyTable(kind.Kind); ok {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// where returns the condition that restricts the table to the kind,
+// to be appended to the other conditions of a query, along with its
+// arguments. The kind of the user-defined kinds is bound as an argument.
+func (t taxonomyTable) where() (string, []any) {
+	if !t.Custom {
+		return "", nil
+	}
+	return " AND taxonomy.kind = ?", []any{t.Kind}
+}
+
+// slug returns the slug of a name of the kind, the slugs
+// of the tags being prefixed with their namespace.
+func (t taxonomyTable) slug(name string) string {
+	if t.Kind == "tag" {
+		return TagSlug(name)
+	}
+	return Slugify(name)
+}
+
+// slugs replaces the names of the kind with their slugs, sorted.
+func (t taxonomyTable) slugs(strs []string) []string {
+	for i, str := range strs {
+		strs[i] = t.slug(str)
+	}
+	sort.Strings(strs)
+	return strs
+}
+
+// name returns the expression of the name of the taxonomies in the
+// listings that mix the kinds, the tags being named with their namespace.
+func (t taxonomyTable) name() string {
+	if t.Kind == "tag" {
+		return "CASE WHEN tag.namespace = '' THEN tag.name ELSE tag.namespace || ':' || tag.name END"
+	}
+	return t.Table + ".name"
+}
+
+// match returns the semi-join matching the archives that have a taxonomy
+// of the kind whose slug is slug, or contains it if wildcard is set. The
+// semi-join uses the (archive_id, x_id) primary key of the join table and
+// the unique slug index instead of counting the rows of a join.
+func (t taxonomyTable) match(slug string, wildcard bool) (string, []any) {
+	cmp := "= ?"
+	if wildcard {
+		cmp = "ILIKE '%' || ? || '%'"
+	}
+
+	where, args := t.where()
+	return fmt.Sprintf(`EXISTS (
+	SELECT 1 FROM %[1]s
+	INNER JOIN %[2]s ON %[2]s.id = %[1]s.%[3]s
+	WHERE %[1]s.archive_id = archive.id%[4]s AND %[2]s.slug %[5]s
+)`, t.JoinTable, t.Table, t.Column, where, cmp), append(args, slug)
+}
+
+// MergeTaxonomy moves every archive of the taxonomy from into the taxonomy into,
+// deletes from and stores its slug so that it can be redirected. If into does
+// not exist yet, from is renamed instead. The tag implications, aliases and
+// blacklist rules on from follow it. Everything runs in one transaction.
+func MergeTaxonomy(kind, from, into string) error {
+	t, ok := getTaxonomyTable(kind)
+	if !ok {
+		return errs.TaxonomyKindInvalid
+	}
+
+	kind = t.Kind
+	into = strings.TrimSpace(into)
+	fromSlug, intoSlug := t.slug(from), t.slug(into)
+
+	var namespace string
+	if kind == "tag" {
+		namespace, into = ParseTagName(into)
+	}
+
+	if t.Title {
+		into = strings.Title(into)
+	}
+
+	if len(fromSlug) == 0 || len(intoSlug) == 0 {
+		return errs.TaxonomyMergeRequired
+	} else if fromSlug == intoSlug {
+		return errs.TaxonomyMergeSame
+	}
+
+	tx, err := database.Conn.Begin()
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
+	err = func() error {
+		where, args := t.where()
+		q := bindVars(fmt.Sprintf(`SELECT id FROM %s WHERE slug = ?%s`, t.Table, where))
+
+		var fromId, intoId int64
+		err := tx.QueryRow(q, append([]any{fromSlug}, args...)...).Scan(&fromId)
+		if err == sql.ErrNoRows {
+			return t.NotFound
+		} else if err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(q, append([]any{intoSlug}, args...)...).Scan(&intoId)
+		if err == sql.ErrNoRows {
+			if kind == "tag" {
+				_, err = tx.Exec(`UPDATE tag SET name = $1, slug = $2, namespace = $3 WHERE id = $4`, into, intoSlug, namespace, fromId)
+			} else {
+				_, err = tx.Exec(fmt.Sprintf(`UPDATE %s SET name = $1, slug = $2 WHERE id = $3`, t.Table), into, intoSlug, fromId)
+			}
+			if err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else {
+			_, err = tx.Exec(fmt.Sprintf(`INSERT INTO %[1]s (archive_id, %[2]s)
+				SELECT archive_id, $1 FROM %[1]s WHERE %[2]s = $2
+				ON CONFLICT DO NOTHING`, t.JoinTable, t.Column), intoId, fromId)
+			if err != nil {
+				return err
+			}
+
+			if _, err = tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, t.Table), fromId); err != nil {
+				return err
+			}
+		}
+
+		// Renamed or merged, the taxonomy now lives at either id.
+		targetId := intoId
+		if targetId == 0 {
+			targetId = fromId
+		}
+
+		err = refreshArchiveSearch(tx, fmt.Sprintf(`a.id IN (SELECT archive_id FROM %s WHERE %s = $1)`,
+			t.JoinTable, t.Column), targetId)
+		if err != nil {
+			return err
+		}
+
+		if isProfileKindValid(kind) {
+			// The profile follows the taxonomy, unless the one
+			// merged into already has a profile of its own.
+			_, err = tx.Exec(`UPDATE taxonomy_profile SET slug = $1, updated_at = NOW()
+				WHERE kind = $2 AND slug = $3 AND NOT EXISTS
+				(SELECT 1 FROM taxonomy_profile WHERE kind = $2 AND slug = $1)`, intoSlug, kind, fromSlug)
+			if err != nil {
+				return err
+			}
+
+			if _, err = tx.Exec(`DELETE FROM taxonomy_profile WHERE kind = $1 AND slug = $2`, kind, fromSlug); err != nil {
+				return err
+			}
+		}
+
+		if _, err = tx.Exec(`DELETE FROM taxonomy_redirect WHERE kind = $1 AND slug = $2`, kind, intoSlug); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(`UPDATE taxonomy_redirect SET target = $1 WHERE kind = $2 AND target = $3`, intoSlug, kind, fromSlug); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO taxonomy_redirect (kind, slug, target) VALUES ($1, $2, $3)
+			ON CONFLICT (kind, slug) DO UPDATE SET target = EXCLUDED.target, created_at = NOW()`, kind, fromSlug, intoSlug)
+		if err != nil {
+			return err
+		}
+
+		intoName := into
+		if len(namespace) > 0 {
+			intoName = namespace + ":" + into
+		}
+		return mergeTaxonomyLists(tx, t, fromSlug, intoSlug, intoName)
+	}()
+
+	if err == nil {
+		err = tx.Commit()
+	} else {
+		tx.Rollback()
+	}
+
+	if err != nil {
+		if err == t.NotFound {
+			return err
+		}
+		log.Println(errors.WithStack(err))
+		return errs.Unknown
+	}
+
+	for _, reload := range []func() error{ReloadAliases, ReloadBlacklists, ReloadImplications} {
+		if err := reload(); err != nil {
+			log.Println(err)
+		}
+	}
+
+	PurgeTaxonomies()
+	PurgeArchivesResults()
+	emitEvent(EventTaxonomyMerged, &TaxonomyMergeEvent{Kind: kind, From: fromSlug, Into: intoSlug, Name: into})
+	return nil
+}
+
+// mergeTaxonomyLists points the tag implications, the aliases and the
+// blacklist rules on the taxonomy from at the taxonomy into, so that
+// they keep applying once from is gone. Those that would duplicate
+// one on into are removed instead.
+func mergeTaxonomyLists(tx *sql.Tx, t taxonomyTable, fromSlug, intoSlug, intoName string) error {
+	if t.Kind == "tag" {
+		if err := mergeTagImplications(tx, fromSlug, intoSlug, intoName); err != nil {
+			return err
+		}
+	}
+
+	if isAliasKindValid(t.Kind) {
+		if err := mergeAliases(tx, t, fromSlug, intoSlug, intoName); err != nil {
+			return err
+		}
+	}
+	return mergeBlacklistRules(tx, t.Kind, fromSlug, intoSlug)
+}
+
+func mergeTagImplications(tx *sql.Tx, fromSlug, intoSlug, intoName string) error {
+	_, err := tx.Exec(`DELETE FROM tag_implication WHERE slug = $1
+		AND implied_slug IN (SELECT implied_slug FROM tag_implication WHERE slug = $2)`, fromSlug, intoSlug)
+	if err == nil {
+		_, err = tx.Exec(`UPDATE tag_implication SET slug = $2, name = $3 WHERE slug = $1`, fromSlug, intoSlug, intoName)
+	}
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM tag_implication WHERE implied_slug = $1
+			AND slug IN (SELECT slug FROM tag_implication WHERE implied_slug = $2)`, fromSlug, intoSlug)
+	}
+	if err == nil {
+		_, err = tx.Exec(`UPDATE tag_implication SET implied_slug = $2, implied = $3 WHERE implied_slug = $1`,
+			fromSlug, intoSlug, intoName)
+	}
+	if err == nil {
+		// An implication between from and into now implies into itself.
+		_, err = tx.Exec(`DELETE FROM tag_implication WHERE slug = $1 AND implied_slug = $1`, intoSlug)
+	}
+	return err
+}
+
+// mergeAliases points the aliases targeting from at into, and removes
+// the alias of into, if any, which would now target into itself.
+func mergeAliases(tx *sql.Tx, t taxonomyTable, fromSlug, intoSlug, intoName string) error {
+	rows, err := tx.Query(`SELECT id, slug, target FROM alias WHERE kind = $1 AND deleted_at IS NULL`, t.Kind)
+	if err != nil {
+		return err
+	}
+
+	var updated, deleted []int64
+	for rows.Next() {
+		var id int64
+		var slug, target string
+		if err := rows.Scan(&id, &slug, &target); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if t.slug(target) != fromSlug {
+			continue
+		} else if slug == intoSlug {
+			deleted = append(deleted, id)
+		} else {
+			updated = append(updated, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range updated {
+		if _, err := tx.Exec(`UPDATE alias SET target = $2 WHERE id = $1`, id, intoName); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range deleted {
+		if _, err := tx.Exec(`UPDATE alias SET deleted_at = NOW() WHERE id = $1`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeBlacklistRules rewrites the rules with conditions on from to
+// match into, removing those that then duplicate an existing rule.
+func mergeBlacklistRules(tx *sql.Tx, kind, fromSlug, intoSlug string) error {
+	rows, err := tx.Query(`SELECT id, kind, value FROM blacklist_rule
+		WHERE kind IN ($1, 'rule') AND deleted_at IS NULL`, kind)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	var values []string
+	for rows.Next() {
+		var id int64
+		var ruleKind, value string
+		if err := rows.Scan(&id, &ruleKind, &value); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if value, ok := renameBlacklistRule(ruleKind, value, kind, fromSlug, intoSlug); ok {
+			ids, values = append(ids, id), append(values, value)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		res, err := tx.Exec(`UPDATE blacklist_rule SET value = $2 WHERE id = $1 AND NOT EXISTS
+			(SELECT 1 FROM blacklist_rule r WHERE r.kind = blacklist_rule.kind
+				AND r.value = $2 AND r.deleted_at IS NULL)`, id, values[i])
+		if err != nil {
+			return err
+		}
+
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			if _, err := tx.Exec(`UPDATE blacklist_rule SET deleted_at = NOW() WHERE id = $1`, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetTaxonomyRedirect returns the slug a merged or renamed taxonomy now lives at.
+func GetTaxonomyRedirect(kind, slug string) (string, bool) {
+	cacheKey := fmt.Sprintf("%s:%s", kind, slug)
+	if c, err := cache.Taxonomies.GetWithPrefix("redirect", cacheKey); err == nil {
+		target := c.(string)
+		return target, len(target) > 0
+	}
+
+	if kind == "tag" {
+		slug = TagSlug(slug)
+	} else {
+		slug = Slugify(slug)
+	}
+
+	var target string
+	err := database.Conn.QueryRow(`SELECT target FROM taxonomy_redirect WHERE kind = $1 AND slug = $2`,
+		kind, slug).Scan(&target)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		return "", false
+	}
+
+	cache.Taxonomies.SetWithPrefix("redirect", cacheKey, target, 0)
+	return target, len(target) > 0
+}
+
+// PurgeTaxonomies purges the taxonomies cache along
+// with the indexes used to validate the search queries.
+func PurgeTaxonomies() {
+	cache.Taxonomies.Purge()
+	for _, t := range taxonomyTables {
+		t.Indexes.Clear()
+	}
+
+	customTaxonomyIndexes.Lock()
+	customTaxonomyIndexes.Map = nil
+	customTaxonomyIndexes.Unlock()
+	invalidateSuggestions()
+
+	relsCache.Lock()
+	relsCache.Taxonomies = make(map[string]*modext.Taxonomy)
+	relsCache.Unlock()
+}
+
+// CreateTaxonomy creates a taxonomy of the kind, or returns the existing
+// one with the same slug. The names of the tags may be prefixed with their
+// namespace, in the format of namespace:name.
+func CreateTaxonomy(kind, name string) (*modext.Taxonomy, error) {
+	t, ok := getTaxonomyTable(kind)
+	if !ok {
+		return nil, errs.TaxonomyKindInvalid
+	}
+
+	var namespace string
+	if t.Kind == "tag" {
+		namespace, name = ParseTagName(name)
+	} else {
+		name = strings.TrimSpace(name)
+	}
+
+	if t.Title {
+		name = strings.Title(name)
+	}
+
+	if len(name) == 0 {
+		return nil, t.NameRequired
+	} else if len(name) > 128 {
+		return nil, t.NameTooLong
+	} else if len(namespace) > 32 {
+		return nil, errs.TagNamespaceTooLong
+	}
+
+	slug := Slugify(name)
+	if len(namespace) > 0 {
+		slug = namespace + ":" + slug
+	}
+
+	cols, conflict := "slug, name", "slug"
+	args := []any{slug, name}
+	if t.Custom {
+		cols, conflict = cols+", kind", "kind, slug"
+		args = append(args, t.Kind)
+	} else if t.Kind == "tag" {
+		// The namespace is not part of the generated model.
+		cols += ", namespace"
+		args = append(args, namespace)
+	}
+
+	var id int64
+	err := database.Conn.QueryRow(bindVars(fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)
+		ON CONFLICT (%s) DO UPDATE SET slug = EXCLUDED.slug RETURNING id, slug, name`,
+		t.Table, cols, strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", "), conflict)), args...).
+		Scan(&id, &slug, &name)
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return modext.NewTaxonomy(t.Kind, id, slug, name), nil
+}
+
+// GetTaxonomy returns the taxonomy of the kind with the given slug.
+func GetTaxonomy(kind, slug string) (*modext.Taxonomy, error) {
+	t, ok := getTaxonomyTable(kind)
+	if !ok {
+		return nil, errs.TaxonomyKindInvalid
+	}
+
+	where, args := t.where()
+	var id int64
+	var name string
+	err := database.Conn.QueryRow(bindVars(fmt.Sprintf(`SELECT id, slug, name FROM %s WHERE slug = ?%s`, t.Table, where)),
+		append([]any{slug}, args...)...).Scan(&id, &slug, &name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, t.NotFound
+		}
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return modext.NewTaxonomy(t.Kind, id, slug, name), nil
+}
+
+type GetTaxonomiesOptions struct {
+	Limit  int `json:"1,omitempty"`
+	Offset int `json:"2,omitempty"`
+	TaxonomyListOptions
+}
+
+type GetTaxonomiesResult struct {
+	Taxonomies []*modext.Taxonomy
+	Total      int
+	Err        error
+}
+
+// GetTaxonomies returns the taxonomies of the kind that have
+// at least one archive, along with their archive count.
+func GetTaxonomies(kind string, opts GetTaxonomiesOptions) (result *GetTaxonomiesResult) {
+	opts.Limit = Max(opts.Limit, 0)
+	opts.Offset = Max(opts.Offset, 0)
+	opts.TaxonomyListOptions.Validate()
+	kind = strings.ToLower(kind)
+
+	prefix := "taxonomies:" + kind
+	cacheKey := makeCacheKey(opts)
+	if c, err := cache.Taxonomies.GetWithPrefix(prefix, cacheKey); err == nil {
+		return c.(*GetTaxonomiesResult)
+	}
+
+	result = &GetTaxonomiesResult{Taxonomies: []*modext.Taxonomy{}}
+	t, ok := getTaxonomyTable(kind)
+	if !ok {
+		result.Err = errs.TaxonomyKindInvalid
+		return
+	}
+
+	defer func() {
+		if len(result.Taxonomies) > 0 || result.Total > 0 || result.Err != nil {
+			cache.Taxonomies.RemoveWithPrefix(prefix, cacheKey)
+			cache.Taxonomies.SetWithPrefix(prefix, cacheKey, result, 0)
+		}
+	}()
+
+	where, args := opts.where(t)
+	q := fmt.Sprintf(`SELECT %[1]s.id, %[1]s.slug, %[1]s.name, COUNT(archive.%[2]s) AS archive_count
+		FROM %[1]s INNER JOIN %[3]s archive ON archive.%[2]s = %[1]s.id
+		WHERE %[4]s GROUP BY %[1]s.id ORDER BY %[5]s`, t.Table, t.Column, t.JoinTable, where, opts.orderBy(t))
+	countArgs := args
+
+	if opts.Limit > 0 {
+		q += ` LIMIT ? OFFSET ?`
+		args = append(args, opts.Limit, opts.Offset)
+	}
+
+	rows, err := database.Conn.Query(bindVars(q), args...)
+	if err != nil {
+		log.Println(err)
+		result.Err = errs.Unknown
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, count int64
+		var slug, name string
+		if err := rows.Scan(&id, &slug, &name, &count); err != nil {
+			log.Println(err)
+			result.Err = errs.Unknown
+			return
+		}
+
+		taxonomy := modext.NewTaxonomy(t.Kind, id, slug, name)
+		taxonomy.Count = count
+		result.Taxonomies = append(result.Taxonomies, taxonomy)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		result.Err = errs.Unknown
+		return
+	}
+
+	err = database.Conn.QueryRow(bindVars(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", t.Table, where)),
+		countArgs...).Scan(&result.Total)
+	if err != nil {
+		log.Println(err)
+		result.Err = errs.Unknown
+	}
+	return
+}
+
+// GetTaxonomyCount returns the number of taxonomies of the kind.
+func GetTaxonomyCount(kind string) (int64, error) {
+	t, ok := getTaxonomyTable(kind)
+	if !ok {
+		return 0, errs.TaxonomyKindInvalid
+	}
+
+	cacheKey := "taxonomyCount:" + t.Kind
+	if c, err := cache.Taxonomies.Get(cacheKey); err == nil {
+		return c.(int64), nil
+	}
+
+	where, args := t.where()
+	var count int64
+	err := database.Conn.QueryRow(bindVars(fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE TRUE%s", t.Table, where)),
+		args...).Scan(&count)
+	if err != nil {
+		log.Println(err)
+		return 0, errs.Unknown
+	}
+
+	cache.Taxonomies.Set(cacheKey, count, 0)
+	return count, nil
+}
+
+// IsTaxonomyValid returns whether a taxonomy of the kind
+// with the given name exists and has archives.
+func IsTaxonomyValid(kind, str string) (isValid bool) {
+	t, ok := getTaxonomyTable(kind)
+	if !ok {
+		return
+	}
+
+	str = t.slug(str)
+	if v, ok := t.Indexes.Get(str); ok {
+		return v
+	}
+
+	result := GetTaxonomies(t.Kind, GetTaxonomiesOptions{})
+	if result.Err != nil {
+		return
+	}
+
+	defer t.Indexes.Add(str, isValid)
+	for _, taxonomy := range result.Taxonomies {
+		if taxonomy.Slug == str {
+			isValid = true
+			break
+		}
+	}
+	return
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	. "koushoku/config"
+
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/modext"
+)
+
+// builtinTaxonomyKinds are the kinds backed by their own tables.
+var builtinTaxonomyKinds = []*modext.TaxonomyKind{
+	{Kind: "artist", Name: "Artist", Plural: "Artists", Route: "artists", Builtin: true},
+	{Kind: "circle", Name: "Circle", Plural: "Circles", Route: "circles", Builtin: true},
+	{Kind: "magazine", Name: "Magazine", Plural: "Magazines", Route: "magazines", Builtin: true},
+	{Kind: "parody", Name: "Parody", Plural: "Parodies", Route: "parodies", Builtin: true},
+	{Kind: "tag", Name: "Tag", Plural: "Tags", Route: "tags", Builtin: true},
+}
+
+// reservedTaxonomyKinds cannot be used as kinds since
+// they already have a meaning in the search queries.
+var reservedTaxonomyKinds = []string{
+	"title", "pages", "path", "rule", "size", "created", "published",
+	"has", "source", "submission", "expunged", "redirected",
+}
+
+// reservedTaxonomyRoutes cannot be used as routes of kinds.
+var reservedTaxonomyRoutes = []string{
+	"about", "api", "archive", "opds", "random", "search",
+	"stats", "submit", "submissions", "js", "css", "fonts",
+}
+
+type TaxonomyKindSet struct {
+	Kinds []*modext.TaxonomyKind
+
+	byKind  map[string]*modext.TaxonomyKind
+	byRoute map[string]*modext.TaxonomyKind
+}
+
+func newTaxonomyKindSet() *TaxonomyKindSet {
+	set := &TaxonomyKindSet{
+		byKind:  make(map[string]*modext.TaxonomyKind),
+		byRoute: make(map[string]*modext.TaxonomyKind),
+	}
+	for _, kind := range builtinTaxonomyKinds {
+		set.add(kind)
+	}
+	return set
+}
+
+func (set *TaxonomyKindSet) add(kind *modext.TaxonomyKind) bool {
+	if _, ok := set.byKind[kind.Kind]; ok {
+		return false
+	} else if _, ok := set.byRoute[kind.Route]; ok {
+		return false
+	}
+
+	set.Kinds = append(set.Kinds, kind)
+	set.byKind[kind.Kind] = kind
+	set.byRoute[kind.Route] = kind
+	return true
+}
+
+// Get returns the kind, either built in or user-defined.
+func (set *TaxonomyKindSet) Get(kind string) (*modext.TaxonomyKind, bool) {
+	v, ok := set.byKind[strings.ToLower(kind)]
+	return v, ok
+}
+
+// GetByRoute returns the kind listed under the route, e.g. characters.
+func (set *TaxonomyKindSet) GetByRoute(route string) (*modext.TaxonomyKind, bool) {
+	v, ok := set.byRoute[strings.ToLower(route)]
+	return v, ok
+}
+
+// IsCustom returns whether the kind is a user-defined kind,
+// which is stored in the generic taxonomy table.
+func (set *TaxonomyKindSet) IsCustom(kind string) bool {
+	v, ok := set.Get(kind)
+	return ok && !v.Builtin
+}
+
+// Custom returns the user-defined kinds.
+func (set *TaxonomyKindSet) Custom() (kinds []*modext.TaxonomyKind) {
+	for _, kind := range set.Kinds {
+		if !kind.Builtin {
+			kinds = append(kinds, kind)
+		}
+	}
+	return
+}
+
+var taxonomyKinds struct {
+	value atomic.Value
+	once  sync.Once
+}
+
+// GetTaxonomyKinds returns the currently loaded taxonomy kinds.
+// The returned set must be treated as read-only, it is
+// replaced as a whole whenever the kinds are reloaded.
+func GetTaxonomyKinds() *TaxonomyKindSet {
+	if v, ok := taxonomyKinds.value.Load().(*TaxonomyKindSet); ok {
+		return v
+	}
+	return newTaxonomyKindSet()
+}
+
+func InitTaxonomyKinds() {
+	taxonomyKinds.once.Do(func() {
+		if err := ReloadTaxonomyKinds(); err != nil {
+			log.Println(err)
+			taxonomyKinds.value.Store(newTaxonomyKindSet())
+		}
+	})
+}
+
+// ReloadTaxonomyKinds reads the taxonomy kinds from the config and the database
+// and atomically swaps them with the currently loaded kinds.
+func ReloadTaxonomyKinds() error {
+	set, err := loadTaxonomyKinds()
+	if err != nil {
+		return err
+	}
+	taxonomyKinds.value.Store(set)
+	return nil
+}
+
+func loadTaxonomyKinds() (*TaxonomyKindSet, error) {
+	set := newTaxonomyKindSet()
+	for _, v := range Config.Taxonomies {
+		kind, err := newTaxonomyKind(v.Kind, v.Name, v.Plural)
+		if err != nil {
+			log.Printf("Skipping taxonomy kind %q: %s\n", v.Kind, err)
+			continue
+		}
+		if !set.add(kind) {
+			log.Printf("Skipping taxonomy kind %q: already declared\n", v.Kind)
+		}
+	}
+
+	rows, err := database.Conn.Query(`SELECT kind, name, plural FROM taxonomy_kind ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, name, plural string
+		if err := rows.Scan(&kind, &name, &plural); err != nil {
+			return nil, err
+		}
+
+		v, err := newTaxonomyKind(kind, name, plural)
+		if err != nil {
+			log.Printf("Skipping taxonomy kind %q: %s\n", kind, err)
+			continue
+		}
+		if !set.add(v) {
+			log.Printf("Skipping taxonomy kind %q: already declared\n", kind)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func newTaxonomyKind(kind, name, plural string) (*modext.TaxonomyKind, error) {
+	kind = Slugify(kind)
+	name, plural = strings.TrimSpace(name), strings.TrimSpace(plural)
+	if len(plural) == 0 {
+		plural = name + "s"
+	}
+
+	if len(kind) == 0 || len(name) == 0 {
+		return nil, errs.TaxonomyKindRequired
+	} else if len(kind) > 32 {
+		return nil, errs.TaxonomyKindTooLong
+	} else if len(name) > 64 || len(plural) > 64 {
+		return nil, errs.TaxonomyKindNameTooLong
+	}
+
+	route := Slugify(plural)
+	for _, v := range reservedTaxonomyKinds {
+		if v == kind {
+			return nil, errs.TaxonomyKindReserved
+		}
+	}
+	for _, v := range reservedTaxonomyRoutes {
+		if v == route {
+			return nil, errs.TaxonomyKindReserved
+		}
+	}
+
+	return &modext.TaxonomyKind{Kind: kind, Name: name, Plural: plural, Route: route}, nil
+}
+
+// CreateTaxonomyKind declares a taxonomy kind in the database.
+// If no plural is given, it is the name suffixed with an s.
+func CreateTaxonomyKind(kind, name, plural string) (*modext.TaxonomyKind, error) {
+	v, err := newTaxonomyKind(kind, name, plural)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, ok := GetTaxonomyKinds().Get(v.Kind); ok && existing.Builtin {
+		return nil, errs.TaxonomyKindReserved
+	}
+
+	_, err = database.Conn.Exec(`INSERT INTO taxonomy_kind (kind, name, plural) VALUES ($1, $2, $3)
+		ON CONFLICT (kind) DO UPDATE SET name = EXCLUDED.name, plural = EXCLUDED.plural`,
+		v.Kind, v.Name, v.Plural)
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return v, nil
+}
+
+// DeleteTaxonomyKind removes a taxonomy kind declared in the database.
+// The taxonomies of the kind are kept, but are no longer listed or searchable.
+func DeleteTaxonomyKind(kind string) error {
+	if v, ok := GetTaxonomyKinds().Get(kind); ok && v.Builtin {
+		return errs.TaxonomyKindBuiltin
+	}
+
+	res, err := database.Conn.Exec(`DELETE FROM taxonomy_kind WHERE kind = $1`, Slugify(kind))
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	} else if n == 0 {
+		return errs.TaxonomyKindNotFound
+	}
+	return nil
+}
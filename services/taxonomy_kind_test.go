@@ -0,0 +1,23 @@
+package services
+
+import (
+	"testing"
+
+	"koushoku/errs"
+)
+
+func TestNewTaxonomyKindReserved(t *testing.T) {
+	for field := range searchFields {
+		if _, err := newTaxonomyKind(field, "Name", "Plural Names"); err != errs.TaxonomyKindReserved {
+			t.Errorf("%q: got error %v, want %v", field, err, errs.TaxonomyKindReserved)
+		}
+	}
+
+	if _, err := newTaxonomyKind("draw", "Draw", "Random"); err != errs.TaxonomyKindReserved {
+		t.Errorf("random: got error %v, want %v", err, errs.TaxonomyKindReserved)
+	}
+
+	if _, err := newTaxonomyKind("character", "Character", "Characters"); err != nil {
+		t.Errorf("character: unexpected error %v", err)
+	}
+}
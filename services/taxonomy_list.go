@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	TaxonomySortName  = "name"
+	TaxonomySortCount = "count"
+)
+
+// TaxonomyListOptions are the sort and the filters shared by the listings
+// of every taxonomy kind. They are embedded in the options of each listing.
+type TaxonomyListOptions struct {
+	// Sort is either name or count, the number of archives.
+	Sort  string `json:"3,omitempty"`
+	Order string `json:"4,omitempty"`
+
+	// Prefix and Filter keep the taxonomies whose name
+	// starts with or contains them, ignoring the case.
+	Prefix string `json:"5,omitempty"`
+	Filter string `json:"6,omitempty"`
+}
+
+// Validate normalizes the sort and the filters, the names are sorted
+// in ascending order and the counts in descending order by default.
+func (opts *TaxonomyListOptions) Validate() {
+	opts.Prefix = strings.TrimSpace(opts.Prefix)
+	opts.Filter = strings.TrimSpace(opts.Filter)
+
+	if strings.EqualFold(opts.Sort, TaxonomySortCount) {
+		opts.Sort = TaxonomySortCount
+	} else {
+		opts.Sort = TaxonomySortName
+	}
+
+	if strings.EqualFold(opts.Order, orderAsc) {
+		opts.Order = orderAsc
+	} else if strings.EqualFold(opts.Order, orderDesc) {
+		opts.Order = orderDesc
+	} else if opts.Sort == TaxonomySortCount {
+		opts.Order = orderDesc
+	} else {
+		opts.Order = orderAsc
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(str string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(str)
+}
+
+// where returns the condition of the filters on the given table, which
+// also keeps only the taxonomies that have archives, so that the totals
+// match the listings, which inner join the archives.
+func (opts *TaxonomyListOptions) where(t taxonomyTable) (string, []any) {
+	where, args := t.where()
+	conds := []string{fmt.Sprintf("EXISTS (SELECT 1 FROM %[1]s WHERE %[1]s.%[2]s = %[3]s.id)%[4]s",
+		t.JoinTable, t.Column, t.Table, where)}
+
+	if len(opts.Prefix) > 0 {
+		conds = append(conds, fmt.Sprintf(`%s.name ILIKE ? || '%%'`, t.Table))
+		args = append(args, escapeLike(opts.Prefix))
+	}
+	if len(opts.Filter) > 0 {
+		conds = append(conds, fmt.Sprintf(`%s.name ILIKE '%%' || ? || '%%'`, t.Table))
+		args = append(args, escapeLike(opts.Filter))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// orderBy returns the order of the listing of the given table, the
+// names being sorted by namespace first for the tags.
+func (opts *TaxonomyListOptions) orderBy(t taxonomyTable) string {
+	name := fmt.Sprintf("%s.name %s", t.Table, opts.Order)
+	if t.Kind == "tag" {
+		name = fmt.Sprintf("tag.namespace %[1]s, tag.name %[1]s", opts.Order)
+	}
+
+	if opts.Sort == TaxonomySortCount {
+		return fmt.Sprintf("archive_count %s, %s.name ASC", opts.Order, t.Table)
+	}
+	return name
+}
+
+// bindVars numbers the ? placeholders of a query for the raw queries.
+func bindVars(q string) string {
+	return bindVarsAfter(q, 0)
+}
+
+// bindVarsAfter numbers the ? placeholders of a query
+// that follows n arguments that are already numbered.
+func bindVarsAfter(q string, n int) string {
+	var sb strings.Builder
+	for _, c := range q {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&sb, "$%d", n)
+		} else {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,367 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "koushoku/config"
+
+	"koushoku/cache"
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/modext"
+
+	"github.com/pkg/errors"
+)
+
+// ProfileKinds are the taxonomy kinds that can have a profile.
+var ProfileKinds = []string{"artist", "circle", "magazine", "parody"}
+
+const (
+	profileImageWidth  = 320
+	profileImageHeight = 320
+)
+
+func isProfileKindValid(kind string) bool {
+	for _, v := range ProfileKinds {
+		if v == kind {
+			return true
+		}
+	}
+	return false
+}
+
+const taxonomyProfileCols = `kind, slug, EXTRACT(EPOCH FROM updated_at)::BIGINT,
+	description, links, image, alt_names`
+
+func scanTaxonomyProfile(row interface{ Scan(...any) error }) (*modext.TaxonomyProfile, error) {
+	profile := &modext.TaxonomyProfile{}
+
+	var links, altNames string
+	err := row.Scan(&profile.Kind, &profile.Slug, &profile.UpdatedAt,
+		&profile.Description, &links, &profile.Image, &altNames)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(links) > 0 {
+		profile.Links = strings.Split(links, "\n")
+	}
+	if len(altNames) > 0 {
+		profile.AltNames = strings.Split(altNames, "\n")
+	}
+	return profile, nil
+}
+
+// getProfileTaxonomyName returns the name of the taxonomy the profile
+// belongs to, so that profiles cannot be created for taxonomies that do not exist.
+func getProfileTaxonomyName(kind, slug string) (string, error) {
+	t, ok := getTaxonomyTable(kind)
+	if !ok || !isProfileKindValid(kind) {
+		return "", errs.ProfileKindInvalid
+	}
+
+	var name string
+	err := database.Conn.QueryRow(fmt.Sprintf(`SELECT name FROM %s WHERE slug = $1`, t.Table), slug).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", t.NotFound
+	} else if err != nil {
+		log.Println(err)
+		return "", errs.Unknown
+	}
+	return name, nil
+}
+
+// ParseTaxonomyProfile parses a profile reference in the format of kind:slug.
+func ParseTaxonomyProfile(str string) (kind, slug string, ok bool) {
+	strs := strings.SplitN(strings.TrimSpace(str), ":", 2)
+	if len(strs) < 2 {
+		return
+	}
+
+	kind = strings.ToLower(strings.TrimSpace(strs[0]))
+	slug = Slugify(strs[1])
+	ok = len(kind) > 0 && len(slug) > 0
+	return
+}
+
+// GetTaxonomyProfile returns the profile of an artist, circle, magazine or parody.
+func GetTaxonomyProfile(kind, slug string) (*modext.TaxonomyProfile, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if !isProfileKindValid(kind) {
+		return nil, errs.ProfileKindInvalid
+	}
+
+	slug = Slugify(slug)
+	cacheKey := fmt.Sprintf("%s:%s", kind, slug)
+	if c, err := cache.Taxonomies.GetWithPrefix("profile", cacheKey); err == nil {
+		if profile := c.(*modext.TaxonomyProfile); profile != nil {
+			return profile, nil
+		}
+		return nil, errs.ProfileNotFound
+	}
+
+	profile, err := scanTaxonomyProfile(database.Conn.QueryRow(`SELECT `+taxonomyProfileCols+`
+		FROM taxonomy_profile WHERE kind = $1 AND slug = $2`, kind, slug))
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+
+	cache.Taxonomies.SetWithPrefix("profile", cacheKey, profile, 0)
+	if profile == nil {
+		return nil, errs.ProfileNotFound
+	}
+	return profile, nil
+}
+
+// TaxonomyProfileUpdate holds the fields of a profile to update,
+// the fields that are nil are left untouched.
+type TaxonomyProfileUpdate struct {
+	Description *string   `json:"description"`
+	Links       *[]string `json:"links"`
+	AltNames    *[]string `json:"altNames"`
+}
+
+func (update *TaxonomyProfileUpdate) validate(slug string) error {
+	if update.Description != nil {
+		description := strings.TrimSpace(*update.Description)
+		if len(description) > 10240 {
+			return errs.ProfileDescriptionTooLong
+		}
+		update.Description = &description
+	}
+
+	if update.Links != nil {
+		links := []string{}
+		for _, link := range *update.Links {
+			if link = strings.TrimSpace(link); len(link) == 0 {
+				continue
+			} else if len(link) > 1024 {
+				return errs.ProfileLinkInvalid
+			}
+
+			u, err := url.Parse(link)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+				return errs.ProfileLinkInvalid
+			}
+			links = append(links, link)
+		}
+
+		if len(links) > 32 {
+			return errs.ProfileLinksTooMany
+		}
+		update.Links = &links
+	}
+
+	if update.AltNames != nil {
+		names := []string{}
+		seen := map[string]bool{slug: true}
+		for _, name := range *update.AltNames {
+			name = strings.TrimSpace(name)
+			if len(name) > 128 {
+				return errs.ProfileAltNameTooLong
+			}
+
+			s := Slugify(name)
+			if len(s) == 0 || seen[s] {
+				continue
+			}
+			seen[s] = true
+			names = append(names, name)
+		}
+
+		if len(names) > 64 {
+			return errs.ProfileAltNamesTooMany
+		}
+		update.AltNames = &names
+	}
+	return nil
+}
+
+// UpdateTaxonomyProfile creates or updates the profile of an artist, circle,
+// magazine or parody. The alternative names act as aliases once reloaded.
+func UpdateTaxonomyProfile(kind, slug string, update TaxonomyProfileUpdate) (*modext.TaxonomyProfile, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	slug = Slugify(slug)
+	if _, err := getProfileTaxonomyName(kind, slug); err != nil {
+		return nil, err
+	}
+
+	if err := update.validate(slug); err != nil {
+		return nil, err
+	}
+
+	profile, err := GetTaxonomyProfile(kind, slug)
+	if err == errs.ProfileNotFound {
+		profile = &modext.TaxonomyProfile{Kind: kind, Slug: slug}
+	} else if err != nil {
+		return nil, err
+	}
+
+	description, links, altNames := profile.Description, profile.Links, profile.AltNames
+	if update.Description != nil {
+		description = *update.Description
+	}
+	if update.Links != nil {
+		links = *update.Links
+	}
+	if update.AltNames != nil {
+		altNames = *update.AltNames
+	}
+
+	profile, err = scanTaxonomyProfile(database.Conn.QueryRow(`INSERT INTO taxonomy_profile
+		(kind, slug, description, links, alt_names) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (kind, slug) DO UPDATE SET updated_at = NOW(), description = EXCLUDED.description,
+		links = EXCLUDED.links, alt_names = EXCLUDED.alt_names RETURNING `+taxonomyProfileCols,
+		kind, slug, description, strings.Join(links, "\n"), strings.Join(altNames, "\n")))
+	if err != nil {
+		log.Println(errors.WithStack(err))
+		return nil, errs.Unknown
+	}
+
+	cache.Taxonomies.RemoveWithPrefix("profile", fmt.Sprintf("%s:%s", kind, slug))
+	return profile, nil
+}
+
+// SetTaxonomyProfileImage resizes the image at the path and stores it as the
+// avatar or cover of the profile, replacing the previous one.
+func SetTaxonomyProfileImage(kind, slug, path string) (*modext.TaxonomyProfile, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	slug = Slugify(slug)
+	if _, err := getProfileTaxonomyName(kind, slug); err != nil {
+		return nil, err
+	}
+
+	var previous string
+	if profile, err := GetTaxonomyProfile(kind, slug); err == nil {
+		previous = profile.Image
+	} else if err != errs.ProfileNotFound {
+		return nil, err
+	}
+
+	// The file name changes along with the image, so that
+	// the old one does not linger in the browser caches.
+	image := fmt.Sprintf("%s-%s-%d.webp", kind, slug, time.Now().Unix())
+	fp := filepath.Join(Config.Directories.Profiles, image)
+
+	opts := ResizeOptions{Width: profileImageWidth, Height: profileImageHeight}
+	if err := ResizeImage(path, fp, opts); err != nil {
+		log.Println(err)
+		return nil, errs.ProfileImageInvalid
+	}
+
+	profile, err := scanTaxonomyProfile(database.Conn.QueryRow(`INSERT INTO taxonomy_profile
+		(kind, slug, image) VALUES ($1, $2, $3) ON CONFLICT (kind, slug)
+		DO UPDATE SET updated_at = NOW(), image = EXCLUDED.image
+		RETURNING `+taxonomyProfileCols, kind, slug, image))
+	if err != nil {
+		os.Remove(fp)
+		log.Println(errors.WithStack(err))
+		return nil, errs.Unknown
+	}
+
+	removeTaxonomyProfileImage(previous)
+	cache.Taxonomies.RemoveWithPrefix("profile", fmt.Sprintf("%s:%s", kind, slug))
+	return profile, nil
+}
+
+// SetTaxonomyProfileImageData is like SetTaxonomyProfileImage,
+// but reads the image from the given bytes.
+func SetTaxonomyProfileImageData(kind, slug string, data []byte) (*modext.TaxonomyProfile, error) {
+	if len(data) == 0 {
+		return nil, errs.ProfileImageInvalid
+	}
+
+	tmp, err := os.CreateTemp("", "profile-")
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return SetTaxonomyProfileImage(kind, slug, tmp.Name())
+}
+
+// RemoveTaxonomyProfileImage removes the avatar or cover of the profile.
+func RemoveTaxonomyProfileImage(kind, slug string) (*modext.TaxonomyProfile, error) {
+	profile, err := GetTaxonomyProfile(kind, slug)
+	if err != nil {
+		return nil, err
+	} else if len(profile.Image) == 0 {
+		return profile, nil
+	}
+
+	previous := profile.Image
+	profile, err = scanTaxonomyProfile(database.Conn.QueryRow(`UPDATE taxonomy_profile
+		SET updated_at = NOW(), image = '' WHERE kind = $1 AND slug = $2
+		RETURNING `+taxonomyProfileCols, profile.Kind, profile.Slug))
+	if err != nil {
+		log.Println(errors.WithStack(err))
+		return nil, errs.Unknown
+	}
+
+	removeTaxonomyProfileImage(previous)
+	cache.Taxonomies.RemoveWithPrefix("profile", fmt.Sprintf("%s:%s", profile.Kind, profile.Slug))
+	return profile, nil
+}
+
+func removeTaxonomyProfileImage(image string) {
+	if len(image) == 0 {
+		return
+	}
+
+	fp := filepath.Join(Config.Directories.Profiles, filepath.Base(image))
+	if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+}
+
+// loadProfileAliases adds the alternative names of the profiles
+// to the aliases, pointing to the name of their taxonomy.
+func loadProfileAliases(set *AliasSet) error {
+	rows, err := database.Conn.Query(`SELECT p.kind, p.alt_names,
+		COALESCE(a.name, c.name, m.name, pa.name) FROM taxonomy_profile p
+		LEFT JOIN artist a ON p.kind = 'artist' AND a.slug = p.slug
+		LEFT JOIN circle c ON p.kind = 'circle' AND c.slug = p.slug
+		LEFT JOIN magazine m ON p.kind = 'magazine' AND m.slug = p.slug
+		LEFT JOIN parody pa ON p.kind = 'parody' AND pa.slug = p.slug
+		WHERE p.alt_names <> ''`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, altNames string
+		var target sql.NullString
+		if err := rows.Scan(&kind, &altNames, &target); err != nil {
+			return err
+		}
+
+		if !target.Valid {
+			continue
+		}
+
+		for _, name := range strings.Split(altNames, "\n") {
+			if slug := Slugify(name); len(slug) > 0 {
+				set.add(kind, slug, target.String)
+			}
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	. "koushoku/config"
+
+	"koushoku/database"
+	"koushoku/errs"
+	"koushoku/modext"
+
+	"github.com/volatiletech/sqlboiler/v4/boil"
+)
+
+// The events posted to the webhooks, along with the archive, the
+// submission or the merged taxonomy they are about as their data.
+const (
+	EventArchiveCreated     = "archive.created"
+	EventArchivePublished   = "archive.published"
+	EventArchiveUnpublished = "archive.unpublished"
+	EventArchiveExpunged    = "archive.expunged"
+	EventArchiveRestored    = "archive.restored"
+	EventArchiveRedirected  = "archive.redirected"
+	EventArchiveDeleted     = "archive.deleted"
+
+	EventSubmissionCreated  = "submission.created"
+	EventSubmissionAccepted = "submission.accepted"
+	EventSubmissionRejected = "submission.rejected"
+
+	EventTaxonomyMerged = "taxonomy.merged"
+)
+
+const (
+	// DeliveryPending deliveries are yet to be attempted, or to be retried.
+	DeliveryPending = "pending"
+	// DeliveryDelivered deliveries have been answered with a 2xx.
+	DeliveryDelivered = "delivered"
+	// DeliveryFailed deliveries have failed every attempt.
+	DeliveryFailed = "failed"
+)
+
+const (
+	webhookMaxAttempts   = 10
+	webhookRetryDelay    = 30 * time.Second
+	webhookMaxRetryDelay = 6 * time.Hour
+	webhookTimeout       = 10 * time.Second
+	webhookBatchSize     = 20
+
+	// webhookLease is how long the other servers leave alone a delivery
+	// that is being attempted. The deliveries of a batch are attempted one
+	// after the other, so it outlasts a batch of deliveries that time out.
+	webhookLease = webhookBatchSize*webhookTimeout + time.Minute
+
+	// webhookInsertSize is how many deliveries are queued per statement,
+	// which keeps their parameters well below the limit of Postgres.
+	webhookInsertSize = 1000
+
+	// deliveryLogDays is how long the deliveries are kept once
+	// they have been delivered or have failed.
+	deliveryLogDays = 30
+)
+
+// The headers of the deliveries. The signature is the hex encoded
+// HMAC-SHA256, with the secret of the webhook, of the timestamp,
+// a dot and the body, e.g. sha256=hmac(secret, "1660000000.{...}").
+const (
+	webhookEventHeader     = "X-Koushoku-Event"
+	webhookDeliveryHeader  = "X-Koushoku-Delivery"
+	webhookTimestampHeader = "X-Koushoku-Timestamp"
+	webhookSignatureHeader = "X-Koushoku-Signature"
+)
+
+// WebhookEvent is the body posted to the webhooks.
+type WebhookEvent struct {
+	Event     string `json:"event"`
+	CreatedAt int64  `json:"createdAt"`
+	Data      any    `json:"data"`
+}
+
+// SubmissionEvent is the data of the submission events, which
+// includes the fields of the submission that are not shown publicly.
+type SubmissionEvent struct {
+	*modext.Submission
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Notes   string `json:"notes,omitempty"`
+}
+
+// TaxonomyMergeEvent is the data of the taxonomy merged event, From and
+// Into being slugs, the taxonomy being renamed if into did not exist.
+type TaxonomyMergeEvent struct {
+	Kind string `json:"kind"`
+	From string `json:"from"`
+	Into string `json:"into"`
+	Name string `json:"name"`
+}
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// webhookWake wakes the deliverer of this process up when an event is emitted.
+var webhookWake = make(chan struct{}, 1)
+
+func newSubmissionEvent(submission *modext.Submission) *SubmissionEvent {
+	return &SubmissionEvent{
+		Submission: submission,
+		Name:       submission.Name,
+		Content:    submission.Content,
+		Notes:      submission.Notes,
+	}
+}
+
+// emitEvent queues the event for every webhook that receives it, to be
+// delivered by the web server. Failing to queue it is only logged, since
+// the change it is about has already been made.
+func emitEvent(event string, data any) {
+	if err := queueEvents(database.Conn, event, data); err != nil {
+		log.Println(err)
+		return
+	}
+	wakeWebhookDeliverer()
+}
+
+// queueEvents queues the event once for every data and every webhook that
+// receives it, with multi-row inserts run by exec, which is the transaction
+// of the change when many archives are changed at once, so that their
+// events are queued along with it.
+func queueEvents(exec boil.Executor, event string, data ...any) error {
+	var webhooks []string
+	for i := range Config.Webhooks {
+		if Config.Webhooks[i].HasEvent(event) {
+			webhooks = append(webhooks, Config.Webhooks[i].Name)
+		}
+	}
+
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	var values []string
+	var args []any
+
+	insert := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		_, err := exec.Exec(`INSERT INTO webhook_delivery (webhook, event, payload) VALUES `+
+			strings.Join(values, ", "), args...)
+		values, args = values[:0], args[:0]
+		return err
+	}
+
+	createdAt := time.Now().Unix()
+	for _, v := range data {
+		payload, err := json.Marshal(&WebhookEvent{Event: event, CreatedAt: createdAt, Data: v})
+		if err != nil {
+			return err
+		}
+
+		for _, webhook := range webhooks {
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d)", n+1, n+2, n+3))
+			args = append(args, webhook, event, string(payload))
+
+			if len(values) == webhookInsertSize {
+				if err := insert(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return insert()
+}
+
+func wakeWebhookDeliverer() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+func getWebhook(name string) *Webhook {
+	for i := range Config.Webhooks {
+		if Config.Webhooks[i].Name == name {
+			return &Config.Webhooks[i]
+		}
+	}
+	return nil
+}
+
+// signWebhook returns the signature of the body sent at the timestamp.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookRetryDelayAfter returns how long to wait before retrying a delivery
+// after the given number of failed attempts, doubling after every attempt.
+func webhookRetryDelayAfter(attempts int) time.Duration {
+	delay := webhookRetryDelay << (attempts - 1)
+	if delay <= 0 || delay > webhookMaxRetryDelay {
+		delay = webhookMaxRetryDelay
+	}
+	return delay
+}
+
+// claimDeliveries returns the deliveries that are due, counting their
+// attempt and leasing them so that the other servers skip them.
+func claimDeliveries() ([]*modext.WebhookDelivery, error) {
+	rows, err := database.Conn.Query(`UPDATE webhook_delivery
+		SET attempts = attempts + 1, next_attempt_at = NOW() + $1::INT * INTERVAL '1 second', updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM webhook_delivery
+			WHERE state = $2 AND next_attempt_at <= NOW()
+			ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, webhook, event, payload, attempts`,
+		int(webhookLease.Seconds()), DeliveryPending, webhookBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*modext.WebhookDelivery
+	for rows.Next() {
+		d := &modext.WebhookDelivery{}
+		var payload string
+		if err := rows.Scan(&d.ID, &d.Webhook, &d.Event, &payload, &d.Attempts); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// deliver posts the delivery to its webhook and records the outcome,
+// scheduling another attempt if it failed and attempts are left.
+func deliver(d *modext.WebhookDelivery) error {
+	var statusCode int
+	var deliveryErr string
+
+	if webhook := getWebhook(d.Webhook); webhook == nil {
+		deliveryErr = "Webhook is no longer configured"
+		d.Attempts = webhookMaxAttempts
+	} else {
+		statusCode, deliveryErr = postWebhook(webhook, d)
+	}
+
+	state := DeliveryDelivered
+	var delay time.Duration
+	if len(deliveryErr) > 0 {
+		if d.Attempts >= webhookMaxAttempts {
+			state = DeliveryFailed
+			log.Printf("Webhook %s failed to receive %s %d: %s\n", d.Webhook, d.Event, d.ID, deliveryErr)
+		} else {
+			state = DeliveryPending
+			delay = webhookRetryDelayAfter(d.Attempts)
+		}
+	}
+
+	_, err := database.Conn.Exec(`UPDATE webhook_delivery
+		SET state = $2, status_code = $3, error = $4,
+			next_attempt_at = NOW() + $5::INT * INTERVAL '1 second', updated_at = NOW()
+		WHERE id = $1`, d.ID, state, statusCode, deliveryErr, int(delay.Seconds()))
+	return err
+}
+
+// postWebhook posts the payload of the delivery to the webhook, returning the
+// status code of the response and why the delivery failed, if it did.
+func postWebhook(webhook *Webhook, d *modext.WebhookDelivery) (int, string) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("%s-Webhook", strings.ReplaceAll(Config.Meta.Title, " ", "")))
+	req.Header.Set(webhookEventHeader, d.Event)
+	req.Header.Set(webhookDeliveryHeader, strconv.FormatInt(d.ID, 10))
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(webhook.Secret, timestamp, d.Payload))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, res.Status
+	}
+	return res.StatusCode, ""
+}
+
+// DeliverWebhooks attempts the deliveries that are due until none are
+// left, and removes those that are older than deliveryLogDays.
+func DeliverWebhooks() error {
+	for {
+		deliveries, err := claimDeliveries()
+		if err != nil {
+			return err
+		} else if len(deliveries) == 0 {
+			break
+		}
+
+		for _, d := range deliveries {
+			if err := deliver(d); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := database.Conn.Exec(`DELETE FROM webhook_delivery
+		WHERE state <> $1 AND created_at < NOW() - $2::INT * INTERVAL '1 day'`, DeliveryPending, deliveryLogDays)
+	return err
+}
+
+// StartWebhookDeliverer delivers the webhooks at the given interval,
+// or as soon as an event is emitted by this process.
+func StartWebhookDeliverer(interval time.Duration) {
+	go func() {
+		for {
+			if err := DeliverWebhooks(); err != nil {
+				log.Println("Failed to deliver webhooks", err)
+			}
+
+			select {
+			case <-webhookWake:
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// ListWebhookDeliveries lists the latest deliveries first, optionally
+// only those of a state or of a webhook.
+func ListWebhookDeliveries(state, webhook string, limit, offset int) ([]*modext.WebhookDelivery, error) {
+	q := `SELECT id, EXTRACT(EPOCH FROM created_at)::BIGINT, EXTRACT(EPOCH FROM updated_at)::BIGINT,
+		webhook, event, payload, state, attempts, EXTRACT(EPOCH FROM next_attempt_at)::BIGINT,
+		status_code, error
+		FROM webhook_delivery WHERE ($1 = '' OR state = $1) AND ($2 = '' OR webhook = $2)
+		ORDER BY id DESC LIMIT $3 OFFSET $4`
+
+	rows, err := database.Conn.Query(q, state, webhook, limit, offset)
+	if err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	defer rows.Close()
+
+	deliveries := []*modext.WebhookDelivery{}
+	for rows.Next() {
+		d := &modext.WebhookDelivery{}
+		var payload string
+		if err := rows.Scan(&d.ID, &d.CreatedAt, &d.UpdatedAt, &d.Webhook, &d.Event, &payload,
+			&d.State, &d.Attempts, &d.NextAttemptAt, &d.StatusCode, &d.Error); err != nil {
+			log.Println(err)
+			return nil, errs.Unknown
+		}
+
+		d.Payload = json.RawMessage(payload)
+		if d.State != DeliveryPending {
+			d.NextAttemptAt = 0
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return nil, errs.Unknown
+	}
+	return deliveries, nil
+}
+
+// RetryWebhookDelivery delivers a delivery again, with all of its attempts,
+// whether it has failed or already been delivered.
+func RetryWebhookDelivery(id int64) error {
+	res, err := database.Conn.Exec(`UPDATE webhook_delivery
+		SET state = $2, attempts = 0, next_attempt_at = NOW(), status_code = 0, error = '', updated_at = NOW()
+		WHERE id = $1`, id, DeliveryPending)
+	if err != nil {
+		log.Println(err)
+		return errs.Unknown
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		log.Println(err)
+		return errs.Unknown
+	} else if n == 0 {
+		return errs.DeliveryNotFound
+	}
+
+	wakeWebhookDeliverer()
+	return nil
+}